@@ -0,0 +1,402 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+// AdminHandler tiene las rutas de moderacion. Todas van con RequireRole(store.RoleAdmin)
+type AdminHandler struct {
+	userStore    store.UserStore
+	tokenStore   store.TokenStore
+	workoutStore store.WorkoutStore
+	auditStore   store.AuditStore
+	//si no es nil se avisa al suspender, para que los JWT de la cuenta dejen de servir enseguida
+	suspensions *middleware.SuspensionCache
+	logger      *log.Logger
+}
+
+func NewAdminHandler(userStore store.UserStore, tokenStore store.TokenStore, workoutStore store.WorkoutStore, auditStore store.AuditStore, suspensions *middleware.SuspensionCache, logger *log.Logger) *AdminHandler {
+	return &AdminHandler{
+		userStore:    userStore,
+		tokenStore:   tokenStore,
+		workoutStore: workoutStore,
+		auditStore:   auditStore,
+		suspensions:  suspensions,
+		logger:       logger,
+	}
+}
+
+// HandleListUsers lista los usuarios. Acepta q (busca en username y email), role, suspended,
+// after_id (el id del ultimo usuario de la pagina anterior) y limit
+func (ah *AdminHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := store.UserFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Role:  query.Get("role"),
+	}
+
+	if filter.Role != "" && !slices.Contains(store.Roles, filter.Role) {
+		utils.WriteError(w, utils.BadRequest("role must be one of "+strings.Join(store.Roles, ", ")))
+		return
+	}
+
+	if value := query.Get("suspended"); value != "" {
+		suspended, err := strconv.ParseBool(value)
+
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest("suspended must be true or false"))
+			return
+		}
+
+		filter.Suspended = &suspended
+	}
+
+	for key, target := range map[string]*int{"after_id": &filter.AfterID, "limit": &filter.Limit} {
+		n, err := utils.ReadIntQuery(r, key)
+
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(err.Error()))
+			return
+		}
+
+		if n != nil {
+			if *n < 0 {
+				utils.WriteError(w, utils.BadRequest(key+" can't be negative"))
+				return
+			}
+			*target = *n
+		}
+	}
+
+	users, err := ah.userStore.ListUsers(r.Context(), filter)
+
+	if err != nil {
+		ah.logger.Printf("error: HandleListUsers: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"users": users})
+}
+
+type updateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// HandleUpdateRole cambia el rol de un usuario. Un admin no puede cambiar el suyo,
+// asi no se puede quedar la app sin admins por error
+func (ah *AdminHandler) HandleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ah.readTargetUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var req updateRoleRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		ah.logger.Printf("error: HandleUpdateRole: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	if !slices.Contains(store.Roles, req.Role) {
+		utils.WriteError(w, utils.BadRequest("role must be one of "+strings.Join(store.Roles, ", ")))
+		return
+	}
+
+	user := ah.loadTargetUser(w, r, userID)
+
+	if user == nil {
+		return
+	}
+
+	event := newAuditEvent(r, auditAdminRoleUpdate, store.AuditTargetUser, userID)
+	event.SetBefore(map[string]string{"role": user.Role})
+	event.SetAfter(map[string]string{"role": req.Role})
+
+	err = ah.userStore.UpdateRole(withAudit(r, event), userID, req.Role)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("user not found"))
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("error: HandleUpdateRole: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	ah.writeUser(w, r, userID)
+}
+
+// HandleSuspendUser suspende la cuenta y cierra todas sus sesiones
+func (ah *AdminHandler) HandleSuspendUser(w http.ResponseWriter, r *http.Request) {
+	ah.setSuspended(w, r, true)
+}
+
+// HandleUnsuspendUser reactiva la cuenta. Las sesiones que se cerraron no vuelven, tiene que loguearse
+func (ah *AdminHandler) HandleUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	ah.setSuspended(w, r, false)
+}
+
+func (ah *AdminHandler) setSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	userID, ok := ah.readTargetUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	user := ah.loadTargetUser(w, r, userID)
+
+	if user == nil {
+		return
+	}
+
+	action := auditAdminUnsuspend
+	if suspended {
+		action = auditAdminSuspend
+	}
+
+	event := newAuditEvent(r, action, store.AuditTargetUser, userID)
+	event.SetBefore(map[string]bool{"suspended": user.IsSuspended()})
+	event.SetAfter(map[string]bool{"suspended": suspended})
+
+	err := ah.userStore.SetSuspended(withAudit(r, event), userID, suspended)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("user not found"))
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("error: setSuspended: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if ah.suspensions != nil {
+		ah.suspensions.Set(userID, suspended)
+	}
+
+	ah.writeUser(w, r, userID)
+}
+
+// HandleRevokeUserTokens cierra todas las sesiones de un usuario. Los JWT ya firmados siguen
+// sirviendo hasta que vencen en las rutas que no cargan el usuario
+func (ah *AdminHandler) HandleRevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	//un solo evento para los tres scopes, se guarda con el primero que borra algo
+	ctx := withAudit(r, newAuditEvent(r, auditAdminRevokeTokens, store.AuditTargetUser, int(userID)))
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopeMFAPending} {
+		err := ah.tokenStore.DeleteAllTokensForUser(ctx, int(userID), scope)
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			ah.logger.Printf("error: HandleRevokeUserTokens: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "every session of the user was revoked"})
+}
+
+// HandleGetWorkout devuelve cualquier workout, sin importar su visibilidad
+func (ah *AdminHandler) HandleGetWorkout(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
+		return
+	}
+
+	workout, err := ah.workoutStore.GetWorkoutByID(r.Context(), workoutID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("error: HandleGetWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+}
+
+// HandleDeleteWorkout borra cualquier workout para siempre, para moderar contenido. No pasa por
+// la papelera, asi el dueño no lo puede restaurar
+func (ah *AdminHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
+		return
+	}
+
+	event := newAuditEvent(r, auditAdminWorkoutDelete, store.AuditTargetWorkout, int(workoutID))
+
+	err = ah.workoutStore.PurgeWorkout(withAudit(r, event), workoutID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return
+	}
+
+	if err != nil {
+		ah.logger.Printf("error: HandleDeleteWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "workout deleted"})
+}
+
+// HandleListAuditEvents busca en el registro de auditoria, los eventos mas nuevos primero. Acepta
+// actor_id, action, target_type, target_id, since y until (RFC 3339, until no incluido),
+// before_id (el id del ultimo evento de la pagina anterior) y limit
+func (ah *AdminHandler) HandleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := store.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+
+	ids := map[string]func(id int){
+		"actor_id":  func(id int) { filter.ActorID = id },
+		"target_id": func(id int) { filter.TargetID = int64(id) },
+	}
+
+	for key, set := range ids {
+		n, err := utils.ReadIntQuery(r, key)
+
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(err.Error()))
+			return
+		}
+
+		if n == nil {
+			continue
+		}
+
+		if *n < 1 {
+			utils.WriteError(w, utils.BadRequest(key+" must be positive"))
+			return
+		}
+
+		set(*n)
+	}
+
+	for key, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(key)
+
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(key+" must be a RFC 3339 date, for example 2024-01-31T15:04:05Z"))
+			return
+		}
+
+		*target = &t
+	}
+
+	var err error
+
+	filter.BeforeID, filter.Limit, err = readAuditPage(r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	events, err := ah.auditStore.GetAuditEvents(r.Context(), filter)
+
+	if err != nil {
+		ah.logger.Printf("error: HandleListAuditEvents: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"events": events})
+}
+
+// readTargetUser lee el id del usuario de la ruta. Los admins no pueden cambiarse el rol
+// ni suspenderse a si mismos
+func (ah *AdminHandler) readTargetUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return 0, false
+	}
+
+	if int(userID) == middleware.GetUser(r).ID {
+		utils.WriteError(w, utils.Forbidden("you can't change your own account from the admin api"))
+		return 0, false
+	}
+
+	return int(userID), true
+}
+
+// loadTargetUser busca el usuario antes de cambiarlo, para guardar en el evento como estaba.
+// Si no existe ya respondio y devuelve nil
+func (ah *AdminHandler) loadTargetUser(w http.ResponseWriter, r *http.Request, userID int) *store.User {
+	user, err := ah.userStore.GetUserByID(r.Context(), userID)
+
+	if err != nil {
+		ah.logger.Printf("error: loading user %d: %v", userID, err)
+		utils.WriteError(w, utils.InternalError())
+		return nil
+	}
+
+	if user == nil {
+		utils.WriteError(w, utils.NotFound("user not found"))
+		return nil
+	}
+
+	return user
+}
+
+// writeUser responde con el usuario actualizado
+func (ah *AdminHandler) writeUser(w http.ResponseWriter, r *http.Request, userID int) {
+	user, err := ah.userStore.GetUserByID(r.Context(), userID)
+
+	if err != nil || user == nil {
+		ah.logger.Printf("error: reloading user %d: %v", userID, err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
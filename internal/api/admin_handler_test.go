@@ -0,0 +1,229 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setRole le cambia el rol a un usuario directo en el store y devuelve su id.
+// El primer admin no se puede crear por la api
+func setRole(t *testing.T, server *testServer, username, role string) int {
+	t.Helper()
+
+	user, err := server.users.GetUserByUsername(context.Background(), username)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	require.NoError(t, server.users.UpdateRole(context.Background(), user.ID, role))
+
+	return user.ID
+}
+
+func TestAdminUsers(t *testing.T) {
+	server := newTestServer(t)
+	admin := registerAndLogin(t, server, "admin")
+	adminID := setRole(t, server, "admin", store.RoleAdmin)
+	user := registerAndLogin(t, server, "joaquin")
+	register(t, server, "maria")
+
+	//solo los admins pueden usar la api de admin
+	status, _ := doRequest(t, server, http.MethodGet, "/admin/users", user, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/users", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, body := doRequest(t, server, http.MethodGet, "/admin/users", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["users"], 3)
+
+	status, body = doRequest(t, server, http.MethodGet, "/admin/users?q=JOAQ", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	users := body["users"].([]any)
+	require.Len(t, users, 1)
+	joaquin := users[0].(map[string]any)
+	assert.Equal(t, "user", joaquin["role"])
+	path := fmt.Sprintf("/admin/users/%v", joaquin["id"])
+
+	status, body = doRequest(t, server, http.MethodGet, "/admin/users?role=admin", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["users"], 1)
+
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/admin/users?after_id=%d&limit=1", adminID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "joaquin", body["users"].([]any)[0].(map[string]any)["username"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/users?role=owner", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/users?suspended=maybe", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body = doRequest(t, server, http.MethodPut, path+"/role", admin, map[string]any{"role": "coach"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "coach", body["user"].(map[string]any)["role"])
+	status, _ = doRequest(t, server, http.MethodPut, path+"/role", admin, map[string]any{"role": "owner"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = doRequest(t, server, http.MethodPut, "/admin/users/999/role", admin, map[string]any{"role": "coach"})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	//un admin no se puede sacar el rol ni suspender a si mismo
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/admin/users/%d/role", adminID), admin, map[string]any{"role": "user"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/admin/users/%d/suspension", adminID), admin, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	//suspender cierra las sesiones y no deja volver a loguearse
+	status, body = doRequest(t, server, http.MethodPut, path+"/suspension", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotNil(t, body["user"].(map[string]any)["suspendedAt"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", user, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "account_suspended", apiError(t, body)["code"])
+	assert.Equal(t, "your account is suspended", apiError(t, body)["message"])
+
+	status, body = doRequest(t, server, http.MethodGet, "/admin/users?suspended=true", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["users"], 1)
+
+	status, _ = doRequest(t, server, http.MethodDelete, path+"/suspension", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	user = login(t, server, "joaquin")
+
+	//revocar los tokens cierra las sesiones sin suspender la cuenta
+	status, _ = doRequest(t, server, http.MethodDelete, path+"/tokens", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", user, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	login(t, server, "joaquin")
+}
+
+func TestAdminWorkouts(t *testing.T) {
+	server := newTestServer(t)
+	admin := registerAndLogin(t, server, "admin")
+	setRole(t, server, "admin", store.RoleAdmin)
+	owner := registerAndLogin(t, server, "owner")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{"title": "private"})
+	require.Equal(t, http.StatusOK, status)
+	id := body["workout"].(map[string]any)["id"]
+
+	//el admin ve los workouts privados por la api de admin, no por la comun
+	status, _ = doRequest(t, server, http.MethodGet, fmt.Sprintf("/workouts/%v", id), admin, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/admin/workouts/%v", id), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "private", body["workout"].(map[string]any)["title"])
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/admin/workouts/%v", id), owner, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/admin/workouts/%v", id), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, fmt.Sprintf("/workouts/%v", id), owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/admin/workouts/%v", id), admin, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAdminAuditEvents(t *testing.T) {
+	server := newTestServer(t)
+	admin := registerAndLogin(t, server, "admin")
+	adminID := setRole(t, server, "admin", store.RoleAdmin)
+	user := registerAndLogin(t, server, "joaquin")
+	joaquin, err := server.users.GetUserByUsername(context.Background(), "joaquin")
+	require.NoError(t, err)
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", user, map[string]any{"title": "legs"})
+	require.Equal(t, http.StatusOK, status)
+	workoutID := body["workout"].(map[string]any)["id"]
+
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/audit", user, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	//la verificacion del email y el login no tienen usuario logueado, el actor es el usuario de la cuenta.
+	//El registro queda sin actor
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/admin/audit?actor_id=%d", joaquin.ID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	events := body["events"].([]any)
+	actions := make([]any, len(events))
+	for i, e := range events {
+		actions[i] = e.(map[string]any)["action"]
+	}
+	assert.Equal(t, []any{"workout.create", "session.create", "user.verify_email"}, actions)
+	assert.Equal(t, "127.0.0.1", events[0].(map[string]any)["ip"])
+
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/admin/audit?target_type=workout&target_id=%v", workoutID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body["events"], 1)
+
+	status, body = doRequest(t, server, http.MethodGet, "/admin/audit?action=user.register", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["events"], 2)
+
+	status, body = doRequest(t, server, http.MethodGet, "/admin/audit?since=2999-01-01T00:00:00Z", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body["events"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/audit?since=yesterday", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/audit?actor_id=-1", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	//las acciones de los admins sobre las cuentas y los workouts tambien quedan registradas
+	userPath := fmt.Sprintf("/admin/users/%d", joaquin.ID)
+	status, _ = doRequest(t, server, http.MethodPut, userPath+"/role", admin, map[string]any{"role": "coach"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPut, userPath+"/suspension", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodDelete, userPath+"/suspension", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	login(t, server, "joaquin")
+	status, _ = doRequest(t, server, http.MethodDelete, userPath+"/tokens", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/admin/workouts/%v", workoutID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/admin/audit?actor_id=%d", adminID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	events = body["events"].([]any)
+	actions = make([]any, len(events))
+	for i, e := range events {
+		actions[i] = e.(map[string]any)["action"]
+	}
+	assert.Equal(t, []any{"admin.workout_delete", "admin.revoke_tokens", "admin.unsuspend", "admin.suspend", "admin.role_update", "session.create", "user.verify_email"}, actions)
+	assert.Equal(t, map[string]any{"role": map[string]any{"before": "user", "after": "coach"}}, events[4].(map[string]any)["changes"])
+	assert.Equal(t, map[string]any{"suspended": map[string]any{"before": false, "after": true}}, events[3].(map[string]any)["changes"])
+}
+
+func TestAdminWithJWT(t *testing.T) {
+	server := newJWTTestServer(t)
+	admin := registerAndLogin(t, server, "admin")
+	adminID := setRole(t, server, "admin", store.RoleAdmin)
+
+	//el token se firmo cuando todavia no era admin, pero RequireRole lee el rol de la db
+	status, _ := doRequest(t, server, http.MethodGet, "/admin/users", admin, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	user := registerAndLogin(t, server, "joaquin")
+	userID := setRole(t, server, "joaquin", store.RoleUser)
+
+	//el JWT sigue sin vencer, pero suspender la cuenta lo rechaza enseguida aunque ya se hubiera
+	//guardado en el cache que no estaba suspendida
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", user, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/admin/users/%d/suspension", userID), admin, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, body := doRequest(t, server, http.MethodGet, "/workouts", user, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "account_suspended", apiError(t, body)["code"])
+
+	require.NoError(t, server.users.UpdateRole(context.Background(), adminID, store.RoleUser))
+	status, _ = doRequest(t, server, http.MethodGet, "/admin/users", admin, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+}
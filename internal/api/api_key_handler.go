@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+type APIKeyHandler struct {
+	apiKeyStore store.APIKeyStore
+	logger      *log.Logger
+}
+
+func NewAPIKeyHandler(apiKeyStore store.APIKeyStore, logger *log.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyStore: apiKeyStore,
+		logger:      logger,
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name string `json:"name"`
+	//workouts:read (por defecto) o workouts:write
+	Scope string `json:"scope"`
+	//opcional, sin expiry la key no vence
+	Expiry *time.Time `json:"expiry"`
+}
+
+const maxAPIKeyNameLength = 100
+
+// HandleCreateAPIKey crea una API key para el usuario logueado. La key se devuelve solo en esta respuesta
+func (h *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		h.logger.Printf("error: create api key: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		utils.WriteError(w, utils.BadRequest("name is required and can't be longer than 100 characters"))
+		return
+	}
+
+	if req.Scope == "" {
+		req.Scope = store.APIKeyScopeRead
+	}
+
+	if req.Scope != store.APIKeyScopeRead && req.Scope != store.APIKeyScopeWrite {
+		utils.WriteError(w, utils.BadRequest("scope must be "+store.APIKeyScopeRead+" or "+store.APIKeyScopeWrite))
+		return
+	}
+
+	if req.Expiry != nil && !req.Expiry.After(time.Now()) {
+		utils.WriteError(w, utils.BadRequest("expiry must be in the future"))
+		return
+	}
+
+	plaintext, err := tokens.GenerateAPIKey()
+
+	if err != nil {
+		h.logger.Printf("error: create api key: generating key: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	key := &store.APIKey{
+		UserID:    middleware.GetUser(r).ID,
+		Name:      req.Name,
+		Plaintext: plaintext,
+		Hash:      tokens.Hash(plaintext),
+		Scope:     req.Scope,
+		Expiry:    req.Expiry,
+	}
+
+	err = h.apiKeyStore.CreateAPIKey(r.Context(), key)
+
+	if err != nil {
+		h.logger.Printf("error: create api key: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"api_key": key})
+}
+
+// HandleListAPIKeys lista las keys del usuario logueado, sin el texto de las keys
+func (h *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeyStore.GetAPIKeysForUser(r.Context(), middleware.GetUser(r).ID)
+
+	if err != nil {
+		h.logger.Printf("error: list api keys: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"api_keys": keys})
+}
+
+// HandleRevokeAPIKey borra una key del usuario logueado, deja de servir en el momento
+func (h *APIKeyHandler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: revoke api key: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid api key id"))
+		return
+	}
+
+	err = h.apiKeyStore.DeleteAPIKey(r.Context(), middleware.GetUser(r).ID, int(keyID))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("api key not found"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: revoke api key: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "api key revoked"})
+}
@@ -0,0 +1,97 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createAPIKey crea una API key con ese scope y devuelve su texto y su id
+func createAPIKey(t *testing.T, server *testServer, token, scope string) (string, float64) {
+	t.Helper()
+
+	status, body := doRequest(t, server, http.MethodPost, "/users/me/api-keys", token, map[string]any{"name": "script " + scope, "scope": scope})
+	require.Equal(t, http.StatusCreated, status)
+
+	key := body["api_key"].(map[string]any)
+	plaintext := key["key"].(string)
+	require.True(t, strings.HasPrefix(plaintext, "wapi_"))
+
+	return plaintext, key["id"].(float64)
+}
+
+func TestAPIKeys(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	readKey, readKeyID := createAPIKey(t, server, token, "workouts:read")
+	writeKey, _ := createAPIKey(t, server, token, "workouts:write")
+
+	//la key de escritura puede crear y leer workouts
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", writeKey, map[string]any{"title": "push day", "duration_minutes": 60})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	status, _ = doRequest(t, server, http.MethodGet, path, writeKey, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	//la de lectura solo puede leer
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", readKey, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodDelete, path, readKey, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	//ninguna sirve fuera de los workouts, por ej. para crear mas keys
+	status, _ = doRequest(t, server, http.MethodPost, "/users/me/api-keys", writeKey, map[string]any{"name": "another"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", writeKey, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, body = doRequest(t, server, http.MethodGet, "/users/me/api-keys", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	keys := body["api_keys"].([]any)
+	require.Len(t, keys, 2)
+	for _, k := range keys {
+		assert.Nil(t, k.(map[string]any)["key"], "the key is only shown when it's created")
+	}
+	//la key de lectura ya se uso
+	readKeyInfo := keys[1].(map[string]any)
+	assert.Equal(t, readKeyID, readKeyInfo["id"])
+	assert.NotNil(t, readKeyInfo["last_used_at"])
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/users/me/api-keys/%v", readKeyID), token, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", readKey, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//las keys de otro usuario no se pueden revocar
+	other := registerAndLogin(t, server, "other")
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/users/me/api-keys/%v", readKeyID+1), other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	cases := []map[string]any{
+		{"scope": "workouts:read"},
+		{"name": "script", "scope": "admin"},
+		{"name": "script", "expiry": time.Now().Add(-time.Hour)},
+	}
+
+	for _, c := range cases {
+		status, _ := doRequest(t, server, http.MethodPost, "/users/me/api-keys", token, c)
+		assert.Equal(t, http.StatusBadRequest, status, "%v", c)
+	}
+
+	//sin scope es de solo lectura
+	status, body := doRequest(t, server, http.MethodPost, "/users/me/api-keys", token, map[string]any{"name": "script", "expiry": time.Now().Add(time.Hour)})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "workouts:read", body["api_key"].(map[string]any)["scope"])
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+// acciones del registro de auditoria. Cada cambio que hacen WorkoutHandler, UserHandler,
+// TokenHandler y AdminHandler guarda un evento con una de estas
+const (
+	auditWorkoutCreate        = "workout.create"
+	auditWorkoutUpdate        = "workout.update"
+	auditWorkoutDelete        = "workout.delete"
+	auditWorkoutRestore       = "workout.restore"
+	auditWorkoutEntryCreate   = "workout.entry_create"
+	auditWorkoutEntryUpdate   = "workout.entry_update"
+	auditWorkoutEntryDelete   = "workout.entry_delete"
+	auditWorkoutEntriesOrder  = "workout.entries_reorder"
+	auditWorkoutNoteCreate    = "workout.note_create"
+	auditUserRegister         = "user.register"
+	auditUserVerifyEmail      = "user.verify_email"
+	auditUserUpdate           = "user.update"
+	auditUserDelete           = "user.delete"
+	auditUserPasswordChange   = "user.password_change"
+	auditUserPasswordReset    = "user.password_reset"
+	auditUser2FASetup         = "user.2fa_setup"
+	auditUser2FAEnable        = "user.2fa_enable"
+	auditUser2FADisable       = "user.2fa_disable"
+	auditUserRecoveryCodeUse  = "user.recovery_code_use"
+	auditUserFollow           = "user.follow"
+	auditUserUnfollow         = "user.unfollow"
+	auditUserFollowerRemove   = "user.follower_remove"
+	auditSessionCreate        = "session.create"
+	auditSessionMFAPending    = "session.mfa_pending"
+	auditSessionRefresh       = "session.refresh"
+	auditSessionRevoke        = "session.revoke"
+	auditSessionRevokeAll     = "session.revoke_all"
+	auditPasswordResetRequest = "user.password_reset_request"
+	auditActivationRequest    = "user.activation_request"
+	auditAdminRoleUpdate      = "admin.role_update"
+	auditAdminSuspend         = "admin.suspend"
+	auditAdminUnsuspend       = "admin.unsuspend"
+	auditAdminRevokeTokens    = "admin.revoke_tokens"
+	auditAdminWorkoutDelete   = "admin.workout_delete"
+)
+
+// newAuditEvent arma el evento de un cambio hecho en el request r. El actor es el usuario logueado,
+// en las rutas sin usuario (login, registro, reset de password) lo completa el handler si lo sabe.
+// targetID puede ser 0 si el store lo va a saber recien al guardar (por ej al crear)
+func newAuditEvent(r *http.Request, action, targetType string, targetID int) *store.AuditEvent {
+	event := &store.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   int64(targetID),
+		RequestID:  auditRequestID(r),
+		IP:         clientIP(r),
+	}
+
+	//en las rutas publicas no pasa el middleware Authenticate, asi que no hay usuario en el context
+	if user, ok := r.Context().Value(middleware.UserContextKey).(*store.User); ok && !user.IsAnonymous() {
+		actorID := user.ID
+		event.ActorID = &actorID
+	}
+
+	return event
+}
+
+// maxRequestIDLength es el largo maximo de un request id que se guarda tal cual (la columna es VARCHAR(255))
+const maxRequestIDLength = 128
+
+// auditRequestID es el request id de r para el evento. chimiddleware.RequestID usa el X-Request-Id
+// del cliente sin chequearlo, y uno muy largo o con caracteres raros haria fallar el INSERT del evento,
+// y con el el cambio. Esos se reemplazan por uno generado aca
+func auditRequestID(r *http.Request) string {
+	id := chimiddleware.GetReqID(r.Context())
+
+	if len(id) <= maxRequestIDLength && printableASCII(id) {
+		return id
+	}
+
+	b := make([]byte, 12)
+
+	if _, err := rand.Read(b); err != nil {
+		return "server-" + strconv.FormatUint(chimiddleware.NextRequestID(), 10)
+	}
+
+	return "server-" + hex.EncodeToString(b)
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withAudit es el context de r con event: el store que hace el cambio lo guarda en su transaccion
+func withAudit(r *http.Request, event *store.AuditEvent) context.Context {
+	return store.WithAuditEvent(r.Context(), event)
+}
+
+// readAuditPage lee la paginacion de los listados de eventos: before_id (el id del ultimo evento
+// de la pagina anterior) y limit. Los que no vienen quedan en 0
+func readAuditPage(r *http.Request) (beforeID int64, limit int, err error) {
+	n, err := utils.ReadIntQuery(r, "before_id")
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if n != nil {
+		if *n < 1 {
+			return 0, 0, errors.New("before_id must be positive")
+		}
+		beforeID = int64(*n)
+	}
+
+	n, err = utils.ReadIntQuery(r, "limit")
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if n != nil {
+		if *n < 1 || *n > store.MaxAuditLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", store.MaxAuditLimit)
+		}
+		limit = *n
+	}
+
+	return beforeID, limit, nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+// CoachingHandler maneja las relaciones coach-atleta: el coach invita, el atleta acepta,
+// y cualquiera de los dos la puede terminar. Lo que puede hacer un coach con los workouts
+// de sus atletas lo decide WorkoutHandler.canEdit
+type CoachingHandler struct {
+	coachingStore store.CoachingStore
+	logger        *log.Logger
+}
+
+func NewCoachingHandler(coachingStore store.CoachingStore, logger *log.Logger) *CoachingHandler {
+	return &CoachingHandler{
+		coachingStore: coachingStore,
+		logger:        logger,
+	}
+}
+
+// HandleListCoachings devuelve las relaciones del usuario logueado, como coach y como atleta
+func (h *CoachingHandler) HandleListCoachings(w http.ResponseWriter, r *http.Request) {
+	coachings, err := h.coachingStore.GetCoachings(r.Context(), middleware.GetUser(r).ID)
+
+	if err != nil {
+		h.logger.Printf("error: list coachings: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"coachings": coachings})
+}
+
+// HandleInviteAthlete invita al usuario {id} a ser atleta del coach logueado
+func (h *CoachingHandler) HandleInviteAthlete(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: invite athlete: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	coach := middleware.GetUser(r)
+
+	if coach.ID == int(athleteID) {
+		utils.WriteError(w, utils.BadRequest("you can't coach yourself"))
+		return
+	}
+
+	err = h.coachingStore.InviteAthlete(r.Context(), coach.ID, int(athleteID))
+
+	if apiErr := storeError(err, "user not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: invite athlete: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "athlete invited"})
+}
+
+// HandleRemoveAthlete cancela la invitacion o deja de entrenar al atleta {id}
+func (h *CoachingHandler) HandleRemoveAthlete(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: remove athlete: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	h.deleteCoaching(w, r, middleware.GetUser(r).ID, int(athleteID))
+}
+
+// HandleAcceptCoach acepta la invitacion del coach {id}. Desde ahi el coach puede ver, crear
+// y modificar los workouts del atleta y dejarles notas
+func (h *CoachingHandler) HandleAcceptCoach(w http.ResponseWriter, r *http.Request) {
+	coachID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: accept coach: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	err = h.coachingStore.AcceptInvite(r.Context(), int(coachID), middleware.GetUser(r).ID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("there is no pending invitation from this coach"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: accept coach: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "coach accepted"})
+}
+
+// HandleRemoveCoach rechaza la invitacion o le revoca el acceso al coach {id}, en cualquier momento
+func (h *CoachingHandler) HandleRemoveCoach(w http.ResponseWriter, r *http.Request) {
+	coachID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: remove coach: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	h.deleteCoaching(w, r, int(coachID), middleware.GetUser(r).ID)
+}
+
+func (h *CoachingHandler) deleteCoaching(w http.ResponseWriter, r *http.Request, coachID, athleteID int) {
+	err := h.coachingStore.DeleteCoaching(r.Context(), coachID, athleteID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("coaching relationship not found"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: delete coaching: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "coaching relationship removed"})
+}
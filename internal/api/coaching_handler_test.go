@@ -0,0 +1,119 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoaching(t *testing.T) {
+	server := newTestServer(t)
+	coach := registerAndLogin(t, server, "coach")
+	coachID := setRole(t, server, "coach", store.RoleCoach)
+	athlete := registerAndLogin(t, server, "athlete")
+	athleteID := setRole(t, server, "athlete", store.RoleUser)
+	stranger := registerAndLogin(t, server, "stranger")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", athlete, map[string]any{"title": "private"})
+	require.Equal(t, http.StatusOK, status)
+	workoutPath := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	//solo los coaches pueden invitar
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/coaching/athletes/%d", coachID), stranger, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodPut, "/coaching/athletes/999", coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/coaching/athletes/%d", athleteID), coach, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	//mientras la invitacion esta pendiente el coach no tiene acceso
+	status, _ = doRequest(t, server, http.MethodGet, workoutPath, coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodPost, "/workouts", coach, map[string]any{"title": "for you", "user_id": athleteID})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, body = doRequest(t, server, http.MethodGet, "/coaching", athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	coachings := body["coachings"].([]any)
+	require.Len(t, coachings, 1)
+	assert.Equal(t, "coach", coachings[0].(map[string]any)["coach_username"])
+	assert.Nil(t, coachings[0].(map[string]any)["accepted_at"])
+
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/coaching/coaches/%d", coachID), athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/coaching/coaches/%d", coachID), stranger, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	//ya aceptado, el coach lee el historial, crea y modifica workouts del atleta y deja notas
+	status, _ = doRequest(t, server, http.MethodGet, workoutPath, coach, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/workouts?user_id=%d", athleteID), coach, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["workouts"], 1)
+
+	//el link para compartir es del atleta, el coach no lo ve aunque lo cree el
+	status, body = doRequest(t, server, http.MethodPost, "/workouts", coach, map[string]any{"title": "for you", "user_id": athleteID, "visibility": "public_link"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(athleteID), body["workout"].(map[string]any)["user_id"])
+	assert.Nil(t, body["workout"].(map[string]any)["share_token"])
+
+	status, body = doRequest(t, server, http.MethodPut, workoutPath, coach, map[string]any{"title": "edited by coach", "visibility": "public_link"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, body["workout"].(map[string]any)["share_token"])
+
+	status, body = doRequest(t, server, http.MethodGet, workoutPath, athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, body["workout"].(map[string]any)["share_token"])
+
+	//borrar y restaurar es solo del atleta
+	status, _ = doRequest(t, server, http.MethodDelete, workoutPath, coach, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodDelete, workoutPath, athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/restore", coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/restore", athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/notes", coach, map[string]any{"body": "  "})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/notes", coach, map[string]any{"body": "more rest between sets"})
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/notes", stranger, map[string]any{"body": "spam"})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, body = doRequest(t, server, http.MethodGet, workoutPath+"/notes", athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	notes := body["notes"].([]any)
+	require.Len(t, notes, 1)
+	assert.Equal(t, float64(coachID), notes[0].(map[string]any)["author_id"])
+
+	//el atleta no puede tocar los workouts del coach
+	status, _ = doRequest(t, server, http.MethodPost, "/workouts", athlete, map[string]any{"title": "x", "user_id": coachID})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	//sacarle el rol de coach le quita el acceso, aunque el atleta no lo haya revocado
+	require.NoError(t, server.users.UpdateRole(context.Background(), coachID, store.RoleUser))
+	status, _ = doRequest(t, server, http.MethodGet, workoutPath, coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodPost, workoutPath+"/notes", coach, map[string]any{"body": "still here"})
+	assert.Equal(t, http.StatusNotFound, status)
+	require.NoError(t, server.users.UpdateRole(context.Background(), coachID, store.RoleCoach))
+
+	//y puede revocar el acceso en cualquier momento
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/coaching/coaches/%d", coachID), athlete, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, workoutPath, coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodPut, workoutPath, coach, map[string]any{"title": "x"})
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodGet, workoutPath+"/notes", coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/coaching/athletes/%d", athleteID), coach, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
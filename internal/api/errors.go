@@ -0,0 +1,37 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+// storeError traduce los errores de los stores que son culpa del cliente: sql.ErrNoRows es un 404
+// con el mensaje notFound, las violaciones de UNIQUE un 409 y las de CHECK o de foreign key un 422,
+// igual que los ids de entries invalidos. Devuelve nil para cualquier otro error, que es un 500 y hay que loguearlo
+func storeError(err error, notFound string) *utils.APIError {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return utils.NotFound(notFound)
+	case errors.Is(err, store.ErrDuplicateUsername):
+		return utils.Conflict(err.Error()).WithDetails(map[string]string{"username": "is already taken"})
+	case errors.Is(err, store.ErrDuplicateEmail):
+		return utils.Conflict(err.Error()).WithDetails(map[string]string{"email": "is already in use"})
+	case errors.Is(err, store.ErrEditConflict):
+		//el workout cambio entre que el handler lo leyo y lo guardo
+		return utils.NewAPIError(http.StatusConflict, utils.ErrCodeEditConflict, "the workout was modified by another request, read it again and retry")
+	case errors.Is(err, store.ErrUniqueViolation):
+		return utils.Conflict("it conflicts with an existing resource")
+	case errors.Is(err, store.ErrInvalidEntryID):
+		return utils.ValidationFailed(err.Error(), map[string]string{"entries": "has an unknown or repeated entry id"})
+	case errors.Is(err, store.ErrInvalidEntryOrder):
+		return utils.ValidationFailed(err.Error(), map[string]string{"entry_ids": "must have every entry of the workout exactly once"})
+	case errors.Is(err, store.ErrCheckViolation), errors.Is(err, store.ErrForeignKeyViolation):
+		return utils.ValidationFailed("the request has invalid values", nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+)
+
+// workoutETag es el ETag de un workout: su version, que sube en cada cambio del workout o de sus entries
+func workoutETag(workout *store.Workout) string {
+	return `"` + strconv.Itoa(workout.Version) + `"`
+}
+
+func setWorkoutETag(w http.ResponseWriter, workout *store.Workout) {
+	w.Header().Set("ETag", workoutETag(workout))
+}
+
+// etagMatches dice si alguno de los ETags de un If-Match o If-None-Match (separados por coma, o "*")
+// es etag. If-Match compara en forma estricta: un ETag debil (W/"...") nunca coincide
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch chequea el If-Match del request contra la version del workout que se va a modificar.
+// Sin If-Match no hay nada que chequear. Si no coincide responde 412 y devuelve false
+func checkIfMatch(w http.ResponseWriter, r *http.Request, workout *store.Workout) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" || etagMatches(header, workoutETag(workout), false) {
+		return true
+	}
+
+	setWorkoutETag(w, workout)
+	utils.WriteError(w, utils.PreconditionFailed("the workout was modified since it was read"))
+
+	return false
+}
+
+// notModified responde 304 si el If-None-Match del request ya tiene la version actual del workout
+func notModified(w http.ResponseWriter, r *http.Request, workout *store.Workout) bool {
+	header := r.Header.Get("If-None-Match")
+
+	if header == "" || !etagMatches(header, workoutETag(workout), true) {
+		return false
+	}
+
+	setWorkoutETag(w, workout)
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
@@ -0,0 +1,232 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/api"
+	"github.com/joaquinbian/workout-api-go/internal/app"
+	"github.com/joaquinbian/workout-api-go/internal/config"
+	"github.com/joaquinbian/workout-api-go/internal/mailer"
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/routes"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	//bcrypt con el costo por defecto hace que cada registro/login tarde cientos de ms
+	store.SetBcryptCost(bcrypt.MinCost)
+	os.Exit(m.Run())
+}
+
+// testMailer guarda los mails en vez de mandarlos, para que los tests lean los tokens
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// messages devuelve los mails mandados a to con ese asunto, en orden
+func (m *testMailer) messages(to, subject string) []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []mailer.Message
+	for _, msg := range m.sent {
+		if msg.To == to && msg.Subject == subject {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// los tokens son 32 bytes en base32 hex sin padding
+var tokenRegex = regexp.MustCompile(`[0-9A-V]{52}`)
+
+// lastToken devuelve el token del ultimo mail mandado a to con ese asunto
+func (m *testMailer) lastToken(t *testing.T, to, subject string) string {
+	t.Helper()
+
+	sent := m.messages(to, subject)
+	require.NotEmpty(t, sent, "no mail %q sent to %s", subject, to)
+
+	token := tokenRegex.FindString(sent[len(sent)-1].Body)
+	require.NotEmpty(t, token)
+	return token
+}
+
+// testServer es el servidor de la app junto con los mails que fue mandando
+type testServer struct {
+	*httptest.Server
+	mails *testMailer
+	//para preparar datos que no se pueden crear por la api, como los admins
+	users store.UserStore
+}
+
+// newTestServer levanta todas las rutas de la app sobre los stores en memoria,
+// asi los handlers se prueban de punta a punta sin postgres
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	return startTestServer(t, nil)
+}
+
+// newJWTTestServer es newTestServer con los tokens de auth en formato JWT
+func newJWTTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	jwtManager, err := tokens.NewJWTManager(tokens.JWTAlgorithmEdDSA, "test:"+base64.StdEncoding.EncodeToString(make([]byte, 32)), "", "workout-api")
+	require.NoError(t, err)
+
+	return startTestServer(t, jwtManager)
+}
+
+func startTestServer(t *testing.T, jwtManager *tokens.JWTManager) *testServer {
+	t.Helper()
+
+	db := store.NewMemoryDB()
+	workoutStore := store.NewMemoryWorkoutStore(db)
+	userStore := store.NewMemoryUserStore(db)
+	tokenStore := store.NewMemoryTokenStore(db)
+	loginStore := store.NewMemoryLoginAttemptStore(db)
+	apiKeyStore := store.NewMemoryAPIKeyStore(db)
+	coachingStore := store.NewMemoryCoachingStore(db)
+	auditStore := store.NewMemoryAuditStore(db)
+	suspensions := middleware.NewSuspensionCache(userStore, middleware.SuspensionTTL)
+	logger := log.New(io.Discard, "", 0)
+	mails := &testMailer{}
+	//todos los tests se conectan desde 127.0.0.1, el limite por IP es alto para que no se pisen
+	loginLimits := config.LoginConfig{MaxAttempts: 3, MaxAttemptsPerIP: 100, Lockout: time.Minute, MaxLockout: time.Hour}
+	ttls := config.TokensConfig{AuthTTL: time.Hour, RefreshTTL: 24 * time.Hour, PasswordResetTTL: 30 * time.Minute, ActivationTTL: time.Hour, MFATTL: 5 * time.Minute}
+
+	application := &app.Application{
+		Logger:          logger,
+		WorkoutHandler:  api.NewWorkoutHandler(workoutStore, userStore, coachingStore, auditStore, logger),
+		UserHandler:     api.NewUserHandler(userStore, tokenStore, ttls, mails, logger),
+		TokenHandler:    api.NewTokenHander(tokenStore, userStore, loginStore, ttls, loginLimits, jwtManager, mails, logger),
+		APIKeyHandler:   api.NewAPIKeyHandler(apiKeyStore, logger),
+		AdminHandler:    api.NewAdminHandler(userStore, tokenStore, workoutStore, auditStore, suspensions, logger),
+		CoachingHandler: api.NewCoachingHandler(coachingStore, logger),
+		Middleware:      middleware.UserMiddleware{UserStore: userStore, APIKeyStore: apiKeyStore, JWT: jwtManager, Suspensions: suspensions},
+	}
+
+	server := httptest.NewServer(routes.SetupRoutes(application))
+	t.Cleanup(server.Close)
+
+	return &testServer{Server: server, mails: mails, users: userStore}
+}
+
+// doRequest manda body como JSON (si no es nil) y decodea la respuesta en un map
+func doRequest(t *testing.T, server *testServer, method, path, token string, body any) (int, map[string]any) {
+	t.Helper()
+
+	status, _, decoded := doRequestWithHeaders(t, server, method, path, token, nil, body)
+	return status, decoded
+}
+
+// doRequestWithHeaders es doRequest con headers extra en el request, y devuelve tambien los de la respuesta
+func doRequestWithHeaders(t *testing.T, server *testServer, method, path, token string, headers map[string]string, body any) (int, http.Header, map[string]any) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		require.NoError(t, err)
+		reqBody = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, server.URL+path, reqBody)
+	require.NoError(t, err)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var decoded map[string]any
+	err = json.NewDecoder(res.Body).Decode(&decoded)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+
+	return res.StatusCode, res.Header, decoded
+}
+
+// registerAndLogin crea un usuario, verifica su email y devuelve un token de autenticacion para el
+func registerAndLogin(t *testing.T, server *testServer, username string) string {
+	t.Helper()
+
+	register(t, server, username)
+
+	token := server.mails.lastToken(t, username+"@mail.com", "Verify your email")
+	status, _ := doRequest(t, server, http.MethodPut, "/users/activated", "", map[string]any{"token": token})
+	require.Equal(t, http.StatusOK, status)
+
+	return login(t, server, username)
+}
+
+// register crea un usuario sin verificar su email
+func register(t *testing.T, server *testServer, username string) {
+	t.Helper()
+
+	status, _ := doRequest(t, server, http.MethodPost, "/users", "", map[string]any{
+		"username": username,
+		"email":    username + "@mail.com",
+		"password": "supersecret",
+	})
+	require.Equal(t, http.StatusCreated, status)
+}
+
+// login abre una sesion nueva para un usuario ya registrado
+func login(t *testing.T, server *testServer, username string) string {
+	t.Helper()
+
+	status, body := doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{
+		"username": username,
+		"password": "supersecret",
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	token, ok := body["token"].(map[string]any)
+	require.True(t, ok)
+
+	return token["token"].(string)
+}
+
+// apiError devuelve el objeto "error" de una respuesta de error
+func apiError(t *testing.T, body map[string]any) map[string]any {
+	t.Helper()
+
+	apiErr, ok := body["error"].(map[string]any)
+	require.True(t, ok, "the response has no error object: %v", body)
+
+	return apiErr
+}
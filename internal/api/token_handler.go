@@ -1,20 +1,36 @@
 package api
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/joaquinbian/workout-api-go/internal/config"
+	"github.com/joaquinbian/workout-api-go/internal/mailer"
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
 	"github.com/joaquinbian/workout-api-go/internal/store"
 	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/joaquinbian/workout-api-go/internal/totp"
 	"github.com/joaquinbian/workout-api-go/internal/utils"
 )
 
 type TokenHandler struct {
-	tokenStore store.TokenStore
-	userStore  store.UserStore
-	logger     *log.Logger
+	tokenStore        store.TokenStore
+	userStore         store.UserStore
+	loginAttemptStore store.LoginAttemptStore
+	ttls              config.TokensConfig
+	login             config.LoginConfig
+	jwt               *tokens.JWTManager //nil si los tokens de auth son opacos
+	mailer            mailer.Mailer
+	logger            *log.Logger
 }
 
 type createTokenRequest struct {
@@ -22,11 +38,16 @@ type createTokenRequest struct {
 	Password string `json:"password"`
 }
 
-func NewTokenHander(ts store.TokenStore, us store.UserStore, logger *log.Logger) *TokenHandler {
+func NewTokenHander(ts store.TokenStore, us store.UserStore, las store.LoginAttemptStore, ttls config.TokensConfig, login config.LoginConfig, jwt *tokens.JWTManager, m mailer.Mailer, logger *log.Logger) *TokenHandler {
 	return &TokenHandler{
-		tokenStore: ts,
-		userStore:  us,
-		logger:     logger,
+		tokenStore:        ts,
+		userStore:         us,
+		loginAttemptStore: las,
+		ttls:              ttls,
+		login:             login,
+		jwt:               jwt,
+		mailer:            m,
+		logger:            logger,
 	}
 }
 
@@ -37,39 +58,640 @@ func (th *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request
 
 	if err != nil {
 		th.logger.Printf("error: HandleCreateToken: decoding user: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
 		return
 	}
 
-	user, err := th.userStore.GetUserByUsername(req.Username)
+	userKey, ipKey := "user:"+req.Username, "ip:"+clientIP(r)
+	now := time.Now()
 
-	if err != nil || user == nil {
-		th.logger.Printf("error: HandleCreateToken: getting userByUsername: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "iinternal server error"})
+	//el bloqueo se chequea antes de bcrypt, asi un ataque no nos hace gastar CPU
+	lockedUntil, err := th.loginAttemptStore.LockedUntil(r.Context(), now, userKey, ipKey)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreateToken: checking lockout: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	matches, err := user.PasswordHash.Matches(req.Password)
+	if !lockedUntil.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedUntil.Sub(now).Seconds()))))
+		utils.WriteError(w, utils.TooManyRequests("too many failed login attempts, try again later"))
+		return
+	}
+
+	user, err := th.userStore.GetUserByUsername(r.Context(), req.Username)
+
 	if err != nil {
-		th.logger.Printf("error: HandleCreateToken: checking if password matches: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "iinternal server error"})
+		th.logger.Printf("error: HandleCreateToken: getting userByUsername: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
+	var matches bool
+
+	if user == nil {
+		//mismo tiempo de respuesta que una password incorrecta, asi no se puede saber si el usuario existe
+		store.SimulatePasswordCheck(req.Password)
+	} else {
+		matches, err = user.PasswordHash.Matches(req.Password)
+		if err != nil {
+			th.logger.Printf("error: HandleCreateToken: checking if password matches: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+	}
+
 	if !matches {
-		th.logger.Printf("error: HandleCreateToken: password doesnt match: %v", err)
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "invalid user or password"})
+		err = th.recordLoginFailure(r, now, userKey, ipKey)
+		if err != nil {
+			th.logger.Printf("error: HandleCreateToken: recording failed login: %v", err)
+		}
+
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeInvalidCredentials, "invalid user or password"))
 		return
 	}
 
-	token, err := th.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	//la password se chequea antes, asi no se puede saber si una cuenta esta suspendida sin tenerla
+	if user.IsSuspended() {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeAccountSuspended, "your account is suspended"))
+		return
+	}
+
+	//con 2FA la password sola no alcanza: se da un token que solo sirve para mandar el codigo.
+	//Los fallos no se resetean hasta que el codigo sea valido, sino cada login con la password
+	//daria intentos nuevos para adivinar el codigo
+	if user.HasTwoFactor() {
+		event := th.newLoginEvent(r, auditSessionMFAPending, user)
+
+		mfaToken, err := th.tokenStore.CreateNewToken(withAudit(r, event), user.ID, th.ttls.MFATTL, tokens.ScopeMFAPending)
+
+		if err != nil {
+			th.logger.Printf("error: HandleCreateToken: creating mfa token: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"mfa_required": true, "mfa_token": mfaToken.Plaintext})
+		return
+	}
+
+	err = th.loginAttemptStore.Reset(r.Context(), userKey)
 
 	if err != nil {
-		th.logger.Printf("error: HandleCreateToken: creating token: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "iinternal server error"})
+		th.logger.Printf("error: HandleCreateToken: resetting failed logins: %v", err)
+	}
+
+	event := th.newLoginEvent(r, auditSessionCreate, user)
+
+	auth, refresh, err := th.createSession(r.WithContext(withAudit(r, event)), user)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreateToken: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"token": token})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"token": auth, "refresh_token": refresh})
 	return
 }
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	//se manda uno de los dos: el codigo de la app o un codigo de recuperacion
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// HandleVerifyMFA es el segundo paso del login de las cuentas con 2FA: cambia el token que dio
+// POST /tokens/authentication y un codigo valido por los tokens de la sesion.
+// Los codigos incorrectos cuentan como logins fallidos, con el mismo bloqueo
+func (th *TokenHandler) HandleVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req verifyMFARequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.MFAToken == "" || (req.Code == "") == (req.RecoveryCode == "") {
+		th.logger.Printf("error: HandleVerifyMFA: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("mfa_token and either code or recovery_code are required"))
+		return
+	}
+
+	user, err := th.userStore.GetUserToken(r.Context(), tokens.ScopeMFAPending, req.MFAToken)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired mfa token"))
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("error: HandleVerifyMFA: getting user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	userKey, ipKey := "user:"+user.Username, "ip:"+clientIP(r)
+	now := time.Now()
+
+	lockedUntil, err := th.loginAttemptStore.LockedUntil(r.Context(), now, userKey, ipKey)
+
+	if err != nil {
+		th.logger.Printf("error: HandleVerifyMFA: checking lockout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedUntil.Sub(now).Seconds()))))
+		utils.WriteError(w, utils.TooManyRequests("too many failed login attempts, try again later"))
+		return
+	}
+
+	valid := false
+
+	if req.Code != "" {
+		step, ok := totp.Match(user.TOTPSecret, req.Code, now)
+
+		if ok && user.HasTwoFactor() {
+			//un codigo ya usado no sirve de nuevo aunque siga en su ventana
+			err = th.userStore.UseTOTPStep(r.Context(), user.ID, step)
+
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				th.logger.Printf("error: HandleVerifyMFA: saving totp step: %v", err)
+				utils.WriteError(w, utils.InternalError())
+				return
+			}
+
+			valid = err == nil
+		}
+	} else {
+		event := th.newLoginEvent(r, auditUserRecoveryCodeUse, user)
+
+		err = th.userStore.UseRecoveryCode(withAudit(r, event), user.ID, tokens.Hash(normalizeRecoveryCode(req.RecoveryCode)))
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			th.logger.Printf("error: HandleVerifyMFA: using recovery code: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+
+		valid = err == nil
+	}
+
+	if !valid {
+		err = th.recordLoginFailure(r, now, userKey, ipKey)
+		if err != nil {
+			th.logger.Printf("error: HandleVerifyMFA: recording failed login: %v", err)
+		}
+
+		utils.WriteError(w, utils.NewAPIError(http.StatusUnauthorized, utils.ErrCodeInvalidCredentials, "invalid code"))
+		return
+	}
+
+	//el token se borra antes de crear la sesion, asi dos requests con el mismo token no abren dos sesiones
+	err = th.tokenStore.DeleteSessionByToken(r.Context(), tokens.ScopeMFAPending, req.MFAToken)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired mfa token"))
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("error: HandleVerifyMFA: deleting mfa token: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	err = th.loginAttemptStore.Reset(r.Context(), userKey)
+
+	if err != nil {
+		th.logger.Printf("error: HandleVerifyMFA: resetting failed logins: %v", err)
+	}
+
+	event := th.newLoginEvent(r, auditSessionCreate, user)
+	if req.RecoveryCode != "" {
+		event.SetAfter(map[string]string{"mfa": "recovery_code"})
+	} else {
+		event.SetAfter(map[string]string{"mfa": "code"})
+	}
+
+	auth, refresh, err := th.createSession(r.WithContext(withAudit(r, event)), user)
+
+	if err != nil {
+		th.logger.Printf("error: HandleVerifyMFA: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"token": auth, "refresh_token": refresh})
+}
+
+// newLoginEvent arma el evento de auditoria de un paso del login de user. Las rutas del login
+// no tienen usuario logueado, el actor es el usuario que se loguea
+func (th *TokenHandler) newLoginEvent(r *http.Request, action string, user *store.User) *store.AuditEvent {
+	event := newAuditEvent(r, action, store.AuditTargetUser, user.ID)
+	event.ActorID = &user.ID
+
+	return event
+}
+
+// createSession genera y guarda los tokens de una sesion nueva de user
+func (th *TokenHandler) createSession(r *http.Request, user *store.User) (*tokens.Token, *tokens.Token, error) {
+	familyID, err := tokens.GenerateFamilyID()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating family id: %w", err)
+	}
+
+	auth, refresh, err := th.newSessionTokens(r, user, familyID)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating tokens: %w", err)
+	}
+
+	err = th.tokenStore.Insert(r.Context(), auth, refresh)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating token: %w", err)
+	}
+
+	return auth, refresh, nil
+}
+
+// recordLoginFailure suma el fallo al username y a la IP, y bloquea los que pasaron su limite
+func (th *TokenHandler) recordLoginFailure(r *http.Request, now time.Time, userKey, ipKey string) error {
+	limits := []struct {
+		key         string
+		maxAttempts int
+	}{
+		{userKey, th.login.MaxAttempts},
+		{ipKey, th.login.MaxAttemptsPerIP},
+	}
+
+	for _, limit := range limits {
+		failures, err := th.loginAttemptStore.RecordFailure(r.Context(), limit.key, now, now.Add(-th.login.MaxLockout))
+
+		if err != nil {
+			return err
+		}
+
+		if failures < limit.maxAttempts {
+			continue
+		}
+
+		err = th.loginAttemptStore.Lock(r.Context(), limit.key, now.Add(th.lockoutDuration(failures-limit.maxAttempts)))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockoutDuration es Lockout duplicado por cada fallo despues del limite, hasta MaxLockout
+func (th *TokenHandler) lockoutDuration(failuresOverLimit int) time.Duration {
+	lockout := th.login.Lockout
+
+	for i := 0; i < failuresOverLimit && lockout < th.login.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, th.login.MaxLockout)
+}
+
+// clientIP es la IP de la conexion. Si la API queda detras de un proxy hay que
+// sacarla de X-Forwarded-For (por ej. con el middleware RealIP de chi)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// newSessionTokens genera el token de auth (corto) y el refresh token (largo) de una sesion.
+// En modo JWT el de auth es un JWT, igual se guarda como sesion para poder listarla y cerrarla
+func (th *TokenHandler) newSessionTokens(r *http.Request, user *store.User, familyID string) (*tokens.Token, *tokens.Token, error) {
+	var (
+		auth *tokens.Token
+		err  error
+	)
+
+	if th.jwt != nil {
+		auth, err = th.jwt.GenerateToken(user.ID, user.Username, user.Role, user.EmailVerifiedAt, th.ttls.AuthTTL)
+	} else {
+		auth, err = tokens.GenerateToken(user.ID, th.ttls.AuthTTL, tokens.ScopeAuth)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := tokens.GenerateToken(user.ID, th.ttls.RefreshTTL, tokens.ScopeRefresh)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userAgent := truncate(r.UserAgent(), maxUserAgentLength)
+	for _, t := range []*tokens.Token{auth, refresh} {
+		t.UserAgent = userAgent
+		t.FamilyID = familyID
+	}
+
+	return auth, refresh, nil
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// HandleRefreshToken cambia un refresh token por un token de auth nuevo y un refresh token nuevo.
+// Cada refresh token sirve una sola vez: si se vuelve a usar uno ya rotado se revoca toda la sesion
+func (th *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.RefreshToken == "" {
+		th.logger.Printf("error: HandleRefreshToken: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	//el usuario hace falta antes de rotar para firmar el JWT. Si el token ya se habia usado
+	//lo encuentra igual, y RotateRefreshToken revoca la sesion
+	user, err := th.userStore.GetUserToken(r.Context(), tokens.ScopeRefresh, req.RefreshToken)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired refresh token"))
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("error: HandleRefreshToken: getting user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//la familia la completa el store con la del refresh token
+	auth, refresh, err := th.newSessionTokens(r, user, "")
+
+	if err != nil {
+		th.logger.Printf("error: HandleRefreshToken: generating tokens: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	event := th.newLoginEvent(r, auditSessionRefresh, user)
+
+	err = th.tokenStore.RotateRefreshToken(withAudit(r, event), req.RefreshToken, auth, refresh)
+
+	if errors.Is(err, store.ErrRefreshTokenReused) {
+		th.logger.Printf("error: HandleRefreshToken: refresh token reused, session revoked")
+		utils.WriteError(w, utils.InvalidToken("refresh token already used, the session was revoked"))
+		return
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired refresh token"))
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("error: HandleRefreshToken: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"token": auth, "refresh_token": refresh})
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// HandleCreatePasswordResetToken manda por mail un token para PUT /users/password.
+// Contesta lo mismo exista o no el email, asi no sirve para averiguar quien tiene cuenta
+func (th *TokenHandler) HandleCreatePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Email == "" {
+		th.logger.Printf("error: HandleCreatePasswordResetToken: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if the email belongs to an account, a password reset token was sent to it"}
+
+	user, err := th.userStore.GetUserByEmail(r.Context(), req.Email)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreatePasswordResetToken: getting user by email: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if user == nil {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	//solo sirve el ultimo token pedido
+	err = th.tokenStore.DeleteAllTokensForUser(r.Context(), user.ID, tokens.ScopePasswordReset)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		th.logger.Printf("error: HandleCreatePasswordResetToken: deleting old tokens: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//quien pide el reset no esta logueado, asi que el evento no tiene actor
+	event := newAuditEvent(r, auditPasswordResetRequest, store.AuditTargetUser, user.ID)
+
+	token, err := th.tokenStore.CreateNewToken(withAudit(r, event), user.ID, th.ttls.PasswordResetTTL, tokens.ScopePasswordReset)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreatePasswordResetToken: creating token: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	err = th.mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this token to set a new password with PUT /users/password:\n\n%s\n\nIt expires in %s. If you didn't ask for it, you can ignore this email.\n",
+			user.Username, token.Plaintext, th.ttls.PasswordResetTTL),
+	})
+
+	//si el mail falla no se lo decimos al cliente, seria decirle que el email existe
+	if err != nil {
+		th.logger.Printf("error: HandleCreatePasswordResetToken: sending mail: %v", err)
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+type activationTokenRequest struct {
+	Email string `json:"email"`
+}
+
+// HandleCreateActivationToken vuelve a mandar el mail de verificacion (por ej. si se perdio el del registro).
+// Igual que el reset de password contesta lo mismo exista o no el email
+func (th *TokenHandler) HandleCreateActivationToken(w http.ResponseWriter, r *http.Request) {
+	var req activationTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Email == "" {
+		th.logger.Printf("error: HandleCreateActivationToken: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if the email belongs to an unverified account, an activation token was sent to it"}
+
+	user, err := th.userStore.GetUserByEmail(r.Context(), req.Email)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreateActivationToken: getting user by email: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if user == nil || user.IsVerified() {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	//el evento se guarda con el primer cambio que haga sendActivationToken
+	event := newAuditEvent(r, auditActivationRequest, store.AuditTargetUser, user.ID)
+
+	err = sendActivationToken(withAudit(r, event), th.tokenStore, th.mailer, user, th.ttls.ActivationTTL)
+
+	if err != nil {
+		th.logger.Printf("error: HandleCreateActivationToken: %v", err)
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// el user agent lo manda el cliente, no guardamos mas que esto
+const maxUserAgentLength = 256
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+// session es como se muestra un token de autenticacion en la lista de sesiones,
+// nunca incluye el token en si
+type session struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+	UserAgent string    `json:"user_agent"`
+	//la sesion con la que se hizo la request
+	Current bool `json:"current"`
+}
+
+// HandleRevokeToken cierra la sesion actual (logout): revoca el token con el que se hizo la request
+// y su refresh token
+func (th *TokenHandler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	event := newAuditEvent(r, auditSessionRevoke, store.AuditTargetUser, middleware.GetUser(r).ID)
+
+	err := th.tokenStore.DeleteSessionByToken(withAudit(r, event), tokens.ScopeAuth, middleware.GetToken(r))
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		th.logger.Printf("error: HandleRevokeToken: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "logged out"})
+}
+
+// HandleRevokeAllTokens cierra todas las sesiones del usuario, incluida la actual
+func (th *TokenHandler) HandleRevokeAllTokens(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	//el evento se guarda con el primer scope que tenga tokens
+	ctx := withAudit(r, newAuditEvent(r, auditSessionRevokeAll, store.AuditTargetUser, user.ID))
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err := th.tokenStore.DeleteAllTokensForUser(ctx, user.ID, scope)
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			th.logger.Printf("error: HandleRevokeAllTokens: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "logged out from every session"})
+}
+
+func (th *TokenHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	authTokens, err := th.tokenStore.GetTokensForUser(r.Context(), user.ID, tokens.ScopeAuth)
+
+	if err != nil {
+		th.logger.Printf("error: HandleListSessions: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	currentHash := tokens.Hash(middleware.GetToken(r))
+	sessions := make([]session, 0, len(authTokens))
+
+	for _, t := range authTokens {
+		sessions = append(sessions, session{
+			ID:        t.ID,
+			CreatedAt: t.CreatedAt,
+			Expiry:    t.Expiry,
+			UserAgent: t.UserAgent,
+			Current:   bytes.Equal(t.Hash, currentHash),
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": sessions})
+}
+
+// HandleRevokeSession cierra una sesion puntual del usuario (por ej. un dispositivo perdido)
+func (th *TokenHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		th.logger.Printf("error: HandleRevokeSession: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid session id"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	event := newAuditEvent(r, auditSessionRevoke, store.AuditTargetUser, user.ID)
+	event.SetAfter(map[string]int64{"session_id": sessionID})
+
+	err = th.tokenStore.DeleteSession(withAudit(r, event), user.ID, int(sessionID))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("session not found"))
+		return
+	}
+
+	if err != nil {
+		th.logger.Printf("error: HandleRevokeSession: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "session revoked"})
+}
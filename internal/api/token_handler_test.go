@@ -0,0 +1,317 @@
+package api_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCreateToken(t *testing.T) {
+	server := newTestServer(t)
+	registerAndLogin(t, server, "joaquin")
+
+	status, body := doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{
+		"username": "joaquin",
+		"password": "wrong password",
+	})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.NotContains(t, body, "token")
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", "not an object")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestLogoutAndSessions(t *testing.T) {
+	server := newTestServer(t)
+	laptop := registerAndLogin(t, server, "joaquin")
+	phone := login(t, server, "joaquin")
+	other := registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodGet, "/tokens/authentication/sessions", laptop, nil)
+	require.Equal(t, http.StatusOK, status)
+	sessions := body["sessions"].([]any)
+	require.Len(t, sessions, 2)
+
+	var phoneSessionID any
+	for _, s := range sessions {
+		session := s.(map[string]any)
+		assert.NotEmpty(t, session["user_agent"])
+		assert.NotContains(t, session, "token")
+		if session["current"] == false {
+			phoneSessionID = session["id"]
+		}
+	}
+	require.NotNil(t, phoneSessionID)
+
+	//no se pueden cerrar sesiones de otro usuario
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/tokens/authentication/sessions/%v", phoneSessionID), other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/tokens/authentication/sessions/%v", phoneSessionID), laptop, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", phone, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/tokens/authentication", laptop, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", laptop, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	first := login(t, server, "joaquin")
+	second := login(t, server, "joaquin")
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/tokens/authentication/all", first, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	for _, token := range []string{first, second} {
+		status, _ = doRequest(t, server, http.MethodGet, "/workouts", token, nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+
+	//las sesiones de otros usuarios siguen abiertas
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", other, nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRefreshToken(t *testing.T) {
+	server := newTestServer(t)
+	registerAndLogin(t, server, "joaquin")
+
+	//loginWithRefresh devuelve el token de auth y el refresh de una sesion nueva
+	loginWithRefresh := func() (string, string) {
+		status, body := doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{
+			"username": "joaquin",
+			"password": "supersecret",
+		})
+		require.Equal(t, http.StatusOK, status)
+		return body["token"].(map[string]any)["token"].(string), body["refresh_token"].(map[string]any)["token"].(string)
+	}
+
+	refresh := func(refreshToken string) (int, map[string]any) {
+		return doRequest(t, server, http.MethodPost, "/tokens/refresh", "", map[string]any{"refresh_token": refreshToken})
+	}
+
+	auth, refreshToken := loginWithRefresh()
+
+	status, body := refresh(refreshToken)
+	require.Equal(t, http.StatusOK, status)
+	newAuth := body["token"].(map[string]any)["token"].(string)
+	newRefresh := body["refresh_token"].(map[string]any)["token"].(string)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", newAuth, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", auth, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//un token de auth no sirve para refrescar
+	status, _ = refresh(newAuth)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//reusar el refresh viejo revoca la sesion
+	status, _ = refresh(refreshToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", newAuth, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = refresh(newRefresh)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//el logout tambien invalida el refresh token
+	auth, refreshToken = loginWithRefresh()
+	status, _ = doRequest(t, server, http.MethodDelete, "/tokens/authentication", auth, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = refresh(refreshToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/refresh", "", "not an object")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestLoginLockout(t *testing.T) {
+	server := newTestServer(t)
+	registerAndLogin(t, server, "joaquin")
+	registerAndLogin(t, server, "other")
+
+	wrong := map[string]any{"username": "joaquin", "password": "wrong password"}
+
+	//un usuario que no existe contesta igual que una password incorrecta
+	status, body := doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "nobody", "password": "supersecret"})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "invalid_credentials", apiError(t, body)["code"])
+	assert.Equal(t, "invalid user or password", apiError(t, body)["message"])
+
+	//el servidor de test bloquea despues de 3 fallos
+	for i := 0; i < 3; i++ {
+		status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", wrong)
+		require.Equal(t, http.StatusForbidden, status)
+	}
+
+	//bloqueado, aunque ahora la password sea la correcta
+	res, err := server.Client().Post(server.URL+"/tokens/authentication", "application/json",
+		bytes.NewBufferString(`{"username": "joaquin", "password": "supersecret"}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 60, retryAfter, 1)
+
+	//el bloqueo es por username, otros usuarios pueden loguearse
+	login(t, server, "other")
+}
+
+func TestTwoFactor(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	//sin empezar la activacion no hay secreto para confirmar
+	status, _ := doRequest(t, server, http.MethodPost, "/users/me/2fa/confirm", token, map[string]any{"code": "123456"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := doRequest(t, server, http.MethodPost, "/users/me/2fa", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	secret := body["secret"].(string)
+	assert.Contains(t, body["otpauth_uri"], "otpauth://totp/")
+	assert.Contains(t, body["otpauth_uri"], "secret="+secret)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/users/me/2fa/confirm", token, map[string]any{"code": "000000"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+	status, body = doRequest(t, server, http.MethodPost, "/users/me/2fa/confirm", token, map[string]any{"code": code})
+	require.Equal(t, http.StatusOK, status)
+	recoveryCodes := body["recovery_codes"].([]any)
+	assert.Len(t, recoveryCodes, 10)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/users/me/2fa", token, nil)
+	assert.Equal(t, http.StatusConflict, status)
+
+	//ahora el login con la password no da una sesion sino un token para mandar el codigo
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["mfa_required"])
+	assert.Nil(t, body["token"])
+	mfaToken := body["mfa_token"].(string)
+
+	//el token pendiente no sirve como token de auth
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", mfaToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//el codigo de la activacion ya se uso, aunque siga en su ventana no sirve para loguearse
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	next, err := totp.Code(secret, time.Now().Add(totp.Period))
+	require.NoError(t, err)
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": next})
+	require.Equal(t, http.StatusOK, status)
+	session := body["token"].(map[string]any)["token"].(string)
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", session, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	//el token pendiente se usa una sola vez
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": next})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//y el codigo tambien, aunque venga con otro token pendiente
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": body["mfa_token"], "code": next})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//los codigos de recuperacion tambien son de un solo uso, y se aceptan en mayuscula
+	recoveryCode := strings.ToUpper(recoveryCodes[0].(string))
+	for _, expected := range []int{http.StatusOK, http.StatusUnauthorized} {
+		status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+		require.Equal(t, http.StatusOK, status)
+
+		status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": body["mfa_token"], "recovery_code": recoveryCode})
+		assert.Equal(t, expected, status)
+	}
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me/2fa", session, map[string]any{"password": "wrong password"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me/2fa", session, map[string]any{"password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+
+	//sin 2FA el login vuelve a dar la sesion directamente
+	login(t, server, "joaquin")
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	status, body := doRequest(t, server, http.MethodPost, "/users/me/2fa", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	secret := body["secret"].(string)
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+	status, _ = doRequest(t, server, http.MethodPost, "/users/me/2fa/confirm", token, map[string]any{"code": code})
+	require.Equal(t, http.StatusOK, status)
+
+	//volver a loguearse con la password no resetea los codigos fallidos
+	for i := 0; i < 3; i++ {
+		status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+		require.Equal(t, http.StatusOK, status)
+
+		status, _ = doRequest(t, server, http.MethodPost, "/tokens/mfa", "", map[string]any{"mfa_token": body["mfa_token"], "code": "000000"})
+		require.Equal(t, http.StatusUnauthorized, status)
+	}
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+func TestJWTTokens(t *testing.T) {
+	server := newJWTTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+	assert.Equal(t, 2, strings.Count(token, "."), "the auth token should be a JWT")
+
+	//los workouts solo usan lo que viene en el token (id y email verificado)
+	status, _ := doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{"title": "push day", "duration_minutes": 60})
+	assert.Equal(t, http.StatusOK, status)
+
+	//el perfil se carga completo de la db
+	status, body := doRequest(t, server, http.MethodGet, "/users/me", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "joaquin@mail.com", body["user"].(map[string]any)["email"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", token+"x", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//el refresh token sigue siendo opaco y da otro JWT
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+	refresh := body["refresh_token"].(map[string]any)["token"].(string)
+
+	status, body = doRequest(t, server, http.MethodPost, "/tokens/refresh", "", map[string]any{"refresh_token": refresh})
+	require.Equal(t, http.StatusOK, status)
+	refreshed := body["token"].(map[string]any)["token"].(string)
+	assert.Equal(t, 2, strings.Count(refreshed, "."))
+
+	//los JWT tambien quedan como sesiones
+	status, body = doRequest(t, server, http.MethodGet, "/tokens/authentication/sessions", refreshed, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["sessions"], 2)
+
+	//con el usuario borrado las rutas que lo cargan de la db rechazan el token
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me", token, map[string]any{"password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", refreshed, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
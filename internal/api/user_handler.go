@@ -1,25 +1,43 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/joaquinbian/workout-api-go/internal/config"
+	"github.com/joaquinbian/workout-api-go/internal/mailer"
+	"github.com/joaquinbian/workout-api-go/internal/middleware"
+	"github.com/joaquinbian/workout-api-go/internal/passwords"
 	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/joaquinbian/workout-api-go/internal/totp"
 	"github.com/joaquinbian/workout-api-go/internal/utils"
 )
 
 type UserHandler struct {
-	userStore store.UserStore
-	logger    *log.Logger
+	userStore  store.UserStore
+	tokenStore store.TokenStore
+	ttls       config.TokensConfig
+	mailer     mailer.Mailer
+	logger     *log.Logger
 }
 
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, ttls config.TokensConfig, m mailer.Mailer, logger *log.Logger) *UserHandler {
 	return &UserHandler{
-		userStore: userStore,
-		logger:    logger,
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		ttls:       ttls,
+		mailer:     m,
+		logger:     logger,
 	}
 }
 
@@ -30,28 +48,47 @@ type registerUserRequest struct {
 	Bio      string `json:"bio"`
 }
 
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
 func validateRegisterUserRequest(userTorRegister *registerUserRequest) error {
-	if userTorRegister.Username == "" {
+	err := validateUsername(userTorRegister.Username)
+	if err != nil {
+		return err
+	}
+
+	err = validateEmail(userTorRegister.Email)
+	if err != nil {
+		return err
+	}
+
+	if userTorRegister.Password == "" {
+		return errors.New("password is requried")
+	}
+
+	return passwords.Validate(userTorRegister.Password)
+}
+
+func validateUsername(username string) error {
+	if username == "" {
 		return errors.New("username is required")
 	}
 
-	if len(userTorRegister.Username) > 50 {
+	if len(username) > 50 {
 		return errors.New("username must be at most 50 characters long")
 	}
 
-	if userTorRegister.Email == "" {
+	return nil
+}
+
+func validateEmail(email string) error {
+	if email == "" {
 		return errors.New("email is required")
 	}
 
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-
-	if !emailRegex.Match([]byte(userTorRegister.Email)) {
+	if !emailRegex.Match([]byte(email)) {
 		return errors.New("email is not well formatted")
 	}
 
-	if userTorRegister.Password == "" {
-		return errors.New("password is requried")
-	}
 	return nil
 }
 
@@ -62,7 +99,7 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		h.logger.Printf("error: decoding register user: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid payload"})
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
 		return
 	}
 
@@ -70,7 +107,7 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		h.logger.Printf("error: register user: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user"})
+		utils.WriteError(w, utils.BadRequest("invalid user: "+err.Error()))
 		return
 	}
 
@@ -86,17 +123,658 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 	err = user.PasswordHash.Set(req.Password)
 	if err != nil {
 		h.logger.Printf("error: hashing password: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	err = h.userStore.CreateUser(user)
+	event := newAuditEvent(r, auditUserRegister, store.AuditTargetUser, 0)
+	event.SetAfter(user)
+
+	err = h.userStore.CreateUser(withAudit(r, event), user)
+
+	if apiErr := storeError(err, "user not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
 
 	if err != nil {
 		h.logger.Printf("error: registe: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
+	//la cuenta ya esta creada: si falla el mail el usuario puede pedir otro con POST /tokens/activation
+	err = sendActivationToken(r.Context(), h.tokenStore, h.mailer, user, h.ttls.ActivationTTL)
+
+	if err != nil {
+		h.logger.Printf("error: register: sending activation token: %v", err)
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 }
+
+// sendActivationToken manda por mail un token nuevo para verificar el email de user.
+// Los tokens de activacion que tuviera antes dejan de servir
+func sendActivationToken(ctx context.Context, ts store.TokenStore, m mailer.Mailer, user *store.User, ttl time.Duration) error {
+	err := ts.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeActivation)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	token, err := ts.CreateNewToken(ctx, user.ID, ttl, tokens.ScopeActivation)
+
+	if err != nil {
+		return err
+	}
+
+	return m.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this token to verify your email with PUT /users/activated:\n\n%s\n\nIt expires in %s.\n",
+			user.Username, token.Plaintext, ttl),
+	})
+}
+
+type activateUserRequest struct {
+	Token string `json:"token"`
+}
+
+// HandleActivateUser verifica el email del usuario con el token que se le mando por mail
+func (h *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	var req activateUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Token == "" {
+		h.logger.Printf("error: activate user: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(r.Context(), tokens.ScopeActivation, req.Token)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.BadRequest("invalid or expired activation token"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: activate user: getting user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	event := newAuditEvent(r, auditUserVerifyEmail, store.AuditTargetUser, user.ID)
+	event.ActorID = &user.ID
+	event.SetBefore(user)
+	event.SetAfter(user)
+
+	err = h.userStore.VerifyEmail(withAudit(r, event), user)
+
+	if err != nil {
+		h.logger.Printf("error: activate user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+func (h *UserHandler) HandleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": middleware.GetUser(r)})
+}
+
+// updateUserRequest usa punteros para distinguir un campo que no vino de uno vacio
+type updateUserRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	Bio      *string `json:"bio"`
+}
+
+// HandleUpdateCurrentUser actualiza los campos que vengan en el body. Si cambia el email
+// la cuenta vuelve a quedar sin verificar y se manda un token de activacion al email nuevo
+func (h *UserHandler) HandleUpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var req updateUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		h.logger.Printf("error: update user: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+	previousEmail := user.Email
+
+	event := newAuditEvent(r, auditUserUpdate, store.AuditTargetUser, user.ID)
+	event.SetBefore(user)
+	event.SetAfter(user)
+
+	if req.Username != nil {
+		err = validateUsername(*req.Username)
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(err.Error()))
+			return
+		}
+		user.Username = *req.Username
+	}
+
+	if req.Email != nil {
+		err = validateEmail(*req.Email)
+		if err != nil {
+			utils.WriteError(w, utils.BadRequest(err.Error()))
+			return
+		}
+		user.Email = *req.Email
+	}
+
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+
+	err = h.userStore.UpdateUser(withAudit(r, event), user)
+
+	if apiErr := storeError(err, "user not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: update user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if user.Email != previousEmail {
+		err = sendActivationToken(r.Context(), h.tokenStore, h.mailer, user, h.ttls.ActivationTTL)
+
+		if err != nil {
+			h.logger.Printf("error: update user: sending activation token: %v", err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+type deleteUserRequest struct {
+	Password string `json:"password"`
+}
+
+// HandleDeleteCurrentUser borra la cuenta con todos sus workouts y sesiones.
+// Pide la password de nuevo para que un token robado no alcance para borrar la cuenta
+func (h *UserHandler) HandleDeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var req deleteUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Password == "" {
+		h.logger.Printf("error: delete user: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("password is required"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	matches, err := user.PasswordHash.Matches(req.Password)
+
+	if err != nil {
+		h.logger.Printf("error: delete user: checking password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if !matches {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeInvalidCredentials, "invalid password"))
+		return
+	}
+
+	//el evento queda con la cuenta como estaba, aunque se borre
+	event := newAuditEvent(r, auditUserDelete, store.AuditTargetUser, user.ID)
+	event.SetBefore(user)
+
+	err = h.userStore.DeleteUser(withAudit(r, event), user.ID)
+
+	if err != nil {
+		h.logger.Printf("error: delete user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "account deleted"})
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	//por defecto la sesion con la que se cambia la password sigue abierta
+	RevokeCurrentSession bool `json:"revoke_current_session"`
+}
+
+// HandleChangePassword cambia la password del usuario logueado y cierra el resto de sus sesiones
+func (h *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		h.logger.Printf("error: change password: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		utils.WriteError(w, utils.BadRequest("current_password and new_password are required"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	matches, err := user.PasswordHash.Matches(req.CurrentPassword)
+
+	if err != nil {
+		h.logger.Printf("error: change password: checking password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if !matches {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeInvalidCredentials, "invalid password"))
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		utils.WriteError(w, utils.BadRequest("the new password must be different from the current one"))
+		return
+	}
+
+	err = passwords.Validate(req.NewPassword)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	err = user.PasswordHash.Set(req.NewPassword)
+
+	if err != nil {
+		h.logger.Printf("error: change password: hashing password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	keepSession := middleware.GetToken(r)
+	if req.RevokeCurrentSession {
+		keepSession = ""
+	}
+
+	event := newAuditEvent(r, auditUserPasswordChange, store.AuditTargetUser, user.ID)
+
+	err = h.userStore.UpdatePassword(withAudit(r, event), user, keepSession)
+
+	if err != nil {
+		h.logger.Printf("error: change password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "password updated"})
+}
+
+// nombre con el que aparece la cuenta en la app de autenticacion
+const totpIssuer = "Workout API"
+
+// HandleSetupTwoFactor empieza la activacion del 2FA: genera un secreto nuevo y devuelve el otpauth://
+// para escanear como QR. El 2FA no se activa hasta confirmar con un codigo en POST /users/me/2fa/confirm
+func (h *UserHandler) HandleSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	if user.HasTwoFactor() {
+		utils.WriteError(w, utils.Conflict("2fa is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		h.logger.Printf("error: setup 2fa: generating secret: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	event := newAuditEvent(r, auditUser2FASetup, store.AuditTargetUser, user.ID)
+
+	err = h.userStore.SetTOTPSecret(withAudit(r, event), user.ID, secret)
+
+	if err != nil {
+		h.logger.Printf("error: setup 2fa: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"secret": secret, "otpauth_uri": totp.URI(totpIssuer, user.Username, secret)})
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// cuantos codigos de recuperacion se dan al activar el 2FA
+const recoveryCodeCount = 10
+
+// HandleConfirmTwoFactor activa el 2FA con el primer codigo de la app (asi sabemos que el secreto
+// quedo bien cargado) y devuelve los codigos de recuperacion. Es la unica vez que se muestran
+func (h *UserHandler) HandleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Code == "" {
+		h.logger.Printf("error: confirm 2fa: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("code is required"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	if user.HasTwoFactor() {
+		utils.WriteError(w, utils.Conflict("2fa is already enabled"))
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		utils.WriteError(w, utils.BadRequest("start the 2fa setup with POST /users/me/2fa first"))
+		return
+	}
+
+	step, ok := totp.Match(user.TOTPSecret, req.Code, time.Now())
+
+	if !ok {
+		utils.WriteError(w, utils.BadRequest("invalid code"))
+		return
+	}
+
+	//el codigo de la activacion tampoco se puede volver a usar para loguearse
+	err = h.userStore.UseTOTPStep(r.Context(), user.ID, step)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.BadRequest("invalid code"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: confirm 2fa: saving totp step: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+
+		if err != nil {
+			h.logger.Printf("error: confirm 2fa: generating recovery codes: %v", err)
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+
+		hashes[i] = tokens.Hash(normalizeRecoveryCode(codes[i]))
+	}
+
+	event := newAuditEvent(r, auditUser2FAEnable, store.AuditTargetUser, user.ID)
+	event.SetBefore(map[string]bool{"two_factor": false})
+	event.SetAfter(map[string]bool{"two_factor": true})
+
+	err = h.userStore.EnableTOTP(withAudit(r, event), user.ID, hashes)
+
+	if err != nil {
+		h.logger.Printf("error: confirm 2fa: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+}
+
+// HandleDisableTwoFactor desactiva el 2FA. Pide la password, igual que borrar la cuenta
+func (h *UserHandler) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req disableTwoFactorRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Password == "" {
+		h.logger.Printf("error: disable 2fa: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("password is required"))
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	matches, err := user.PasswordHash.Matches(req.Password)
+
+	if err != nil {
+		h.logger.Printf("error: disable 2fa: checking password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	if !matches {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeInvalidCredentials, "invalid password"))
+		return
+	}
+
+	if !user.HasTwoFactor() {
+		utils.WriteError(w, utils.Conflict("2fa is not enabled"))
+		return
+	}
+
+	event := newAuditEvent(r, auditUser2FADisable, store.AuditTargetUser, user.ID)
+	event.SetBefore(map[string]bool{"two_factor": true})
+	event.SetAfter(map[string]bool{"two_factor": false})
+
+	err = h.userStore.DisableTOTP(withAudit(r, event), user.ID)
+
+	if err != nil {
+		h.logger.Printf("error: disable 2fa: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "2fa disabled"})
+}
+
+// alfabeto de Crockford en minuscula, sin letras que se confunden (i, l, o, u). Son 32 letras,
+// asi byte % 32 no favorece a ninguna
+const recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// generateRecoveryCode genera un codigo de 10 caracteres con la forma xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	random := make([]byte, 10)
+
+	_, err := rand.Read(random)
+
+	if err != nil {
+		return "", err
+	}
+
+	code := make([]byte, len(random))
+	for i, b := range random {
+		code[i] = recoveryCodeAlphabet[b%32]
+	}
+
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// normalizeRecoveryCode es la forma en que se hashean los codigos: se aceptan con o sin guion y en mayuscula
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// HandleResetPassword cambia la password con el token que mando POST /tokens/password-reset.
+// Al cambiarla se cierran todas las sesiones del usuario y el token deja de servir
+func (h *UserHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		h.logger.Printf("error: reset password: decoding request: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid payload"))
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		utils.WriteError(w, utils.BadRequest("token and password are required"))
+		return
+	}
+
+	err = passwords.Validate(req.Password)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(r.Context(), tokens.ScopePasswordReset, req.Token)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.BadRequest("invalid or expired password reset token"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: reset password: getting user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	err = user.PasswordHash.Set(req.Password)
+
+	if err != nil {
+		h.logger.Printf("error: reset password: hashing password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//la ruta no tiene usuario logueado, el actor es el dueño del token
+	event := newAuditEvent(r, auditUserPasswordReset, store.AuditTargetUser, user.ID)
+	event.ActorID = &user.ID
+
+	err = h.userStore.UpdatePassword(withAudit(r, event), user, "")
+
+	if err != nil {
+		h.logger.Printf("error: reset password: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "password updated, log in again"})
+}
+
+// HandleFollowUser hace que el usuario logueado siga al usuario {id}, asi puede ver
+// sus workouts con visibility followers
+func (h *UserHandler) HandleFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: follow user: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	if currentUser.ID == int(followeeID) {
+		utils.WriteError(w, utils.BadRequest("you can't follow yourself"))
+		return
+	}
+
+	event := newAuditEvent(r, auditUserFollow, store.AuditTargetUser, int(followeeID))
+
+	err = h.userStore.FollowUser(withAudit(r, event), currentUser.ID, int(followeeID))
+
+	if apiErr := storeError(err, "user not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: follow user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "following user"})
+}
+
+func (h *UserHandler) HandleUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: unfollow user: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	event := newAuditEvent(r, auditUserUnfollow, store.AuditTargetUser, int(followeeID))
+
+	err = h.userStore.UnfollowUser(withAudit(r, event), currentUser.ID, int(followeeID))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("you are not following this user"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: unfollow user: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "unfollowed user"})
+}
+
+// HandleRemoveFollower saca al usuario {id} de los seguidores del usuario logueado, asi deja de ver
+// sus workouts con visibility followers. Seguir no pide aprobacion, esta es la forma de cortarlo
+func (h *UserHandler) HandleRemoveFollower(w http.ResponseWriter, r *http.Request) {
+	followerID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		h.logger.Printf("error: remove follower: reading id: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid user id"))
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	event := newAuditEvent(r, auditUserFollowerRemove, store.AuditTargetUser, int(followerID))
+
+	err = h.userStore.UnfollowUser(withAudit(r, event), int(followerID), currentUser.ID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("this user is not following you"))
+		return
+	}
+
+	if err != nil {
+		h.logger.Printf("error: remove follower: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "follower removed"})
+}
@@ -0,0 +1,274 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleRegisterUser(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+	}{
+		{
+			name:       "valid user",
+			body:       map[string]any{"username": "joaquin", "email": "joaquin@mail.com", "password": "supersecret", "bio": "hola"},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "missing username",
+			body:       map[string]any{"email": "nouser@mail.com", "password": "supersecret"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed email",
+			body:       map[string]any{"username": "bademail", "email": "not-an-email", "password": "supersecret"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "common password",
+			body:       map[string]any{"username": "weak", "email": "weak@mail.com", "password": "password123"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "duplicate username",
+			body:       map[string]any{"username": "joaquin", "email": "other@mail.com", "password": "supersecret"},
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			status, body := doRequest(t, server, http.MethodPost, "/users", "", c.body)
+			require.Equal(t, c.wantStatus, status)
+
+			if c.wantStatus == http.StatusCreated {
+				user := body["user"].(map[string]any)
+				assert.Equal(t, c.body["username"], user["username"])
+				assert.NotContains(t, user, "password")
+				return
+			}
+
+			assert.NotEmpty(t, apiError(t, body)["code"])
+			assert.NotEmpty(t, apiError(t, body)["message"])
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	server := newTestServer(t)
+	session := registerAndLogin(t, server, "joaquin")
+
+	//un email que no existe recibe la misma respuesta, pero no se manda nada
+	status, _ := doRequest(t, server, http.MethodPost, "/tokens/password-reset", "", map[string]any{"email": "nobody@mail.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Empty(t, server.mails.messages("nobody@mail.com", "Reset your password"))
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/password-reset", "", map[string]any{"email": "joaquin@mail.com"})
+	require.Equal(t, http.StatusAccepted, status)
+	oldToken := server.mails.lastToken(t, "joaquin@mail.com", "Reset your password")
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/password-reset", "", map[string]any{"email": "joaquin@mail.com"})
+	require.Equal(t, http.StatusAccepted, status)
+	resetToken := server.mails.lastToken(t, "joaquin@mail.com", "Reset your password")
+
+	//pedir otro token invalida el anterior
+	status, _ = doRequest(t, server, http.MethodPut, "/users/password", "", map[string]any{"token": oldToken, "password": "newpassword"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	//un token de auth no sirve para cambiar la password
+	status, _ = doRequest(t, server, http.MethodPut, "/users/password", "", map[string]any{"token": session, "password": "newpassword"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodPut, "/users/password", "", map[string]any{"token": resetToken})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodPut, "/users/password", "", map[string]any{"token": resetToken, "password": "newpassword"})
+	require.Equal(t, http.StatusOK, status)
+
+	//las sesiones abiertas se cierran y el token no se puede reusar
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", session, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = doRequest(t, server, http.MethodPut, "/users/password", "", map[string]any{"token": resetToken, "password": "another"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "newpassword"})
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestEmailVerification(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "joaquin")
+	firstToken := server.mails.lastToken(t, "joaquin@mail.com", "Verify your email")
+
+	//sin verificar se puede loguear y leer, pero no crear workouts
+	session := login(t, server, "joaquin")
+	workout := map[string]any{"title": "push day", "duration_minutes": 60}
+
+	status, _ := doRequest(t, server, http.MethodGet, "/workouts", session, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodPost, "/workouts", session, workout)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	//pedir el mail de nuevo invalida el token anterior
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/activation", "", map[string]any{"email": "joaquin@mail.com"})
+	require.Equal(t, http.StatusAccepted, status)
+	token := server.mails.lastToken(t, "joaquin@mail.com", "Verify your email")
+	require.NotEqual(t, firstToken, token)
+
+	status, _ = doRequest(t, server, http.MethodPut, "/users/activated", "", map[string]any{"token": firstToken})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := doRequest(t, server, http.MethodPut, "/users/activated", "", map[string]any{"token": token})
+	require.Equal(t, http.StatusOK, status)
+	assert.NotNil(t, body["user"].(map[string]any)["emailVerifiedAt"])
+
+	status, _ = doRequest(t, server, http.MethodPost, "/workouts", session, workout)
+	assert.Equal(t, http.StatusOK, status)
+
+	//el token se usa una sola vez y a una cuenta verificada no se le manda otro
+	status, _ = doRequest(t, server, http.MethodPut, "/users/activated", "", map[string]any{"token": token})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/activation", "", map[string]any{"email": "joaquin@mail.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Len(t, server.mails.messages("joaquin@mail.com", "Verify your email"), 2)
+}
+
+func TestCurrentUser(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+	registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodGet, "/users/me", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "joaquin", body["user"].(map[string]any)["username"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+	}{
+		{name: "empty username", body: map[string]any{"username": ""}, wantStatus: http.StatusBadRequest},
+		{name: "malformed email", body: map[string]any{"email": "not-an-email"}, wantStatus: http.StatusBadRequest},
+		{name: "taken username", body: map[string]any{"username": "other"}, wantStatus: http.StatusConflict},
+		{name: "taken email", body: map[string]any{"email": "other@mail.com"}, wantStatus: http.StatusConflict},
+		{name: "only bio", body: map[string]any{"bio": "hola"}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := doRequest(t, server, http.MethodPatch, "/users/me", token, tt.body)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+
+	//los conflictos dicen que campo esta repetido
+	status, body = doRequest(t, server, http.MethodPatch, "/users/me", token, map[string]any{"email": "other@mail.com"})
+	require.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "conflict", apiError(t, body)["code"])
+	assert.Equal(t, map[string]any{"email": "is already in use"}, apiError(t, body)["details"])
+
+	status, body = doRequest(t, server, http.MethodPatch, "/users/me", token, map[string]any{"username": "joaco"})
+	require.Equal(t, http.StatusOK, status)
+	user := body["user"].(map[string]any)
+	assert.Equal(t, "joaco", user["username"])
+	assert.Equal(t, "hola", user["bio"])
+	assert.NotNil(t, user["emailVerifiedAt"])
+
+	//cambiar el email pide verificarlo de nuevo
+	status, body = doRequest(t, server, http.MethodPatch, "/users/me", token, map[string]any{"email": "joaco@mail.com"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Nil(t, body["user"].(map[string]any)["emailVerifiedAt"])
+
+	status, _ = doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{"title": "push day"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	activation := server.mails.lastToken(t, "joaco@mail.com", "Verify your email")
+	status, _ = doRequest(t, server, http.MethodPut, "/users/activated", "", map[string]any{"token": activation})
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestDeleteCurrentUser(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	status, _ := doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{"title": "push day", "duration_minutes": 60})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me", token, map[string]any{})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me", token, map[string]any{"password": "wrong password"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/users/me", token, map[string]any{"password": "supersecret"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	//el username y el email quedan libres
+	registerAndLogin(t, server, "joaquin")
+}
+
+func TestChangePassword(t *testing.T) {
+	server := newTestServer(t)
+	laptop := registerAndLogin(t, server, "joaquin")
+	phone := login(t, server, "joaquin")
+
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+	}{
+		{name: "missing fields", body: map[string]any{"new_password": "correct horse battery"}, wantStatus: http.StatusBadRequest},
+		{name: "wrong current password", body: map[string]any{"current_password": "wrong password", "new_password": "correct horse battery"}, wantStatus: http.StatusForbidden},
+		{name: "same password", body: map[string]any{"current_password": "supersecret", "new_password": "supersecret"}, wantStatus: http.StatusBadRequest},
+		{name: "too short", body: map[string]any{"current_password": "supersecret", "new_password": "short"}, wantStatus: http.StatusBadRequest},
+		{name: "common password", body: map[string]any{"current_password": "supersecret", "new_password": "password123"}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := doRequest(t, server, http.MethodPut, "/users/me/password", laptop, tt.body)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+
+	status, _ := doRequest(t, server, http.MethodPut, "/users/me/password", laptop, map[string]any{
+		"current_password": "supersecret",
+		"new_password":     "correct horse battery",
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	//la sesion que cambio la password sigue abierta, el resto no
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", laptop, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", phone, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doRequest(t, server, http.MethodPost, "/tokens/authentication", "", map[string]any{"username": "joaquin", "password": "supersecret"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = doRequest(t, server, http.MethodPut, "/users/me/password", laptop, map[string]any{
+		"current_password":       "correct horse battery",
+		"new_password":           "another long passphrase",
+		"revoke_current_session": true,
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/users/me", laptop, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/joaquinbian/workout-api-go/internal/jsonpatch"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
+	"github.com/joaquinbian/workout-api-go/internal/validator"
+)
+
+// las rutas de este archivo modifican un workout por partes: sus entries de a una, el orden
+// de las entries, o el documento entero con un JSON Patch. Todas piden poder editar el workout,
+// respetan su If-Match y responden con el ETag de la version nueva
+
+// maxPatchSize es el tamaño maximo de un JSON Patch, un workout entero entra de sobra
+const maxPatchSize = 1 << 20
+
+// CreateWorkoutEntry agrega una entry al workout. Sin order_index va al final
+func (wh *WorkoutHandler) CreateWorkoutEntry(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	var req struct {
+		store.WorkoutEntry
+		OrderIndex *int `json:"order_index"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		wh.logger.Printf("error: decoding workout entry: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	entry := req.WorkoutEntry
+	entry.ID = 0
+	entry.OrderIndex = nextOrderIndex(workout.Entries)
+
+	if req.OrderIndex != nil {
+		entry.OrderIndex = *req.OrderIndex
+	}
+
+	if !validEntry(w, &entry) {
+		return
+	}
+
+	event := newAuditEvent(r, auditWorkoutEntryCreate, store.AuditTargetWorkout, workout.ID)
+	event.SetAfter(&entry)
+
+	err = wh.workoutStore.CreateWorkoutEntry(withAudit(r, event), workout, &entry)
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: CreateWorkoutEntry: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	setWorkoutETag(w, workout)
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"entry": entry})
+}
+
+// UpdateWorkoutEntry modifica solo los campos que vienen en el body. Un null borra el campo,
+// asi se puede pasar de reps a duration_seconds con {"reps": null, "duration_seconds": 60}
+func (wh *WorkoutHandler) UpdateWorkoutEntry(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	entry := wh.findEntry(w, r, workout)
+
+	if entry == nil {
+		return
+	}
+
+	entryID := entry.ID
+
+	event := newAuditEvent(r, auditWorkoutEntryUpdate, store.AuditTargetWorkout, workout.ID)
+	event.SetBefore(entry)
+
+	//json.Decode solo pisa los campos que vienen en el body, el resto queda como estaba
+	err := json.NewDecoder(r.Body).Decode(entry)
+
+	if err != nil {
+		wh.logger.Printf("error: decoding workout entry: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	entry.ID = entryID
+
+	if !validEntry(w, entry) {
+		return
+	}
+
+	event.SetAfter(entry)
+
+	err = wh.workoutStore.UpdateWorkoutEntry(withAudit(r, event), workout, entry)
+
+	if apiErr := storeError(err, "entry not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: UpdateWorkoutEntry: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	setWorkoutETag(w, workout)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entry": entry})
+}
+
+func (wh *WorkoutHandler) DeleteWorkoutEntry(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	entry := wh.findEntry(w, r, workout)
+
+	if entry == nil {
+		return
+	}
+
+	event := newAuditEvent(r, auditWorkoutEntryDelete, store.AuditTargetWorkout, workout.ID)
+	event.SetBefore(entry)
+
+	err := wh.workoutStore.DeleteWorkoutEntry(withAudit(r, event), workout, int64(entry.ID))
+
+	if apiErr := storeError(err, "entry not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: DeleteWorkoutEntry: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	setWorkoutETag(w, workout)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "entry deleted"})
+}
+
+// ReorderWorkoutEntries recibe los ids de todas las entries en el orden nuevo y reescribe sus order_index
+func (wh *WorkoutHandler) ReorderWorkoutEntries(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	var req struct {
+		EntryIDs []int `json:"entry_ids"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		wh.logger.Printf("error: decoding entries order: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	entryIDs := make([]int, len(workout.Entries))
+	for i, e := range workout.Entries {
+		entryIDs[i] = e.ID
+	}
+
+	event := newAuditEvent(r, auditWorkoutEntriesOrder, store.AuditTargetWorkout, workout.ID)
+	event.SetBefore(map[string][]int{"entry_ids": entryIDs})
+	event.SetAfter(map[string][]int{"entry_ids": req.EntryIDs})
+
+	err = wh.workoutStore.ReorderWorkoutEntries(withAudit(r, event), workout, req.EntryIDs)
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: ReorderWorkoutEntries: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	reordered, err := wh.workoutStore.GetWorkoutByID(r.Context(), int64(workout.ID))
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: ReorderWorkoutEntries: reloading workout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	setWorkoutETag(w, reordered)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entries": reordered.Entries})
+}
+
+// PatchWorkout aplica un JSON Patch (RFC 6902) al workout tal como lo devuelve GET /workouts/{id}.
+// Las entries conservan su id como en UpdateWorkout. Los campos que no se pueden modificar
+// (id, user_id, created_at, share_token, version y deleted_at) se ignoran, aunque se pueden usar en un test
+func (wh *WorkoutHandler) PatchWorkout(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != jsonpatch.MediaType {
+		utils.WriteError(w, utils.NewAPIError(http.StatusUnsupportedMediaType, utils.ErrCodeUnsupportedMedia, "the content type must be "+jsonpatch.MediaType))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+
+	if err != nil {
+		wh.logger.Printf("error: reading json patch: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	patch, err := jsonpatch.Decode(body)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	//sin entries el documento tendria "entries": null y no se podria hacer add en /entries/-
+	if workout.Entries == nil {
+		workout.Entries = []store.WorkoutEntry{}
+	}
+
+	doc, err := json.Marshal(workout)
+
+	if err != nil {
+		wh.logger.Printf("error: PatchWorkout: encoding workout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	doc, err = patch.Apply(doc)
+
+	//un patch bien armado que no se puede aplicar a este workout es un conflicto con su estado actual
+	if errors.Is(err, jsonpatch.ErrPathNotFound) || errors.Is(err, jsonpatch.ErrTestFailed) {
+		utils.WriteError(w, utils.Conflict(err.Error()))
+		return
+	}
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	var patched store.Workout
+
+	err = json.Unmarshal(doc, &patched)
+
+	if err != nil {
+		utils.WriteError(w, utils.ValidationFailed("the patched workout has values of the wrong type", nil))
+		return
+	}
+
+	patched.ID = workout.ID
+	patched.UserID = workout.UserID
+	patched.CreatedAt = workout.CreatedAt
+	patched.ShareToken = workout.ShareToken
+	patched.Version = workout.Version
+	patched.DeletedAt = workout.DeletedAt
+
+	event := newAuditEvent(r, auditWorkoutUpdate, store.AuditTargetWorkout, workout.ID)
+	event.SetBefore(workout)
+
+	wh.saveWorkout(w, r, &patched, event)
+}
+
+// findEntry busca la entry {entryID} entre las del workout. Si no esta ya respondio y devuelve nil
+func (wh *WorkoutHandler) findEntry(w http.ResponseWriter, r *http.Request, workout *store.Workout) *store.WorkoutEntry {
+	entryID, err := utils.ReadIntParam(r, "entryID")
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest("invalid entry id"))
+		return nil
+	}
+
+	for i := range workout.Entries {
+		if workout.Entries[i].ID == int(entryID) {
+			return &workout.Entries[i]
+		}
+	}
+
+	utils.WriteError(w, utils.NotFound("entry not found"))
+	return nil
+}
+
+// nextOrderIndex es el order_index para agregar una entry despues de todas las demas
+func nextOrderIndex(entries []store.WorkoutEntry) int {
+	next := 0
+
+	for _, e := range entries {
+		next = max(next, e.OrderIndex+1)
+	}
+
+	return next
+}
+
+// validEntry es como validWorkout pero para una entry sola
+func validEntry(w http.ResponseWriter, entry *store.WorkoutEntry) bool {
+	v := validator.New()
+	store.ValidateWorkoutEntry(v, "", entry)
+
+	if !v.Valid() {
+		utils.WriteError(w, utils.ValidationFailed("the entry has invalid fields", v.Errors))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entryIDs devuelve los ids de las entries de un workout de la respuesta, en orden
+func entryIDs(entries any) []float64 {
+	ids := []float64{}
+	for _, e := range entries.([]any) {
+		ids = append(ids, e.(map[string]any)["id"].(float64))
+	}
+	return ids
+}
+
+func TestWorkoutEntries(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "joaquin")
+	other := registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+		"title": "legs",
+		"entries": []map[string]any{
+			{"exercise_name": "squat", "sets": 5, "reps": 5, "order_index": 0},
+			{"exercise_name": "lunge", "sets": 3, "reps": 10, "order_index": 1},
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+	workout := body["workout"].(map[string]any)
+	path := fmt.Sprintf("/workouts/%v", workout["id"])
+	ids := entryIDs(workout["entries"])
+
+	//sin order_index la entry va al final
+	status, body = doRequest(t, server, http.MethodPost, path+"/entries", owner, map[string]any{"exercise_name": "plank", "sets": 3, "duration_seconds": 60})
+	require.Equal(t, http.StatusCreated, status)
+	plank := body["entry"].(map[string]any)
+	assert.Equal(t, float64(2), plank["order_index"])
+	plankPath := fmt.Sprintf("%s/entries/%v", path, plank["id"])
+
+	status, body = doRequest(t, server, http.MethodPost, path+"/entries", owner, map[string]any{"exercise_name": "", "sets": 0, "reps": 5})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, apiError(t, body)["details"], "sets")
+
+	status, _ = doRequest(t, server, http.MethodPost, path+"/entries", other, map[string]any{"exercise_name": "spam", "sets": 1, "reps": 1})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	//PATCH solo cambia lo que viene, y un null borra el campo
+	status, body = doRequest(t, server, http.MethodPatch, plankPath, owner, map[string]any{"duration_seconds": nil, "reps": 1})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, plank["id"], body["entry"].(map[string]any)["id"])
+	assert.Equal(t, "plank", body["entry"].(map[string]any)["exercise_name"])
+	assert.Nil(t, body["entry"].(map[string]any)["duration_seconds"])
+
+	status, body = doRequest(t, server, http.MethodPatch, plankPath, owner, map[string]any{"duration_seconds": 30})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, apiError(t, body)["details"], "entry")
+
+	status, _ = doRequest(t, server, http.MethodPatch, path+"/entries/999", owner, map[string]any{"sets": 2})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	order := []float64{plank["id"].(float64), ids[1], ids[0]}
+	status, body = doRequest(t, server, http.MethodPut, path+"/entries/order", owner, map[string]any{"entry_ids": order})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, order, entryIDs(body["entries"]))
+
+	status, _ = doRequest(t, server, http.MethodPut, path+"/entries/order", owner, map[string]any{"entry_ids": order[:2]})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, plankPath, owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodDelete, plankPath, owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	//PUT con las entries que ya tienen id las conserva
+	status, body = doRequest(t, server, http.MethodGet, path, owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	entries := body["workout"].(map[string]any)["entries"].([]any)
+	entries = append(entries[:1], map[string]any{"exercise_name": "calf raise", "sets": 3, "reps": 15, "order_index": 5})
+
+	status, body = doRequest(t, server, http.MethodPut, path, owner, map[string]any{"entries": entries})
+	require.Equal(t, http.StatusOK, status)
+	updated := entryIDs(body["workout"].(map[string]any)["entries"])
+	require.Len(t, updated, 2)
+	assert.Equal(t, ids[1], updated[0])
+	assert.NotContains(t, ids, updated[1])
+}
+
+func TestPatchWorkout(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "joaquin")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+		"title":   "legs",
+		"entries": []map[string]any{{"exercise_name": "squat", "sets": 5, "reps": 5, "order_index": 0}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	workout := body["workout"].(map[string]any)
+	path := fmt.Sprintf("/workouts/%v", workout["id"])
+	squatID := entryIDs(workout["entries"])[0]
+
+	patch := func(ops ...map[string]any) (int, map[string]any) {
+		status, _, body := doRequestWithHeaders(t, server, http.MethodPatch, path, owner, map[string]string{"Content-Type": "application/json-patch+json"}, ops)
+		return status, body
+	}
+
+	status, body = patch(
+		map[string]any{"op": "test", "path": "/title", "value": "legs"},
+		map[string]any{"op": "replace", "path": "/title", "value": "leg day"},
+		map[string]any{"op": "replace", "path": "/entries/0/sets", "value": 4},
+		map[string]any{"op": "add", "path": "/entries/-", "value": map[string]any{"exercise_name": "plank", "sets": 3, "duration_seconds": 60, "order_index": 1}},
+		//los campos de solo lectura se ignoran
+		map[string]any{"op": "replace", "path": "/user_id", "value": 999},
+	)
+	require.Equal(t, http.StatusOK, status)
+	patched := body["workout"].(map[string]any)
+	assert.Equal(t, "leg day", patched["title"])
+	assert.Equal(t, workout["user_id"], patched["user_id"])
+	ids := entryIDs(patched["entries"])
+	require.Len(t, ids, 2)
+	assert.Equal(t, squatID, ids[0])
+	assert.Equal(t, float64(4), patched["entries"].([]any)[0].(map[string]any)["sets"])
+
+	status, body = patch(map[string]any{"op": "test", "path": "/title", "value": "legs"})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "conflict", apiError(t, body)["code"])
+
+	status, _ = patch(map[string]any{"op": "remove", "path": "/entries/7"})
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, _ = patch(map[string]any{"op": "merge", "path": "/title"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	//el resultado pasa por la misma validacion que un PUT
+	status, body = patch(map[string]any{"op": "replace", "path": "/title", "value": ""}, map[string]any{"op": "remove", "path": "/entries/1/duration_seconds"})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, apiError(t, body)["details"], "title")
+	assert.Contains(t, apiError(t, body)["details"], "entries[1]")
+
+	status, _ = patch(map[string]any{"op": "replace", "path": "/title", "value": 5})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = doRequest(t, server, http.MethodPatch, path, owner, []map[string]any{{"op": "replace", "path": "/title", "value": "x"}})
+	assert.Equal(t, http.StatusUnsupportedMediaType, status)
+
+	status, body = doRequest(t, server, http.MethodGet, path, owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "leg day", body["workout"].(map[string]any)["title"])
+}
@@ -1,46 +1,167 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/joaquinbian/workout-api-go/internal/middleware"
 	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
 	"github.com/joaquinbian/workout-api-go/internal/utils"
+	"github.com/joaquinbian/workout-api-go/internal/validator"
 )
 
 type WorkoutHandler struct {
-	workoutStore store.WorkoutStore
-	logger       *log.Logger
+	workoutStore  store.WorkoutStore
+	userStore     store.UserStore
+	coachingStore store.CoachingStore
+	auditStore    store.AuditStore
+	logger        *log.Logger
 }
 
-func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *WorkoutHandler {
+func NewWorkoutHandler(workoutStore store.WorkoutStore, userStore store.UserStore, coachingStore store.CoachingStore, auditStore store.AuditStore, logger *log.Logger) *WorkoutHandler {
 	return &WorkoutHandler{
-		workoutStore: workoutStore,
-		logger:       logger,
+		workoutStore:  workoutStore,
+		userStore:     userStore,
+		coachingStore: coachingStore,
+		auditStore:    auditStore,
+		logger:        logger,
 	}
 }
 
+// canView dice si user puede ver el workout: si puede editarlo (canEdit) o si el workout es para
+// seguidores y user sigue al dueño. Los workouts public_link solo se ven con el link (GetSharedWorkout)
+func (wh *WorkoutHandler) canView(ctx context.Context, user *store.User, workout *store.Workout) (bool, error) {
+	canEdit, err := wh.canEdit(ctx, user, workout.UserID)
+
+	if err != nil || canEdit {
+		return canEdit, err
+	}
+
+	if workout.Visibility != store.VisibilityFollowers {
+		return false, nil
+	}
+
+	return wh.userStore.IsFollowing(ctx, user.ID, workout.UserID)
+}
+
+// canEdit dice si user puede crear o modificar workouts de ownerID y dejarles notas: si es el
+// mismo usuario o si es su coach y el atleta lo acepto. Borrarlos y restaurarlos es solo del dueño
+func (wh *WorkoutHandler) canEdit(ctx context.Context, user *store.User, ownerID int) (bool, error) {
+	if user.ID == ownerID {
+		return true, nil
+	}
+
+	return wh.coachingStore.IsCoach(ctx, user.ID, ownerID)
+}
+
+// hideShareToken saca el token del link de los workouts que no son de user
+func hideShareToken(user *store.User, workouts ...*store.Workout) {
+	for _, workout := range workouts {
+		if workout.UserID != user.ID {
+			workout.ShareToken = nil
+		}
+	}
+}
+
+// syncShareToken genera un link nuevo cuando el workout pasa a ser public_link
+// y lo borra cuando deja de serlo, asi el link viejo deja de funcionar
+func syncShareToken(workout *store.Workout) error {
+	if workout.Visibility != store.VisibilityPublicLink {
+		workout.ShareToken = nil
+		return nil
+	}
+
+	if workout.ShareToken != nil {
+		return nil
+	}
+
+	token, err := tokens.GenerateShareToken()
+
+	if err != nil {
+		return err
+	}
+
+	workout.ShareToken = &token
+
+	return nil
+}
+
 func (wh *WorkoutHandler) GetWorkoutByID(w http.ResponseWriter, r *http.Request) {
 	workoutID, err := utils.ReadIdParam(w, r)
 
 	if err != nil {
 		wh.logger.Printf("error: ReadIdParam: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "error leyendo id de los params"})
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
 		return
 	}
 
-	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	workout, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutID)
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
 
 	if err != nil {
 		wh.logger.Printf("error: GetWorkoutByID: %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "workout no encontrado"})
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	canView, err := wh.canView(r.Context(), currentUser, workout)
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutByID: checking visibility: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//respondemos 404 y no 403 para no revelar que el workout existe
+	if !canView {
+		utils.WriteError(w, utils.NotFound("workout not found"))
 		return
 	}
 
+	if notModified(w, r, workout) {
+		return
+	}
+
+	hideShareToken(currentUser, workout)
+
+	setWorkoutETag(w, workout)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+}
+
+// GetSharedWorkout es la unica ruta de workouts que no requiere usuario: cualquiera con el link
+// puede ver el workout, pero solo para leerlo
+func (wh *WorkoutHandler) GetSharedWorkout(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	workout, err := wh.workoutStore.GetWorkoutByShareToken(r.Context(), token)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: GetSharedWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	workout.ShareToken = nil
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
 }
 
@@ -52,74 +173,226 @@ func (wh *WorkoutHandler) CreateWorkout(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		wh.logger.Printf("error: decoding workout: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "formato incorrecto"})
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
 		return
 	}
 
 	currentUser := middleware.GetUser(r)
 	if currentUser == nil || currentUser == store.AnonymousUser {
 		wh.logger.Printf("error: Create Workout handler: invalid user: %v", err)
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "debes estar logueado para ejecutar esta operacion"})
+		utils.WriteError(w, utils.Unauthorized("you must be logged to access this route"))
+		return
+	}
+
+	//un coach puede crear workouts para sus atletas mandando su user_id, sin user_id es para uno mismo
+	if workout.UserID == 0 {
+		workout.UserID = currentUser.ID
+	}
+
+	canEdit, err := wh.canEdit(r.Context(), currentUser, workout.UserID)
+
+	if err != nil {
+		wh.logger.Printf("error: creating workout: checking coach: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
-	workout.UserID = currentUser.ID
 
-	createdWorkout, err := wh.workoutStore.CreateWorkout(&workout)
+	if !canEdit {
+		utils.WriteError(w, utils.Forbidden("you can't create workouts for this user"))
+		return
+	}
+
+	if workout.Visibility == "" {
+		workout.Visibility = store.VisibilityPrivate
+	}
+
+	if !validWorkout(w, &workout) {
+		return
+	}
+
+	//el token del link lo genera el server, nunca el cliente. Y un workout nuevo nunca esta en la papelera
+	workout.ShareToken = nil
+	workout.DeletedAt = nil
+	err = syncShareToken(&workout)
+
+	if err != nil {
+		wh.logger.Printf("error: creating workout: generating share token: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	event := newAuditEvent(r, auditWorkoutCreate, store.AuditTargetWorkout, 0)
+	event.SetAfter(&workout)
+
+	createdWorkout, err := wh.workoutStore.CreateWorkout(withAudit(r, event), &workout)
+
+	//un check de la db (por ej una entry con reps y duracion) o un user_id que no existe
+	if apiErr := storeError(err, "user not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
 
 	if err != nil {
 		wh.logger.Printf("error: creating workout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "no pudimos procesar la solicitud"})
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
+	hideShareToken(currentUser, createdWorkout)
+
+	setWorkoutETag(w, createdWorkout)
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": createdWorkout})
 }
 
+// validWorkout valida el workout con store.ValidateWorkout. Si no es valido responde 422 con
+// todos los errores por campo y devuelve false
+func validWorkout(w http.ResponseWriter, workout *store.Workout) bool {
+	v := validator.New()
+	store.ValidateWorkout(v, workout)
+
+	if !v.Valid() {
+		utils.WriteError(w, utils.ValidationFailed("the workout has invalid fields", v.Errors))
+		return false
+	}
+
+	return true
+}
+
 func (wh *WorkoutHandler) GetWorkouts(w http.ResponseWriter, r *http.Request) {
 
-	workouts, err := wh.workoutStore.GetWorkouts()
+	filter, err := readWorkoutFilter(r)
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkouts: reading filter: %v", err)
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	filter.ViewerID = currentUser.ID
+
+	page, err := wh.workoutStore.GetWorkouts(r.Context(), filter)
+
+	if errors.Is(err, store.ErrInvalidCursor) {
+		utils.WriteError(w, utils.BadRequest("invalid cursor"))
+		return
+	}
 
 	if err != nil {
 		wh.logger.Printf("error: GetWorkouts: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "error obteniendo los workouts"})
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": workouts})
+	hideShareToken(currentUser, page.Workouts...)
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"workouts": page.Workouts,
+		"metadata": utils.Envelope{
+			"limit":       filter.Limit,
+			"next_cursor": page.NextCursor,
+			"has_more":    page.NextCursor != "",
+		},
+	})
 }
 
-func (wh *WorkoutHandler) UpdateWorkout(w http.ResponseWriter, r *http.Request) {
+// campos que acepta ?sort=, con un "-" adelante se ordena descendente (por ej ?sort=-calories)
+var workoutSortFields = map[string]string{
+	"created_at": store.WorkoutSortCreatedAt,
+	"duration":   store.WorkoutSortDuration,
+	"calories":   store.WorkoutSortCalories,
+}
 
-	workoutID, err := utils.ReadIdParam(w, r)
+// readWorkoutFilter lee los query params de GET /workouts:
+// limit, cursor, sort, title, user_id, min_duration, max_duration, min_calories y max_calories
+func readWorkoutFilter(r *http.Request) (store.WorkoutFilter, error) {
+	query := r.URL.Query()
+
+	filter := store.WorkoutFilter{
+		Limit:      store.DefaultWorkoutsLimit,
+		Cursor:     query.Get("cursor"),
+		SortBy:     store.WorkoutSortCreatedAt,
+		Descending: true,
+		Title:      strings.TrimSpace(query.Get("title")),
+	}
 
+	limit, err := utils.ReadIntQuery(r, "limit")
 	if err != nil {
-		wh.logger.Printf("error: ReadIdParam: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "error leyendo id de los params"})
-		return
+		return filter, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > store.MaxWorkoutsLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", store.MaxWorkoutsLimit)
+		}
+		filter.Limit = *limit
 	}
 
-	existingWorkout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if sort := query.Get("sort"); sort != "" {
+		field, descending := strings.CutPrefix(sort, "-")
+		sortBy, ok := workoutSortFields[field]
+		if !ok {
+			return filter, errors.New("invalid sort, it must be created_at, duration or calories")
+		}
+		filter.SortBy = sortBy
+		filter.Descending = descending
+	}
 
+	userID, err := utils.ReadIntQuery(r, "user_id")
 	if err != nil {
-		wh.logger.Printf("error: GetWorkoutByID: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "error al obtener el workout"})
-		return
+		return filter, err
+	}
+	if userID != nil {
+		filter.UserID = *userID
+	}
+
+	ranges := []struct {
+		key  string
+		dest **int
+	}{
+		{"min_duration", &filter.MinDuration},
+		{"max_duration", &filter.MaxDuration},
+		{"min_calories", &filter.MinCalories},
+		{"max_calories", &filter.MaxCalories},
 	}
 
+	for _, rg := range ranges {
+		*rg.dest, err = utils.ReadIntQuery(r, rg.key)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// UpdateWorkout modifica los campos que vienen en el body. Si vienen entries reemplazan a las del
+// workout: las que traen id conservan su id, las nuevas se agregan y las que no vinieron se borran
+func (wh *WorkoutHandler) UpdateWorkout(w http.ResponseWriter, r *http.Request) {
+	existingWorkout := wh.workoutForEdit(w, r)
+
 	if existingWorkout == nil {
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "workout no encontrado"})
 		return
 	}
 
+	event := newAuditEvent(r, auditWorkoutUpdate, store.AuditTargetWorkout, existingWorkout.ID)
+	event.SetBefore(existingWorkout)
+
 	var updateWorkoutRequest struct {
 		Title           *string              `json:"title"`
 		Description     *string              `json:"description"`
 		DurationMinutes *int                 `json:"duration_minutes"`
 		CaloriesBurned  *int                 `json:"calories_burned"`
+		Visibility      *string              `json:"visibility"`
 		Entries         []store.WorkoutEntry `json:"entries"`
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&updateWorkoutRequest)
+	err := json.NewDecoder(r.Body).Decode(&updateWorkoutRequest)
+
+	if err != nil {
+		wh.logger.Printf("error: decoding workout update: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
 
 	if updateWorkoutRequest.Title != nil {
 		existingWorkout.Title = *updateWorkoutRequest.Title
@@ -136,93 +409,392 @@ func (wh *WorkoutHandler) UpdateWorkout(w http.ResponseWriter, r *http.Request)
 	if updateWorkoutRequest.Entries != nil {
 		existingWorkout.Entries = updateWorkoutRequest.Entries
 	}
+	if updateWorkoutRequest.Visibility != nil {
+		existingWorkout.Visibility = *updateWorkoutRequest.Visibility
+	}
 
-	userReq := middleware.GetUser(r)
+	wh.saveWorkout(w, r, existingWorkout, event)
+}
 
-	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+// workoutForEdit busca el workout {id} y chequea que el usuario lo pueda modificar (el dueño y sus
+// coaches) y el If-Match del request, si vino. Si no, ya respondio y devuelve nil
+func (wh *WorkoutHandler) workoutForEdit(w http.ResponseWriter, r *http.Request) *store.Workout {
+	workoutID, err := utils.ReadIdParam(w, r)
 
 	if err != nil {
-		wh.logger.Printf("error: update workout: get workout owner: %v", err)
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "workout inexistente"})
-			return
-		}
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "internal server error"})
+		wh.logger.Printf("error: ReadIdParam: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
+		return nil
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutID)
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return nil
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutByID: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return nil
+	}
+
+	canEdit, err := wh.canEdit(r.Context(), middleware.GetUser(r), workout.UserID)
+
+	if err != nil {
+		wh.logger.Printf("error: workoutForEdit: checking coach: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return nil
+	}
+
+	if !canEdit {
+		wh.writeCantEdit(w, r, workout)
+		return nil
+	}
+
+	if !checkIfMatch(w, r, workout) {
+		return nil
+	}
+
+	return workout
+}
+
+// writeCantEdit responde a quien no puede modificar el workout: 403 si igual lo puede ver y 404 si no,
+// como GetWorkoutByID, para no revelar que el workout existe
+func (wh *WorkoutHandler) writeCantEdit(w http.ResponseWriter, r *http.Request, workout *store.Workout) {
+	canView, err := wh.canView(r.Context(), middleware.GetUser(r), workout)
+
+	if err != nil {
+		wh.logger.Printf("error: workoutForEdit: checking visibility: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
+	}
 
+	if !canView {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return
 	}
-	if userReq == nil || userReq == store.AnonymousUser {
-		wh.logger.Printf("error: Create Workout handler: invalid user: %v", err)
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "debes estar logueado para ejectuar esta operacion"})
+
+	utils.WriteError(w, utils.Forbidden("you can't modify this workout"))
+}
+
+// saveWorkout valida y guarda el workout ya modificado, con las mismas reglas que al crearlo,
+// y responde con el workout guardado. event es el evento de auditoria con el workout de antes del cambio
+func (wh *WorkoutHandler) saveWorkout(w http.ResponseWriter, r *http.Request, workout *store.Workout, event *store.AuditEvent) {
+	if !validWorkout(w, workout) {
 		return
 	}
 
-	if userReq.ID != workoutOwner {
-		wh.logger.Printf("error: Create Workout handler: unauthorized user: %v", err)
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "no puedes modificar este workout"})
+	err := syncShareToken(workout)
+	if err != nil {
+		wh.logger.Printf("error: saveWorkout: generating share token: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	event.SetAfter(workout)
+
+	err = wh.workoutStore.UpdateWorkout(withAudit(r, event), workout)
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
 		return
 	}
 
-	err = wh.workoutStore.UpdateWorkout(existingWorkout)
 	if err != nil {
 		wh.logger.Printf("error: UpdateWorkout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "error al modificar el workout"})
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": existingWorkout})
+	hideShareToken(middleware.GetUser(r), workout)
 
+	setWorkoutETag(w, workout)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
 }
 
+// DeleteWorkout manda el workout a la papelera, de donde se puede restaurar hasta que lo borre la purga.
+// Solo lo puede borrar el dueño, sus coaches no
 func (wh *WorkoutHandler) DeleteWorkout(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForEdit(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	if workout.UserID != middleware.GetUser(r).ID {
+		utils.WriteError(w, utils.Forbidden("only the owner can delete this workout"))
+		return
+	}
+
+	event := newAuditEvent(r, auditWorkoutDelete, store.AuditTargetWorkout, workout.ID)
+
+	err := wh.workoutStore.DeleteWorkout(withAudit(r, event), int64(workout.ID))
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: DeleteWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "workout moved to the trash"})
+}
+
+// GetDeletedWorkouts devuelve la papelera del usuario logueado: sus workouts borrados que todavia
+// no purgo la purga, los ultimos borrados primero
+func (wh *WorkoutHandler) GetDeletedWorkouts(w http.ResponseWriter, r *http.Request) {
+	workouts, err := wh.workoutStore.GetDeletedWorkouts(r.Context(), middleware.GetUser(r).ID)
+
+	if err != nil {
+		wh.logger.Printf("error: GetDeletedWorkouts: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": workouts})
+}
 
+// RestoreWorkout saca el workout {id} de la papelera. Como la papelera, es solo del dueño
+func (wh *WorkoutHandler) RestoreWorkout(w http.ResponseWriter, r *http.Request) {
 	workoutID, err := utils.ReadIdParam(w, r)
 
 	if err != nil {
 		wh.logger.Printf("error: ReadIdParam: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "error leyendo el id de los params"})
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
 		return
 	}
 
-	userReq := middleware.GetUser(r)
+	deleted, err := wh.workoutStore.GetDeletedWorkoutByID(r.Context(), workoutID)
 
-	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+	if apiErr := storeError(err, "workout not found in the trash"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
 
 	if err != nil {
-		wh.logger.Printf("error: update workout: get workout owner: %v", err)
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"message": "workout inexistente"})
-			return
-		}
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "internal server error"})
+		wh.logger.Printf("error: RestoreWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
+	}
 
+	//como en GetWorkoutByID, 404 y no 403 para no revelar que el workout existe
+	if deleted.UserID != middleware.GetUser(r).ID {
+		utils.WriteError(w, utils.NotFound("workout not found in the trash"))
+		return
 	}
-	if userReq == nil || userReq == store.AnonymousUser {
-		wh.logger.Printf("error: Create Workout handler: invalid user: %v", err)
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"message": "debes estar logueado para ejectuar esta operacion"})
+
+	event := newAuditEvent(r, auditWorkoutRestore, store.AuditTargetWorkout, deleted.ID)
+
+	err = wh.workoutStore.RestoreWorkout(withAudit(r, event), workoutID)
+
+	if apiErr := storeError(err, "workout not found in the trash"); apiErr != nil {
+		utils.WriteError(w, apiErr)
 		return
 	}
 
-	if userReq.ID != workoutOwner {
-		wh.logger.Printf("error: Create Workout handler: unauthorized user: %v", err)
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"message": "no puedes eliminar este workout"})
+	if err != nil {
+		wh.logger.Printf("error: RestoreWorkout: %v", err)
+		utils.WriteError(w, utils.InternalError())
 		return
 	}
 
-	err = wh.workoutStore.DeleteWorkout(workoutID)
+	restored, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutID)
 
-	if err == sql.ErrNoRows {
-		wh.logger.Printf("error: DeleteWorkout: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"message": "ningun workout fue eliminado"})
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
 		return
 	}
 
 	if err != nil {
-		wh.logger.Printf("error: DeleteWorkout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"message": "error eliminando el workout"})
+		wh.logger.Printf("error: RestoreWorkout: reloading workout: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	setWorkoutETag(w, restored)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": restored})
+}
+
+const maxWorkoutNoteLength = 2000
+
+// workoutForNotes busca el workout {id} y chequea que el usuario pueda leer y escribir sus notas
+// (el dueño y sus coaches). Si no, ya respondio y devuelve nil
+func (wh *WorkoutHandler) workoutForNotes(w http.ResponseWriter, r *http.Request) *store.Workout {
+	workoutID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		wh.logger.Printf("error: ReadIdParam: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
+		return nil
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return nil
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "workout eliminado"})
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutByID: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return nil
+	}
+
+	canEdit, err := wh.canEdit(r.Context(), middleware.GetUser(r), workout.UserID)
+
+	if err != nil {
+		wh.logger.Printf("error: workout notes: checking coach: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return nil
+	}
+
+	//404 y no 403, igual que GetWorkoutByID, para no revelar que el workout existe
+	if !canEdit {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return nil
+	}
+
+	return workout
+}
+
+func (wh *WorkoutHandler) GetWorkoutNotes(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForNotes(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	notes, err := wh.workoutStore.GetWorkoutNotes(r.Context(), int64(workout.ID))
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutNotes: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"notes": notes})
+}
+
+// CreateWorkoutNote deja una nota en el workout, la usan los coaches para comentarle al atleta
+func (wh *WorkoutHandler) CreateWorkoutNote(w http.ResponseWriter, r *http.Request) {
+	workout := wh.workoutForNotes(w, r)
+
+	if workout == nil {
+		return
+	}
+
+	var req struct {
+		Body string `json:"body"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		wh.logger.Printf("error: decoding workout note: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid request payload"))
+		return
+	}
+
+	req.Body = strings.TrimSpace(req.Body)
+
+	if req.Body == "" || len(req.Body) > maxWorkoutNoteLength {
+		utils.WriteError(w, utils.ValidationFailed(fmt.Sprintf("the note can't be empty or longer than %d characters", maxWorkoutNoteLength), map[string]string{"body": fmt.Sprintf("must have between 1 and %d characters", maxWorkoutNoteLength)}))
+		return
+	}
+
+	note := &store.WorkoutNote{WorkoutID: workout.ID, AuthorID: middleware.GetUser(r).ID, Body: req.Body}
+
+	event := newAuditEvent(r, auditWorkoutNoteCreate, store.AuditTargetWorkout, workout.ID)
+	event.SetAfter(note)
+
+	err = wh.workoutStore.CreateWorkoutNote(withAudit(r, event), note)
+
+	//el workout se borro entre medio
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: CreateWorkoutNote: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"note": note})
+}
+
+// GetWorkoutHistory devuelve los eventos de auditoria del workout {id}, los mas nuevos primero:
+// quien lo cambio, cuando y que campos. La ven los que pueden editar el workout, tambien si esta
+// en la papelera. Acepta before_id (el id del ultimo evento de la pagina anterior) y limit
+func (wh *WorkoutHandler) GetWorkoutHistory(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIdParam(w, r)
+
+	if err != nil {
+		wh.logger.Printf("error: ReadIdParam: %v", err)
+		utils.WriteError(w, utils.BadRequest("invalid workout id"))
+		return
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		workout, err = wh.workoutStore.GetDeletedWorkoutByID(r.Context(), workoutID)
+	}
+
+	if apiErr := storeError(err, "workout not found"); apiErr != nil {
+		utils.WriteError(w, apiErr)
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutHistory: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	canEdit, err := wh.canEdit(r.Context(), middleware.GetUser(r), workout.UserID)
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutHistory: checking coach: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//404 y no 403, igual que GetWorkoutByID, para no revelar que el workout existe
+	if !canEdit {
+		utils.WriteError(w, utils.NotFound("workout not found"))
+		return
+	}
+
+	filter := store.AuditFilter{TargetType: store.AuditTargetWorkout, TargetID: int64(workout.ID)}
+
+	filter.BeforeID, filter.Limit, err = readAuditPage(r)
+
+	if err != nil {
+		utils.WriteError(w, utils.BadRequest(err.Error()))
+		return
+	}
+
+	events, err := wh.auditStore.GetAuditEvents(r.Context(), filter)
+
+	if err != nil {
+		wh.logger.Printf("error: GetWorkoutHistory: %v", err)
+		utils.WriteError(w, utils.InternalError())
+		return
+	}
+
+	//la ip de quien hizo el cambio solo la ven los admins
+	for _, event := range events {
+		event.IP = ""
+	}
 
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"events": events})
 }
@@ -0,0 +1,435 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkoutHandlerCRUD(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{
+		"title":            "push day",
+		"duration_minutes": 60,
+		"entries": []map[string]any{
+			{"exercise_name": "Bench press", "sets": 3, "reps": 10, "order_index": 1},
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	workout := body["workout"].(map[string]any)
+	path := fmt.Sprintf("/workouts/%v", workout["id"])
+
+	status, body = doRequest(t, server, http.MethodGet, path, token, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "push day", body["workout"].(map[string]any)["title"])
+
+	status, body = doRequest(t, server, http.MethodPut, path, token, map[string]any{"title": "pull day"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "pull day", body["workout"].(map[string]any)["title"])
+
+	status, body = doRequest(t, server, http.MethodGet, "/workouts", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["workouts"], 1)
+
+	status, _ = doRequest(t, server, http.MethodDelete, path, token, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, path, token, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestWorkoutHandlerRequiresUser(t *testing.T) {
+	server := newTestServer(t)
+
+	status, _ := doRequest(t, server, http.MethodGet, "/workouts", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts", "not-a-token", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestWorkoutHandlerOwnership(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "owner")
+	other := registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+		"title":            "leg day",
+		"duration_minutes": 45,
+	})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	//el workout es privado, para other no existe
+	status, _ = doRequest(t, server, http.MethodPut, path, other, map[string]any{"title": "mine now"})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, path, other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/workouts/999", owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestWorkoutHandlerInvalidEntry(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	//todos los errores vienen juntos, los de las entries con su indice
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{
+		"title":            " ",
+		"duration_minutes": -10,
+		"entries": []map[string]any{
+			{"exercise_name": "squats", "sets": 4, "reps": 12, "order_index": 0},
+			{"exercise_name": "squats", "sets": 4, "reps": 12, "duration_seconds": 60, "order_index": 1},
+			{"exercise_name": "", "sets": 0, "duration_seconds": 60, "order_index": 2},
+		},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	apiErr := apiError(t, body)
+	assert.Equal(t, "validation_failed", apiErr["code"])
+
+	details := apiErr["details"].(map[string]any)
+	assert.Len(t, details, 5)
+	for _, field := range []string{"title", "duration_minutes", "entries[1]", "entries[2].exercise_name", "entries[2].sets"} {
+		assert.Contains(t, details, field)
+	}
+
+	status, body = doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{"title": "valid"})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	//al modificar se valida el workout completo, con las mismas reglas
+	status, body = doRequest(t, server, http.MethodPut, path, token, map[string]any{
+		"entries": []map[string]any{{"exercise_name": "plank", "sets": 3, "order_index": 0}},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, apiError(t, body)["details"], "entries[0]")
+
+	status, _ = doRequest(t, server, http.MethodPut, path, token, map[string]any{"calories_burned": -1})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestGetWorkoutsPagination(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	for i := 1; i <= 3; i++ {
+		status, _ := doRequest(t, server, http.MethodPost, "/workouts", token, map[string]any{
+			"title":            fmt.Sprintf("workout %d", i),
+			"duration_minutes": i * 10,
+		})
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	status, body := doRequest(t, server, http.MethodGet, "/workouts?limit=2&sort=duration", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body["workouts"], 2)
+
+	metadata := body["metadata"].(map[string]any)
+	assert.Equal(t, true, metadata["has_more"])
+	assert.EqualValues(t, 2, metadata["limit"])
+
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("/workouts?limit=2&sort=duration&cursor=%s", metadata["next_cursor"]), token, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body["workouts"], 1)
+	assert.Equal(t, "workout 3", body["workouts"].([]any)[0].(map[string]any)["title"])
+	assert.Equal(t, false, body["metadata"].(map[string]any)["has_more"])
+
+	status, body = doRequest(t, server, http.MethodGet, "/workouts?title=WORKOUT%202&max_duration=30", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body["workouts"], 1)
+
+	for _, query := range []string{"limit=0", "limit=abc", "sort=weight", "min_calories=x", "cursor=bogus"} {
+		status, _ = doRequest(t, server, http.MethodGet, "/workouts?"+query, token, nil)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func TestWorkoutVisibility(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "owner")
+	follower := registerAndLogin(t, server, "follower")
+	stranger := registerAndLogin(t, server, "stranger")
+
+	create := func(title, visibility string) map[string]any {
+		status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+			"title":      title,
+			"visibility": visibility,
+		})
+		require.Equal(t, http.StatusOK, status)
+		return body["workout"].(map[string]any)
+	}
+
+	private := create("private", "")
+	assert.Equal(t, "private", private["visibility"])
+	followers := create("followers", "followers")
+	public := create("public", "public_link")
+
+	shareToken, ok := public["share_token"].(string)
+	require.True(t, ok)
+
+	status, _ := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{"title": "x", "visibility": "everyone"})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/users/%v/follow", private["user_id"]), follower, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	listLen := func(token string) int {
+		status, body := doRequest(t, server, http.MethodGet, "/workouts", token, nil)
+		require.Equal(t, http.StatusOK, status)
+		return len(body["workouts"].([]any))
+	}
+
+	assert.Equal(t, 3, listLen(owner))
+	assert.Equal(t, 1, listLen(follower))
+	assert.Equal(t, 0, listLen(stranger))
+
+	get := func(workout map[string]any, token string) int {
+		status, _ := doRequest(t, server, http.MethodGet, fmt.Sprintf("/workouts/%v", workout["id"]), token, nil)
+		return status
+	}
+
+	assert.Equal(t, http.StatusOK, get(followers, follower))
+	assert.Equal(t, http.StatusNotFound, get(private, follower))
+	assert.Equal(t, http.StatusNotFound, get(followers, stranger))
+	//el link es la unica forma de ver un workout public_link
+	assert.Equal(t, http.StatusNotFound, get(public, stranger))
+
+	//modificar un workout ajeno da 403 si se puede ver y 404 si no, para no revelar que existe
+	put := func(workout map[string]any, token string) int {
+		status, _ := doRequest(t, server, http.MethodPut, fmt.Sprintf("/workouts/%v", workout["id"]), token, map[string]any{"title": "mine now"})
+		return status
+	}
+
+	assert.Equal(t, http.StatusForbidden, put(followers, follower))
+	assert.Equal(t, http.StatusNotFound, put(private, follower))
+	assert.Equal(t, http.StatusNotFound, put(followers, stranger))
+
+	status, body := doRequest(t, server, http.MethodGet, "/shared/workouts/"+shareToken, "", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "public", body["workout"].(map[string]any)["title"])
+	assert.Nil(t, body["workout"].(map[string]any)["share_token"])
+
+	status, _ = doRequest(t, server, http.MethodGet, "/shared/workouts/not-a-token", "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	//al hacerlo privado el link deja de funcionar
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/workouts/%v", public["id"]), owner, map[string]any{"visibility": "private"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/shared/workouts/"+shareToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/users/%v/follow", private["user_id"]), follower, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 0, listLen(follower))
+
+	//el dueño tambien puede sacar a un seguidor
+	status, _ = doRequest(t, server, http.MethodPut, fmt.Sprintf("/users/%v/follow", private["user_id"]), follower, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, http.StatusOK, get(followers, follower))
+
+	status, body = doRequest(t, server, http.MethodPost, "/workouts", follower, map[string]any{"title": "mine"})
+	require.Equal(t, http.StatusOK, status)
+	followerID := body["workout"].(map[string]any)["user_id"]
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/users/me/followers/%v", followerID), owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, http.StatusNotFound, get(followers, follower))
+	//en el listado solo le queda el suyo
+	assert.Equal(t, 1, listLen(follower))
+
+	status, _ = doRequest(t, server, http.MethodDelete, fmt.Sprintf("/users/me/followers/%v", followerID), owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestWorkoutETag(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	status, headers, body := doRequestWithHeaders(t, server, http.MethodPost, "/workouts", token, nil, map[string]any{
+		"title":   "legs",
+		"entries": []map[string]any{{"exercise_name": "squat", "sets": 5, "reps": 5, "order_index": 0}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `"1"`, headers.Get("ETag"))
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	status, headers, _ = doRequestWithHeaders(t, server, http.MethodGet, path, token, nil, nil)
+	require.Equal(t, http.StatusOK, status)
+	etag := headers.Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	status, _, body = doRequestWithHeaders(t, server, http.MethodGet, path, token, map[string]string{"If-None-Match": etag}, nil)
+	assert.Equal(t, http.StatusNotModified, status)
+	assert.Nil(t, body)
+
+	status, headers, body = doRequestWithHeaders(t, server, http.MethodPut, path, token, map[string]string{"If-Match": etag}, map[string]any{"title": "leg day"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `"2"`, headers.Get("ETag"))
+	assert.Equal(t, float64(2), body["workout"].(map[string]any)["version"])
+
+	//con la version vieja no se pisa el cambio anterior
+	status, headers, body = doRequestWithHeaders(t, server, http.MethodPut, path, token, map[string]string{"If-Match": etag}, map[string]any{"title": "legs again"})
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	assert.Equal(t, "precondition_failed", apiError(t, body)["code"])
+	assert.Equal(t, `"2"`, headers.Get("ETag"))
+
+	status, _, _ = doRequestWithHeaders(t, server, http.MethodGet, path, token, map[string]string{"If-None-Match": etag}, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	//las entries tambien cambian la version del workout
+	status, headers, _ = doRequestWithHeaders(t, server, http.MethodPost, path+"/entries", token, map[string]string{"If-Match": `"2"`}, map[string]any{"exercise_name": "plank", "sets": 3, "duration_seconds": 60})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, `"3"`, headers.Get("ETag"))
+
+	status, headers, _ = doRequestWithHeaders(t, server, http.MethodPatch, path, token, map[string]string{"Content-Type": "application/json-patch+json", "If-Match": `"3"`}, []map[string]any{
+		{"op": "replace", "path": "/title", "value": "legs"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `"4"`, headers.Get("ETag"))
+
+	status, _, _ = doRequestWithHeaders(t, server, http.MethodDelete, path, token, map[string]string{"If-Match": `"3"`}, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+
+	status, _, _ = doRequestWithHeaders(t, server, http.MethodDelete, path, token, map[string]string{"If-Match": `"4"`}, nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestWorkoutTrash(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "joaquin")
+	other := registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+		"title":   "legs",
+		"entries": []map[string]any{{"exercise_name": "squat", "sets": 5, "reps": 5, "order_index": 0}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	status, _ = doRequest(t, server, http.MethodDelete, path, owner, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, path, owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, body = doRequest(t, server, http.MethodGet, "/workouts", owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body["workouts"])
+
+	status, body = doRequest(t, server, http.MethodGet, "/workouts/trash", owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	trash := body["workouts"].([]any)
+	require.Len(t, trash, 1)
+	assert.Equal(t, "legs", trash[0].(map[string]any)["title"])
+	assert.NotNil(t, trash[0].(map[string]any)["deleted_at"])
+
+	//la papelera de cada usuario es solo suya
+	status, body = doRequest(t, server, http.MethodGet, "/workouts/trash", other, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body["workouts"])
+
+	status, _ = doRequest(t, server, http.MethodPost, path+"/restore", other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, headers, body := doRequestWithHeaders(t, server, http.MethodPost, path+"/restore", owner, nil, nil)
+	require.Equal(t, http.StatusOK, status)
+	restored := body["workout"].(map[string]any)
+	assert.Nil(t, restored["deleted_at"])
+	assert.Len(t, restored["entries"], 1)
+	assert.Equal(t, `"3"`, headers.Get("ETag"))
+
+	status, body = doRequest(t, server, http.MethodPost, path+"/restore", owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "not_found", apiError(t, body)["code"])
+
+	status, _ = doRequest(t, server, http.MethodGet, path, owner, nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestWorkoutHistory(t *testing.T) {
+	server := newTestServer(t)
+	owner := registerAndLogin(t, server, "joaquin")
+	other := registerAndLogin(t, server, "other")
+
+	status, body := doRequest(t, server, http.MethodPost, "/workouts", owner, map[string]any{
+		"title":   "legs",
+		"entries": []map[string]any{{"exercise_name": "squat", "sets": 5, "reps": 5, "order_index": 0}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	status, _ = doRequest(t, server, http.MethodPut, path, owner, map[string]any{"title": "leg day"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = doRequest(t, server, http.MethodDelete, path, owner, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	//el historial sigue disponible con el workout en la papelera
+	status, body = doRequest(t, server, http.MethodGet, path+"/history", owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	events := body["events"].([]any)
+	require.Len(t, events, 3)
+
+	deleted := events[0].(map[string]any)
+	assert.Equal(t, "workout.delete", deleted["action"])
+	assert.NotEmpty(t, deleted["request_id"])
+	assert.Nil(t, deleted["ip"])
+
+	updated := events[1].(map[string]any)
+	assert.Equal(t, "workout.update", updated["action"])
+	assert.Equal(t, "joaquin", updated["actor_username"])
+	assert.Equal(t, map[string]any{"title": map[string]any{"before": "legs", "after": "leg day"}}, updated["changes"])
+
+	assert.Equal(t, "workout.create", events[2].(map[string]any)["action"])
+
+	status, body = doRequest(t, server, http.MethodGet, fmt.Sprintf("%s/history?limit=1&before_id=%v", path, updated["id"]), owner, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body["events"], 1)
+	assert.Equal(t, "workout.create", body["events"].([]any)[0].(map[string]any)["action"])
+
+	status, _ = doRequest(t, server, http.MethodGet, path+"/history?limit=0", owner, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	//solo lo ve quien puede editar el workout
+	status, _ = doRequest(t, server, http.MethodGet, path+"/history", other, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, server, http.MethodGet, "/workouts/999/history", owner, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestWorkoutHistoryRequestID(t *testing.T) {
+	server := newTestServer(t)
+	token := registerAndLogin(t, server, "joaquin")
+
+	//el X-Request-Id del cliente se guarda tal cual si es razonable
+	status, _, body := doRequestWithHeaders(t, server, http.MethodPost, "/workouts", token, map[string]string{"X-Request-Id": "client-id-1"}, map[string]any{"title": "legs"})
+	require.Equal(t, http.StatusOK, status)
+	path := fmt.Sprintf("/workouts/%v", body["workout"].(map[string]any)["id"])
+
+	//uno muy largo o que no es ascii no rompe el cambio, se reemplaza por uno del server
+	for i, requestID := range []string{strings.Repeat("x", 300), "ñandú"} {
+		status, _, _ = doRequestWithHeaders(t, server, http.MethodPut, path, token, map[string]string{"X-Request-Id": requestID}, map[string]any{"title": fmt.Sprintf("leg day %d", i)})
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	status, body = doRequest(t, server, http.MethodGet, path+"/history", token, nil)
+	require.Equal(t, http.StatusOK, status)
+	events := body["events"].([]any)
+	require.Len(t, events, 3)
+
+	for _, e := range events[:2] {
+		requestID := e.(map[string]any)["request_id"].(string)
+		assert.True(t, strings.HasPrefix(requestID, "server-"), requestID)
+		assert.LessOrEqual(t, len(requestID), 128)
+	}
+	assert.Equal(t, "client-id-1", events[2].(map[string]any)["request_id"])
+}
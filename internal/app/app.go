@@ -3,64 +3,205 @@ package app
 import (
 	"database/sql"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 
 	"github.com/joaquinbian/workout-api-go/internal/api"
+	"github.com/joaquinbian/workout-api-go/internal/config"
+	"github.com/joaquinbian/workout-api-go/internal/mailer"
 	"github.com/joaquinbian/workout-api-go/internal/middleware"
 	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
 	"github.com/joaquinbian/workout-api-go/migrations"
+	"github.com/pressly/goose/v3"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHandler   *api.TokenHandler
-	Middleware     middleware.UserMiddleware
-	DB             *sql.DB
+	Config          *config.Config
+	Logger          *log.Logger
+	WorkoutHandler  *api.WorkoutHandler
+	UserHandler     *api.UserHandler
+	TokenHandler    *api.TokenHandler
+	APIKeyHandler   *api.APIKeyHandler
+	AdminHandler    *api.AdminHandler
+	CoachingHandler *api.CoachingHandler
+	Middleware      middleware.UserMiddleware
+	DB              *sql.DB
+
+	//para las tareas que corren fuera de los requests, como PurgeTrash
+	workoutStore store.WorkoutStore
+	errorLogger  *log.Logger
 }
 
-func NewApplication() (*Application, error) {
+func NewApplication(cfg *config.Config) (*Application, error) {
 
 	//vamos a usar logger para los logs pq nos da un mejor manejo de ellos y nos ayuda a saber que esta pasando
 	//con logger se puede manejar mejor diferentes tipos de logs como errores, logs para debugging, etc
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
+	//logger es para mensajes informativos y errorLogger para los errores de los handlers,
+	//los dos filtran segun cfg.LogLevel
+	logger, errorLogger, err := newLoggers(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	store.SetBcryptCost(cfg.BcryptCost)
 
 	//store
-	db, err := store.Open()
+	var (
+		db            *sql.DB
+		migrationsFS  fs.FS
+		migrationsDir string
+		workoutStore  store.WorkoutStore
+		userStore     store.UserStore
+		tokenStore    store.TokenStore
+		loginStore    store.LoginAttemptStore
+		apiKeyStore   store.APIKeyStore
+		coachingStore store.CoachingStore
+		auditStore    store.AuditStore
+	)
+
+	switch cfg.DB.Driver {
+	case store.DriverPostgres:
+		db, err = store.Open(cfg.DB.DSN)
+		if err != nil {
+			return nil, err
+		}
+		migrationsFS, migrationsDir = migrations.FS, "."
+
+		workoutStore = store.NewPostgresWorkoutStore(db, cfg.DB.QueryTimeout)
+		userStore = store.NewPostgresUserStore(db, cfg.DB.QueryTimeout)
+		tokenStore = store.NewPostgresTokenStore(db, cfg.DB.QueryTimeout)
+		loginStore = store.NewPostgresLoginAttemptStore(db, cfg.DB.QueryTimeout)
+		apiKeyStore = store.NewPostgresAPIKeyStore(db, cfg.DB.QueryTimeout)
+		coachingStore = store.NewPostgresCoachingStore(db, cfg.DB.QueryTimeout)
+		auditStore = store.NewPostgresAuditStore(db, cfg.DB.QueryTimeout)
+	case store.DriverSQLite:
+		db, err = store.OpenSQLite(cfg.DB.DSN)
+		if err != nil {
+			return nil, err
+		}
+		migrationsFS, migrationsDir = migrations.SQLiteFS, "sqlite"
+
+		workoutStore = store.NewSQLiteWorkoutStore(db, cfg.DB.QueryTimeout)
+		userStore = store.NewSQLiteUserStore(db, cfg.DB.QueryTimeout)
+		tokenStore = store.NewSQLiteTokenStore(db, cfg.DB.QueryTimeout)
+		loginStore = store.NewSQLiteLoginAttemptStore(db, cfg.DB.QueryTimeout)
+		apiKeyStore = store.NewSQLiteAPIKeyStore(db, cfg.DB.QueryTimeout)
+		coachingStore = store.NewSQLiteCoachingStore(db, cfg.DB.QueryTimeout)
+		auditStore = store.NewSQLiteAuditStore(db, cfg.DB.QueryTimeout)
+	default:
+		return nil, fmt.Errorf("unknown db driver %q", cfg.DB.Driver)
+	}
+
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxIdleTime(cfg.DB.ConnMaxIdleTime)
+
+	err = db.Ping()
 	if err != nil {
-		return nil, err
+		db.Close()
+		return nil, fmt.Errorf("db: ping: %w", err)
 	}
-	err = store.MigrateFS(db, migrations.FS, ".")
 
+	logger.Printf("Successfully connected to %s database", cfg.DB.Driver)
+
+	if cfg.DB.AutoMigrate {
+		goose.SetLogger(logger)
+
+		err = store.MigrateFS(db, cfg.DB.Driver, migrationsFS, migrationsDir)
+
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	m, err := newMailer(cfg.Mailer)
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	workoutStore := store.NewPostgresWorkoutStore(db)
-	userStore := store.NewPostgresUserStore(db)
-	tokenStore := store.NewPostgresTokenStore(db)
+	var jwtManager *tokens.JWTManager
+	if cfg.Tokens.UsesJWT() {
+		jwtManager, err = tokens.NewJWTManager(cfg.JWT.Algorithm, cfg.JWT.Keys, cfg.JWT.ActiveKeyID, cfg.JWT.Issuer)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 
 	//handlers
-	workoutHandler := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHander(tokenStore, userStore, logger)
-	middlewareHandler := middleware.UserMiddleware{UserStore: userStore}
+	workoutHandler := api.NewWorkoutHandler(workoutStore, userStore, coachingStore, auditStore, errorLogger)
+	userHandler := api.NewUserHandler(userStore, tokenStore, cfg.Tokens, m, errorLogger)
+	tokenHandler := api.NewTokenHander(tokenStore, userStore, loginStore, cfg.Tokens, cfg.Login, jwtManager, m, errorLogger)
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeyStore, errorLogger)
+	suspensions := middleware.NewSuspensionCache(userStore, middleware.SuspensionTTL)
+	adminHandler := api.NewAdminHandler(userStore, tokenStore, workoutStore, auditStore, suspensions, errorLogger)
+	coachingHandler := api.NewCoachingHandler(coachingStore, errorLogger)
+	middlewareHandler := middleware.UserMiddleware{UserStore: userStore, APIKeyStore: apiKeyStore, JWT: jwtManager, Suspensions: suspensions}
 
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHandler,
-		UserHandler:    userHandler,
-		TokenHandler:   tokenHandler,
-		Middleware:     middlewareHandler,
-		DB:             db,
+		Config:          cfg,
+		Logger:          logger,
+		WorkoutHandler:  workoutHandler,
+		UserHandler:     userHandler,
+		TokenHandler:    tokenHandler,
+		APIKeyHandler:   apiKeyHandler,
+		AdminHandler:    adminHandler,
+		CoachingHandler: coachingHandler,
+		Middleware:      middlewareHandler,
+		DB:              db,
+		workoutStore:    workoutStore,
+		errorLogger:     errorLogger,
 	}
 
 	return app, nil
 }
 
+// newMailer arma el mailer segun la config. El de log escribe en un archivo (que queda abierto
+// mientras corra la app) o en stdout
+func newMailer(cfg config.MailerConfig) (mailer.Mailer, error) {
+	if cfg.Driver == "smtp" {
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}), nil
+	}
+
+	if cfg.File == "" {
+		return mailer.NewWriterMailer(os.Stdout, cfg.From), nil
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: opening %s: %w", cfg.File, err)
+	}
+
+	return mailer.NewWriterMailer(f, cfg.From), nil
+}
+
+// newLoggers devuelve dos *log.Logger sobre el mismo slog handler: uno que escribe con nivel INFO
+// y otro con nivel ERROR. Asi los handlers siguen usando *log.Logger y el nivel se filtra en un solo lugar
+func newLoggers(level string) (*log.Logger, *log.Logger, error) {
+	var lvl slog.Level
+
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, nil, fmt.Errorf("log level: %w", err)
+	}
+
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: lvl})
+
+	return slog.NewLogLogger(handler, slog.LevelInfo), slog.NewLogLogger(handler, slog.LevelError), nil
+}
+
 func (a *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	//w: interface usada por HTTP handlers para crear respuestas HTTP
 	//	con el contestamos al cliente
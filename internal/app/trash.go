@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// PurgeTrash borra para siempre los workouts que estan en la papelera hace mas de Trash.Retention.
+// Purga una vez al arrancar y despues cada Trash.PurgeInterval, hasta que se cancela ctx
+func (a *Application) PurgeTrash(ctx context.Context) {
+	ticker := time.NewTicker(a.Config.Trash.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.workoutStore.PurgeDeletedWorkouts(ctx, time.Now().Add(-a.Config.Trash.Retention))
+
+		if err != nil {
+			a.errorLogger.Printf("error: purging trash: %v", err)
+		} else if purged > 0 {
+			a.Logger.Printf("Purged %d workouts from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
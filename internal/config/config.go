@@ -0,0 +1,393 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mismos valores que store.DriverPostgres y store.DriverSQLite, config no importa store
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+const (
+	mailerLog  = "log"
+	mailerSMTP = "smtp"
+)
+
+// formatos de los tokens de auth: opaque se busca en la db en cada request, jwt se valida con la firma
+const (
+	tokenFormatOpaque = "opaque"
+	tokenFormatJWT    = "jwt"
+)
+
+// mismos valores que tokens.JWTAlgorithmEdDSA y tokens.JWTAlgorithmHS256
+const (
+	jwtAlgorithmEdDSA = "EdDSA"
+	jwtAlgorithmHS256 = "HS256"
+)
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Config es toda la configuracion de la app. Se carga una sola vez al arrancar con Load
+// y despues se pasa a quien la necesite
+type Config struct {
+	Port       int
+	LogLevel   string
+	BcryptCost int
+	DB         DBConfig
+	Server     ServerConfig
+	Tokens     TokensConfig
+	Mailer     MailerConfig
+	Login      LoginConfig
+	JWT        JWTConfig
+	Trash      TrashConfig
+}
+
+type DBConfig struct {
+	Driver string
+	//para postgres es el connection string, para sqlite el path del archivo
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	//cuanto puede tardar como maximo cada llamada a un store, 0 es sin limite
+	QueryTimeout time.Duration
+	//si es false las migraciones no se corren al arrancar
+	AutoMigrate bool
+}
+
+type ServerConfig struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+type TokensConfig struct {
+	Format           string
+	AuthTTL          time.Duration
+	RefreshTTL       time.Duration
+	PasswordResetTTL time.Duration
+	ActivationTTL    time.Duration
+	MFATTL           time.Duration
+}
+
+// LoginConfig es la proteccion contra fuerza bruta del login. Despues de MaxAttempts fallos seguidos
+// (por username) o MaxAttemptsPerIP (por IP) se bloquea Lockout, y cada fallo mas duplica el bloqueo
+// hasta MaxLockout. La cuenta de fallos se olvida si pasa MaxLockout sin fallar
+type LoginConfig struct {
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	Lockout          time.Duration
+	MaxLockout       time.Duration
+}
+
+// TrashConfig es la papelera de workouts: un workout borrado se puede restaurar durante Retention.
+// Cada PurgeInterval se borran para siempre los que estan en la papelera hace mas que eso
+type TrashConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// JWTConfig son las keys para firmar los tokens de auth cuando Tokens.Format es jwt.
+// Keys tiene pares kid:key en base64 separados por comas; se firma con ActiveKeyID
+// (o la primera) y se validan todas, asi se puede rotar la key
+type JWTConfig struct {
+	Algorithm   string
+	Keys        string
+	ActiveKeyID string
+	Issuer      string
+}
+
+// UsesJWT dice si los tokens de auth son JWT
+func (c TokensConfig) UsesJWT() bool {
+	return c.Format == tokenFormatJWT
+}
+
+type MailerConfig struct {
+	//log escribe los mails en File (o stdout si esta vacio), smtp los manda de verdad
+	Driver       string
+	File         string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+const defaultPostgresDSN = "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable"
+const defaultSQLitePath = "workouts.db"
+
+// Load arma la config con esta prioridad (de menor a mayor):
+// valores por defecto, archivo .env (si existe), variables de entorno y flags.
+// Devuelve error si algun valor no se puede parsear o no es valido
+func Load(args []string) (*Config, error) {
+	return load(args, ".env")
+}
+
+func load(args []string, envFile string) (*Config, error) {
+	//godotenv no pisa las variables que ya estan en el entorno, por eso el entorno tiene prioridad sobre el .env
+	err := godotenv.Load(envFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("config: loading %s: %w", envFile, err)
+	}
+
+	env := &envReader{}
+	cfg := &Config{
+		Port:       env.int("PORT", 8080),
+		LogLevel:   env.string("LOG_LEVEL", "info"),
+		BcryptCost: env.int("BCRYPT_COST", 12),
+		DB: DBConfig{
+			Driver:          env.string("DB_DRIVER", driverPostgres),
+			DSN:             env.string("DB_DSN", ""),
+			MaxOpenConns:    env.int("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    env.int("DB_MAX_IDLE_CONNS", 25),
+			ConnMaxIdleTime: env.duration("DB_CONN_MAX_IDLE_TIME", 15*time.Minute),
+			QueryTimeout:    env.duration("DB_QUERY_TIMEOUT", 5*time.Second),
+			AutoMigrate:     env.bool("DB_AUTO_MIGRATE", true),
+		},
+		Server: ServerConfig{
+			ReadTimeout:  env.duration("SERVER_READ_TIMEOUT", 10*time.Second),
+			WriteTimeout: env.duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:  env.duration("SERVER_IDLE_TIMEOUT", time.Minute),
+		},
+		Tokens: TokensConfig{
+			Format:           env.string("TOKEN_FORMAT", tokenFormatOpaque),
+			AuthTTL:          env.duration("TOKEN_AUTH_TTL", 15*time.Minute),
+			RefreshTTL:       env.duration("TOKEN_REFRESH_TTL", 30*24*time.Hour),
+			PasswordResetTTL: env.duration("TOKEN_PASSWORD_RESET_TTL", 30*time.Minute),
+			ActivationTTL:    env.duration("TOKEN_ACTIVATION_TTL", 3*24*time.Hour),
+			MFATTL:           env.duration("TOKEN_MFA_TTL", 5*time.Minute),
+		},
+		Login: LoginConfig{
+			MaxAttempts:      env.int("LOGIN_MAX_ATTEMPTS", 5),
+			MaxAttemptsPerIP: env.int("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+			Lockout:          env.duration("LOGIN_LOCKOUT", time.Minute),
+			MaxLockout:       env.duration("LOGIN_MAX_LOCKOUT", time.Hour),
+		},
+		JWT: JWTConfig{
+			Algorithm:   env.string("JWT_ALGORITHM", jwtAlgorithmEdDSA),
+			Keys:        env.string("JWT_KEYS", ""),
+			ActiveKeyID: env.string("JWT_ACTIVE_KEY_ID", ""),
+			Issuer:      env.string("JWT_ISSUER", "workout-api"),
+		},
+		Trash: TrashConfig{
+			Retention:     env.duration("TRASH_RETENTION", 30*24*time.Hour),
+			PurgeInterval: env.duration("TRASH_PURGE_INTERVAL", time.Hour),
+		},
+		Mailer: MailerConfig{
+			Driver:       env.string("MAILER_DRIVER", mailerLog),
+			File:         env.string("MAILER_FILE", ""),
+			From:         env.string("MAILER_FROM", "Workout API <no-reply@workout-api.local>"),
+			SMTPHost:     env.string("SMTP_HOST", ""),
+			SMTPPort:     env.int("SMTP_PORT", 587),
+			SMTPUsername: env.string("SMTP_USERNAME", ""),
+			SMTPPassword: env.string("SMTP_PASSWORD", ""),
+		},
+	}
+
+	if env.err != nil {
+		return nil, env.err
+	}
+
+	//los flags usan como default lo que vino del entorno, asi solo pisan lo que se pasa explicitamente
+	fl := flag.NewFlagSet("workout-api", flag.ContinueOnError)
+	fl.IntVar(&cfg.Port, "port", cfg.Port, "Server port (PORT)")
+	fl.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level: debug|info|warn|error (LOG_LEVEL)")
+	fl.IntVar(&cfg.BcryptCost, "bcrypt-cost", cfg.BcryptCost, "bcrypt cost for password hashes (BCRYPT_COST)")
+	fl.StringVar(&cfg.DB.Driver, "db", cfg.DB.Driver, "Database driver: postgres|sqlite (DB_DRIVER)")
+	fl.StringVar(&cfg.DB.DSN, "db-dsn", cfg.DB.DSN, "Postgres connection string or SQLite file path (DB_DSN)")
+	fl.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", cfg.DB.MaxOpenConns, "Max open DB connections, 0 is unlimited (DB_MAX_OPEN_CONNS)")
+	fl.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", cfg.DB.MaxIdleConns, "Max idle DB connections (DB_MAX_IDLE_CONNS)")
+	fl.DurationVar(&cfg.DB.ConnMaxIdleTime, "db-conn-max-idle-time", cfg.DB.ConnMaxIdleTime, "Max time a DB connection can stay idle (DB_CONN_MAX_IDLE_TIME)")
+	fl.DurationVar(&cfg.DB.QueryTimeout, "db-query-timeout", cfg.DB.QueryTimeout, "Max time for each database call, 0 is unlimited (DB_QUERY_TIMEOUT)")
+	fl.BoolVar(&cfg.DB.AutoMigrate, "db-auto-migrate", cfg.DB.AutoMigrate, "Run migrations at startup (DB_AUTO_MIGRATE)")
+	fl.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", cfg.Server.ReadTimeout, "HTTP server read timeout (SERVER_READ_TIMEOUT)")
+	fl.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", cfg.Server.WriteTimeout, "HTTP server write timeout (SERVER_WRITE_TIMEOUT)")
+	fl.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", cfg.Server.IdleTimeout, "HTTP server idle timeout (SERVER_IDLE_TIMEOUT)")
+	fl.StringVar(&cfg.Tokens.Format, "token-format", cfg.Tokens.Format, "Auth token format: opaque|jwt (TOKEN_FORMAT)")
+	fl.DurationVar(&cfg.Tokens.AuthTTL, "token-auth-ttl", cfg.Tokens.AuthTTL, "Lifetime of authentication tokens (TOKEN_AUTH_TTL)")
+	fl.DurationVar(&cfg.Tokens.RefreshTTL, "token-refresh-ttl", cfg.Tokens.RefreshTTL, "Lifetime of refresh tokens (TOKEN_REFRESH_TTL)")
+	fl.DurationVar(&cfg.Tokens.PasswordResetTTL, "token-password-reset-ttl", cfg.Tokens.PasswordResetTTL, "Lifetime of password reset tokens (TOKEN_PASSWORD_RESET_TTL)")
+	fl.DurationVar(&cfg.Tokens.ActivationTTL, "token-activation-ttl", cfg.Tokens.ActivationTTL, "Lifetime of email verification tokens (TOKEN_ACTIVATION_TTL)")
+	fl.DurationVar(&cfg.Tokens.MFATTL, "token-mfa-ttl", cfg.Tokens.MFATTL, "Time to send the 2FA code after the password (TOKEN_MFA_TTL)")
+	fl.IntVar(&cfg.Login.MaxAttempts, "login-max-attempts", cfg.Login.MaxAttempts, "Failed logins for a username before it's locked (LOGIN_MAX_ATTEMPTS)")
+	fl.IntVar(&cfg.Login.MaxAttemptsPerIP, "login-max-attempts-per-ip", cfg.Login.MaxAttemptsPerIP, "Failed logins from an IP before it's locked (LOGIN_MAX_ATTEMPTS_PER_IP)")
+	fl.DurationVar(&cfg.Login.Lockout, "login-lockout", cfg.Login.Lockout, "First login lockout, doubles with each failure (LOGIN_LOCKOUT)")
+	fl.DurationVar(&cfg.Login.MaxLockout, "login-max-lockout", cfg.Login.MaxLockout, "Longest login lockout (LOGIN_MAX_LOCKOUT)")
+	fl.StringVar(&cfg.JWT.Algorithm, "jwt-algorithm", cfg.JWT.Algorithm, "JWT signing algorithm: EdDSA|HS256 (JWT_ALGORITHM)")
+	fl.StringVar(&cfg.JWT.ActiveKeyID, "jwt-active-key-id", cfg.JWT.ActiveKeyID, "Key id from JWT_KEYS used to sign, the first one if empty (JWT_ACTIVE_KEY_ID)")
+	fl.StringVar(&cfg.JWT.Issuer, "jwt-issuer", cfg.JWT.Issuer, "Issuer of the JWTs (JWT_ISSUER)")
+	fl.DurationVar(&cfg.Trash.Retention, "trash-retention", cfg.Trash.Retention, "How long deleted workouts can be restored before they are purged (TRASH_RETENTION)")
+	fl.DurationVar(&cfg.Trash.PurgeInterval, "trash-purge-interval", cfg.Trash.PurgeInterval, "How often expired workouts are purged from the trash (TRASH_PURGE_INTERVAL)")
+	fl.StringVar(&cfg.Mailer.Driver, "mailer", cfg.Mailer.Driver, "Mailer: log|smtp (MAILER_DRIVER)")
+	fl.StringVar(&cfg.Mailer.File, "mailer-file", cfg.Mailer.File, "File where the log mailer appends mails, stdout if empty (MAILER_FILE)")
+	fl.StringVar(&cfg.Mailer.From, "mailer-from", cfg.Mailer.From, "Sender of the mails (MAILER_FROM)")
+	fl.StringVar(&cfg.Mailer.SMTPHost, "smtp-host", cfg.Mailer.SMTPHost, "SMTP server host (SMTP_HOST)")
+	fl.IntVar(&cfg.Mailer.SMTPPort, "smtp-port", cfg.Mailer.SMTPPort, "SMTP server port (SMTP_PORT)")
+	fl.StringVar(&cfg.Mailer.SMTPUsername, "smtp-username", cfg.Mailer.SMTPUsername, "SMTP username, empty to send without auth (SMTP_USERNAME)")
+
+	err = fl.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DB.DSN == "" {
+		switch cfg.DB.Driver {
+		case driverPostgres:
+			cfg.DB.DSN = defaultPostgresDSN
+		case driverSQLite:
+			cfg.DB.DSN = defaultSQLitePath
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate chequea todos los valores y devuelve un error con todos los problemas juntos,
+// asi no hay que arrancar la app varias veces para encontrarlos
+func (c *Config) Validate() error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port must be between 1 and 65535, got %d", c.Port)
+	check(slices.Contains(logLevels, c.LogLevel), "log level must be one of %s, got %q", strings.Join(logLevels, ", "), c.LogLevel)
+	check(c.BcryptCost >= bcrypt.MinCost && c.BcryptCost <= bcrypt.MaxCost, "bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.BcryptCost)
+
+	check(c.DB.Driver == driverPostgres || c.DB.Driver == driverSQLite, "db driver must be %q or %q, got %q", driverPostgres, driverSQLite, c.DB.Driver)
+	check(c.DB.DSN != "", "db dsn is required")
+	check(c.DB.MaxOpenConns >= 0, "db max open conns must not be negative, got %d", c.DB.MaxOpenConns)
+	check(c.DB.MaxIdleConns >= 0, "db max idle conns must not be negative, got %d", c.DB.MaxIdleConns)
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db max idle conns (%d) must not be greater than max open conns (%d)", c.DB.MaxIdleConns, c.DB.MaxOpenConns)
+	check(c.DB.ConnMaxIdleTime >= 0, "db conn max idle time must not be negative, got %s", c.DB.ConnMaxIdleTime)
+	check(c.DB.QueryTimeout >= 0, "db query timeout must not be negative, got %s", c.DB.QueryTimeout)
+
+	check(c.Server.ReadTimeout > 0, "server read timeout must be positive, got %s", c.Server.ReadTimeout)
+	check(c.Server.WriteTimeout > 0, "server write timeout must be positive, got %s", c.Server.WriteTimeout)
+	check(c.Server.IdleTimeout > 0, "server idle timeout must be positive, got %s", c.Server.IdleTimeout)
+
+	check(c.Tokens.Format == tokenFormatOpaque || c.Tokens.Format == tokenFormatJWT, "token format must be %q or %q, got %q", tokenFormatOpaque, tokenFormatJWT, c.Tokens.Format)
+	check(c.Tokens.AuthTTL > 0, "token auth ttl must be positive, got %s", c.Tokens.AuthTTL)
+	check(c.Tokens.RefreshTTL > c.Tokens.AuthTTL, "token refresh ttl (%s) must be longer than auth ttl (%s)", c.Tokens.RefreshTTL, c.Tokens.AuthTTL)
+	check(c.Tokens.PasswordResetTTL > 0, "token password reset ttl must be positive, got %s", c.Tokens.PasswordResetTTL)
+	check(c.Tokens.ActivationTTL > 0, "token activation ttl must be positive, got %s", c.Tokens.ActivationTTL)
+	check(c.Tokens.MFATTL > 0, "token mfa ttl must be positive, got %s", c.Tokens.MFATTL)
+
+	if c.Tokens.UsesJWT() {
+		check(c.JWT.Algorithm == jwtAlgorithmEdDSA || c.JWT.Algorithm == jwtAlgorithmHS256, "jwt algorithm must be %q or %q, got %q", jwtAlgorithmEdDSA, jwtAlgorithmHS256, c.JWT.Algorithm)
+		check(c.JWT.Keys != "", "jwt keys are required when the token format is %q", tokenFormatJWT)
+		check(c.JWT.Issuer != "", "jwt issuer is required")
+	}
+
+	check(c.Login.MaxAttempts > 0, "login max attempts must be positive, got %d", c.Login.MaxAttempts)
+	check(c.Login.MaxAttemptsPerIP > 0, "login max attempts per ip must be positive, got %d", c.Login.MaxAttemptsPerIP)
+	check(c.Login.Lockout > 0, "login lockout must be positive, got %s", c.Login.Lockout)
+	check(c.Login.MaxLockout >= c.Login.Lockout, "login max lockout (%s) must not be shorter than login lockout (%s)", c.Login.MaxLockout, c.Login.Lockout)
+
+	check(c.Trash.Retention > 0, "trash retention must be positive, got %s", c.Trash.Retention)
+	check(c.Trash.PurgeInterval > 0, "trash purge interval must be positive, got %s", c.Trash.PurgeInterval)
+
+	check(c.Mailer.Driver == mailerLog || c.Mailer.Driver == mailerSMTP, "mailer driver must be %q or %q, got %q", mailerLog, mailerSMTP, c.Mailer.Driver)
+	check(c.Mailer.From != "", "mailer from is required")
+	if c.Mailer.Driver == mailerSMTP {
+		check(c.Mailer.SMTPHost != "", "smtp host is required when the mailer driver is %q", mailerSMTP)
+		check(c.Mailer.SMTPPort > 0 && c.Mailer.SMTPPort <= 65535, "smtp port must be between 1 and 65535, got %d", c.Mailer.SMTPPort)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// envReader lee variables de entorno tipadas y guarda el primer error de parseo,
+// asi load no tiene que chequear error despues de cada variable
+type envReader struct {
+	err error
+}
+
+func (e *envReader) lookup(key string) (string, bool) {
+	v, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(v), true
+}
+
+func (e *envReader) fail(key, v string, err error) {
+	if e.err == nil {
+		e.err = fmt.Errorf("config: invalid value %q for %s: %w", v, key, err)
+	}
+}
+
+func (e *envReader) string(key, def string) string {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	return v
+}
+
+func (e *envReader) int(key string, def int) int {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(key, v, err)
+		return def
+	}
+	return n
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(key, v, err)
+		return def
+	}
+	return b
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail(key, v, err)
+		return def
+	}
+	return d
+}
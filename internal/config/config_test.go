@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(nil, filepath.Join(t.TempDir(), "missing.env"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, driverPostgres, cfg.DB.Driver)
+	assert.Equal(t, defaultPostgresDSN, cfg.DB.DSN)
+	assert.Equal(t, 15*time.Minute, cfg.Tokens.AuthTTL)
+	assert.Equal(t, 30*24*time.Hour, cfg.Tokens.RefreshTTL)
+	assert.Equal(t, 30*time.Minute, cfg.Tokens.PasswordResetTTL)
+	assert.Equal(t, 72*time.Hour, cfg.Tokens.ActivationTTL)
+	assert.Equal(t, 5*time.Minute, cfg.Tokens.MFATTL)
+	assert.False(t, cfg.Tokens.UsesJWT())
+	assert.Equal(t, mailerLog, cfg.Mailer.Driver)
+	assert.Equal(t, 5, cfg.Login.MaxAttempts)
+	assert.Equal(t, 5*time.Second, cfg.DB.QueryTimeout)
+	assert.True(t, cfg.DB.AutoMigrate)
+	assert.Equal(t, 30*24*time.Hour, cfg.Trash.Retention)
+	assert.Equal(t, time.Hour, cfg.Trash.PurgeInterval)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(envFile, []byte("PORT=9000\nDB_DRIVER=sqlite\nTOKEN_AUTH_TTL=2h\nLOG_LEVEL=debug\n"), 0o600)
+	require.NoError(t, err)
+
+	//el entorno le gana al .env y los flags le ganan a los dos
+	t.Setenv("PORT", "9001")
+	t.Setenv("TOKEN_AUTH_TTL", "")
+	t.Cleanup(func() {
+		//godotenv setea en el proceso las variables del archivo que no estaban definidas
+		os.Unsetenv("DB_DRIVER")
+		os.Unsetenv("LOG_LEVEL")
+	})
+
+	cfg, err := load([]string{"-port", "9002", "-db-auto-migrate=false"}, envFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, 9002, cfg.Port)
+	assert.Equal(t, driverSQLite, cfg.DB.Driver)
+	assert.Equal(t, defaultSQLitePath, cfg.DB.DSN)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.False(t, cfg.DB.AutoMigrate)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.env")
+
+	t.Run("unparseable env var", func(t *testing.T) {
+		t.Setenv("TOKEN_AUTH_TTL", "one day")
+
+		_, err := load(nil, missing)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "TOKEN_AUTH_TTL")
+	})
+
+	t.Run("every invalid value is reported", func(t *testing.T) {
+		_, err := load([]string{"-port", "0", "-db", "mysql", "-bcrypt-cost", "99", "-log-level", "verbose"}, missing)
+		require.Error(t, err)
+
+		for _, want := range []string{"port", "db driver", "bcrypt cost", "log level"} {
+			assert.Contains(t, err.Error(), want)
+		}
+	})
+
+	t.Run("idle conns greater than open conns", func(t *testing.T) {
+		_, err := load([]string{"-db-max-open-conns", "5", "-db-max-idle-conns", "10"}, missing)
+		assert.ErrorContains(t, err, "max idle conns")
+	})
+	t.Run("smtp mailer without host", func(t *testing.T) {
+		_, err := load([]string{"-mailer", "smtp"}, missing)
+		assert.ErrorContains(t, err, "smtp host is required")
+	})
+	t.Run("jwt format without keys", func(t *testing.T) {
+		_, err := load([]string{"-token-format", "jwt"}, missing)
+		assert.ErrorContains(t, err, "jwt keys are required")
+	})
+	t.Run("trash without retention", func(t *testing.T) {
+		_, err := load([]string{"-trash-retention", "0s"}, missing)
+		assert.ErrorContains(t, err, "trash retention must be positive")
+	})
+}
@@ -0,0 +1,362 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MediaType es el Content-Type de un JSON Patch
+const MediaType = "application/json-patch+json"
+
+var (
+	// ErrInvalidPatch es un patch mal armado: un op desconocido, un path que no es un JSON Pointer, etc
+	ErrInvalidPatch = errors.New("invalid json patch")
+	// ErrPathNotFound es un patch bien armado que no se puede aplicar a este documento
+	ErrPathNotFound = errors.New("path not found")
+	// ErrTestFailed es una operacion test que no se cumplio
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Operation es una operacion de RFC 6902. Value queda en nil si no vino, y en "null" si vino null
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+type Patch []Operation
+
+// Decode lee un patch y chequea que cada operacion tenga lo que necesita
+func Decode(data []byte) (Patch, error) {
+	var patch Patch
+
+	err := json.Unmarshal(data, &patch)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range patch {
+		if _, err := parsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d: %s needs a value", ErrInvalidPatch, i, op.Op)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: from: %v", ErrInvalidPatch, i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d: unknown op %q", ErrInvalidPatch, i, op.Op)
+		}
+	}
+
+	return patch, nil
+}
+
+// Apply aplica las operaciones en orden y devuelve el documento nuevo. Si alguna falla
+// devuelve el error y el documento original no cambia, como pide la RFC
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	var root any
+
+	err := json.Unmarshal(doc, &root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range p {
+		root, err = op.apply(root)
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func (op Operation) apply(root any) (any, error) {
+	path, err := parsePointer(op.Path)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var value any
+
+	if op.Value != nil {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(root, path, value)
+	case "remove":
+		return remove(root, path)
+	case "replace":
+		return replace(root, path, value)
+	case "test":
+		current, err := get(root, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		//los numeros se decodifican todos como float64, asi 1 y 1.0 son iguales como pide la RFC
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+
+		return root, nil
+	}
+
+	from, err := parsePointer(op.From)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	value, err = get(root, from)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Op == "copy" {
+		return add(root, path, deepCopy(value))
+	}
+
+	//un objeto no se puede mover adentro de si mismo
+	if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+		return nil, fmt.Errorf("%w: can't move a value into itself", ErrInvalidPatch)
+	}
+
+	root, err = remove(root, from)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return add(root, path, value)
+}
+
+// parsePointer separa un JSON Pointer (RFC 6901) en sus tokens. "" es el documento entero
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		var err error
+
+		node, err = child(node, token)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			if token == "-" {
+				return append(c, value), nil
+			}
+
+			//en add el indice puede ser len, que es agregar al final
+			i, err := arrayIndex(token, len(c)+1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+
+			return c, nil
+		}
+
+		return nil, ErrPathNotFound
+	})
+}
+
+func remove(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: can't remove the whole document", ErrInvalidPatch)
+	}
+
+	return update(root, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, ErrPathNotFound
+			}
+
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(token, len(c))
+
+			if err != nil {
+				return nil, err
+			}
+
+			return append(c[:i:i], c[i+1:]...), nil
+		}
+
+		return nil, ErrPathNotFound
+	})
+}
+
+func replace(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(root, path, func(container any, token string) (any, error) {
+		//replace es remove + add, el valor tiene que existir
+		if _, err := child(container, token); err != nil {
+			return nil, err
+		}
+
+		return setChild(container, token, value)
+	})
+}
+
+// update busca el contenedor del ultimo token del path, le aplica fn y vuelve a armar el documento
+// hacia arriba, porque fn puede devolver un slice nuevo
+func update(node any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	next, err := child(node, path[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	next, err = update(next, path[1:], fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return setChild(node, path[0], next)
+}
+
+func child(node any, token string) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		value, ok := n[token]
+
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+
+		return value, nil
+	case []any:
+		i, err := arrayIndex(token, len(n))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return n[i], nil
+	}
+
+	return nil, ErrPathNotFound
+}
+
+func setChild(node any, token string, value any) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		n[token] = value
+		return n, nil
+	case []any:
+		i, err := arrayIndex(token, len(n))
+
+		if err != nil {
+			return nil, err
+		}
+
+		n[i] = value
+		return n, nil
+	}
+
+	return nil, ErrPathNotFound
+}
+
+// arrayIndex lee el indice de un array, que tiene que ser menor a length. La RFC 6901 no permite
+// ceros a la izquierda ni signos
+func arrayIndex(token string, length int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.ContainsAny(token, "+-") {
+		return 0, ErrPathNotFound
+	}
+
+	i, err := strconv.Atoi(token)
+
+	if err != nil || i >= length {
+		return 0, ErrPathNotFound
+	}
+
+	return i, nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, item := range v {
+			c[key] = deepCopy(item)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, item := range v {
+			c[i] = deepCopy(item)
+		}
+		return c
+	}
+
+	return value
+}
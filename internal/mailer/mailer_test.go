@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriterMailer(&buf, "app@mail.com")
+
+	err := m.Send(context.Background(), Message{To: "joaquin@mail.com", Subject: "hola", Body: "el token es ABC"})
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "From: app@mail.com")
+	assert.Contains(t, out, "To: joaquin@mail.com")
+	assert.Contains(t, out, "Subject: hola")
+	assert.Contains(t, out, "el token es ABC")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, m.Send(ctx, Message{}), context.Canceled)
+}
+
+func TestSMTPMailerFormat(t *testing.T) {
+	m := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: 25, From: "app@mail.com"})
+
+	msg := string(m.format(Message{To: "joaquin@mail.com", Subject: "hola", Body: "linea 1\nlinea 2"}))
+
+	headers, body, found := strings.Cut(msg, "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, headers, "To: joaquin@mail.com\r\n")
+	assert.Contains(t, headers, "Subject: hola\r\n")
+	assert.Equal(t, "linea 1\r\nlinea 2", body)
+}
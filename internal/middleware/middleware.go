@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/joaquinbian/workout-api-go/internal/store"
 	"github.com/joaquinbian/workout-api-go/internal/tokens"
@@ -11,7 +12,12 @@ import (
 )
 
 type UserMiddleware struct {
-	UserStore store.UserStore
+	UserStore   store.UserStore
+	APIKeyStore store.APIKeyStore
+	//si no es nil los JWT se validan con la firma, sin ir a la db
+	JWT *tokens.JWTManager
+	//con JWT, de donde se saca si la cuenta esta suspendida
+	Suspensions *SuspensionCache
 }
 
 // tenemos que usar un tipo custom para evitar colisiones de nombre en el context
@@ -20,6 +26,19 @@ type contextKey string
 
 var UserContextKey = contextKey("user")
 
+// TokenContextKey guarda el token con el que se autentico la request, para poder revocarlo (logout)
+var TokenContextKey = contextKey("token")
+
+// APIKeyContextKey guarda la API key con la que se autentico la request, si no fue con un token de sesion
+var APIKeyContextKey = contextKey("apiKey")
+
+// jwtClaimsContextKey guarda los claims cuando la request se autentico con un JWT. En ese caso
+// el usuario del context solo tiene lo que viene en los claims
+var jwtClaimsContextKey = contextKey("jwtClaims")
+
+// apiKeyAllowedContextKey lo pone AllowAPIKey cuando la ruta acepta la API key de la request
+var apiKeyAllowedContextKey = contextKey("apiKeyAllowed")
+
 func SetUser(r *http.Request, user *store.User) *http.Request {
 	//el context se usa, entre otras cosas, para pasar valores entre las request
 	ctx := context.WithValue(r.Context(), UserContextKey, user)
@@ -38,6 +57,20 @@ func GetUser(r *http.Request) *store.User {
 	return u
 }
 
+// GetToken devuelve el token con el que se autentico la request, o "" si es anonima
+func GetToken(r *http.Request) string {
+	token, _ := r.Context().Value(TokenContextKey).(string)
+
+	return token
+}
+
+// GetAPIKey devuelve la API key con la que se autentico la request, o nil si no se uso una
+func GetAPIKey(r *http.Request) *store.APIKey {
+	key, _ := r.Context().Value(APIKeyContextKey).(*store.APIKey)
+
+	return key
+}
+
 func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -55,36 +88,139 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 		headerParts := strings.Split(authHeader, " ")
 
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid auth header"})
+			utils.WriteError(w, utils.InvalidToken("invalid auth header"))
 			return
 		}
 
 		token := headerParts[1]
 
-		user, err := um.UserStore.GetUserToken(tokens.ScopeAuth, token)
+		if strings.HasPrefix(token, tokens.APIKeyPrefix) {
+			um.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		if um.JWT != nil && tokens.IsJWT(token) {
+			um.authenticateJWT(w, r, next, token)
+			return
+		}
+
+		user, err := um.UserStore.GetUserToken(r.Context(), tokens.ScopeAuth, token)
 
 		if err != nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid token"})
+			utils.WriteError(w, utils.InvalidToken("invalid token"))
 			return
 		}
 
 		if user == nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
+			utils.WriteError(w, utils.InvalidToken("invalid or expired token"))
 			return
 
 		}
+
+		if user.IsSuspended() {
+			writeSuspended(w)
+			return
+		}
+
 		r = SetUser(r, user)
+		r = r.WithContext(context.WithValue(r.Context(), TokenContextKey, token))
 		next.ServeHTTP(w, r)
 		return
 	})
 }
 
+// authenticateAPIKey es Authenticate para las API keys. Cada uso actualiza el last_used_at de la key
+func (um *UserMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	key, err := um.APIKeyStore.UseAPIKey(r.Context(), plaintext, time.Now())
+
+	if err != nil {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired api key"))
+		return
+	}
+
+	user, err := um.UserStore.GetUserByID(r.Context(), key.UserID)
+
+	if err != nil || user == nil {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired api key"))
+		return
+	}
+
+	if user.IsSuspended() {
+		writeSuspended(w)
+		return
+	}
+
+	r = SetUser(r, user)
+	r = r.WithContext(context.WithValue(r.Context(), APIKeyContextKey, key))
+	next.ServeHTTP(w, r)
+}
+
+// authenticateJWT es Authenticate para los JWT: arma el usuario con los claims, sin ir a la db.
+// Si la cuenta esta suspendida se entera por Suspensions, que solo va a la db cada tanto
+func (um *UserMiddleware) authenticateJWT(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := um.JWT.Parse(token)
+
+	if err != nil || !claims.HasScope(tokens.ScopeAuth) {
+		utils.WriteError(w, utils.InvalidToken("invalid or expired token"))
+		return
+	}
+
+	user := &store.User{ID: claims.UserID, Username: claims.Username, Role: claims.Role}
+	if claims.EmailVerifiedAt != nil {
+		user.EmailVerifiedAt = &claims.EmailVerifiedAt.Time
+	}
+
+	if um.Suspensions != nil {
+		suspended, err := um.Suspensions.IsSuspended(r.Context(), user.ID)
+
+		if err != nil {
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+
+		if suspended {
+			writeSuspended(w)
+			return
+		}
+	}
+
+	r = SetUser(r, user)
+	ctx := context.WithValue(r.Context(), TokenContextKey, token)
+	ctx = context.WithValue(ctx, jwtClaimsContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AllowAPIKey deja usar RequireUser (y RequireVerifiedUser) con una API key que tenga scope.
+// Las rutas que no lo usan solo aceptan tokens de sesion, asi una key filtrada no sirve
+// para cambiar la password, crear mas keys, etc.
+func (um *UserMiddleware) AllowAPIKey(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := GetAPIKey(r)
+
+		if key != nil {
+			if !key.Allows(scope) {
+				utils.WriteError(w, utils.Forbidden("the api key needs the "+scope+" scope for this route"))
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyAllowedContextKey, true))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := GetUser(r)
 
 		if user.IsAnonymous() {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "you must be logged to access this route"})
+			utils.WriteError(w, utils.Unauthorized("you must be logged to access this route"))
+			return
+		}
+
+		if allowed, _ := r.Context().Value(apiKeyAllowedContextKey).(bool); GetAPIKey(r) != nil && !allowed {
+			utils.WriteError(w, utils.Forbidden("api keys can't be used on this route"))
 			return
 		}
 
@@ -93,3 +229,68 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 	})
 
 }
+
+// RequireFullUser es RequireUser para los handlers que usan mas que el id del usuario (la password,
+// el perfil, el 2FA...). Si la request vino con un JWT carga el usuario completo de la db
+func (um *UserMiddleware) RequireFullUser(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(jwtClaimsContextKey).(*tokens.JWTClaims); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := um.UserStore.GetUserByID(r.Context(), GetUser(r).ID)
+
+		if err != nil {
+			utils.WriteError(w, utils.InternalError())
+			return
+		}
+
+		//el usuario se borro despues de firmar el token
+		if user == nil {
+			utils.WriteError(w, utils.InvalidToken("invalid or expired token"))
+			return
+		}
+
+		if user.IsSuspended() {
+			writeSuspended(w)
+			return
+		}
+
+		next.ServeHTTP(w, SetUser(r, user))
+	})
+}
+
+// RequireRole es RequireFullUser pero ademas pide que el usuario tenga role. Carga el usuario
+// de la db con JWT, asi quitarle el rol a alguien (o suspenderlo) tiene efecto enseguida
+func (um *UserMiddleware) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireFullUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if !user.HasRole(role) {
+			utils.WriteError(w, utils.Forbidden("you don't have permission to access this route"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedUser es RequireUser pero ademas pide que el usuario haya verificado su email.
+// Se usa en las rutas que no queremos abrir a cuentas con emails sin confirmar
+func (um *UserMiddleware) RequireVerifiedUser(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if !user.IsVerified() {
+			utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeEmailNotVerified, "you must verify your email to access this route"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeSuspended(w http.ResponseWriter) {
+	utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ErrCodeAccountSuspended, "your account is suspended"))
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/store"
+)
+
+// SuspensionTTL es cuanto se recuerda si un usuario esta suspendido. Suspender a alguien desde
+// otra instancia de la api tarda hasta esto en cerrarle los JWT en esta
+const SuspensionTTL = 30 * time.Second
+
+// maxSuspensionEntries es a partir de cuantos usuarios se limpian las entradas vencidas
+const maxSuspensionEntries = 10000
+
+// SuspensionCache recuerda por un rato si cada usuario esta suspendido. Los JWT no van a la db,
+// asi que sin esto una cuenta suspendida los seguiria usando hasta que venzan; con el cache se
+// busca el usuario una vez cada ttl y no en cada request
+type SuspensionCache struct {
+	users store.UserStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[int]suspensionEntry
+}
+
+type suspensionEntry struct {
+	suspended bool
+	expiresAt time.Time
+}
+
+func NewSuspensionCache(users store.UserStore, ttl time.Duration) *SuspensionCache {
+	return &SuspensionCache{users: users, ttl: ttl, entries: map[int]suspensionEntry{}}
+}
+
+// IsSuspended dice si userID esta suspendido, yendo a la db solo si no lo sabe o ya vencio.
+// Un usuario que no existe (se borro despues de firmar el token) no cuenta como suspendido
+func (c *SuspensionCache) IsSuspended(ctx context.Context, userID int) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		return entry.suspended, nil
+	}
+
+	user, err := c.users.GetUserByID(ctx, userID)
+
+	if err != nil {
+		return false, err
+	}
+
+	suspended := user != nil && user.IsSuspended()
+	c.Set(userID, suspended)
+
+	return suspended, nil
+}
+
+// Set guarda el estado de userID sin ir a la db. Lo usa el admin al suspender o reactivar una
+// cuenta, asi en esta instancia tiene efecto enseguida
+func (c *SuspensionCache) Set(userID int, suspended bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxSuspensionEntries {
+		for id, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+	}
+
+	c.entries[userID] = suspensionEntry{suspended: suspended, expiresAt: now.Add(c.ttl)}
+}
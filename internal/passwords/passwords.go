@@ -0,0 +1,56 @@
+package passwords
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MinLength = 8
+	//bcrypt solo usa los primeros 72 bytes y GenerateFromPassword falla con mas
+	MaxLength = 72
+)
+
+var ErrCommon = errors.New("password is too common, choose a different one")
+
+// common.txt tiene passwords filtradas en brechas conocidas, una por linea, en minuscula.
+// Solo tiene sentido listar las que llegan a MinLength
+//
+//go:embed common.txt
+var commonFile string
+
+var common = parseList(commonFile)
+
+func parseList(file string) map[string]struct{} {
+	list := make(map[string]struct{})
+
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = struct{}{}
+	}
+
+	return list
+}
+
+// Validate chequea que plaintext cumpla la politica de passwords: largo minimo y maximo
+// y que no este en la lista de passwords comunes (sin distinguir mayusculas)
+func Validate(plaintext string) error {
+	if len(plaintext) < MinLength {
+		return fmt.Errorf("password must be at least %d characters long", MinLength)
+	}
+
+	if len(plaintext) > MaxLength {
+		return fmt.Errorf("password must be at most %d bytes long", MaxLength)
+	}
+
+	if _, ok := common[strings.ToLower(plaintext)]; ok {
+		return ErrCommon
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "valid", password: "correct horse battery"},
+		{name: "too short", password: "abc123", wantErr: "at least 8"},
+		{name: "too long", password: strings.Repeat("a", MaxLength+1), wantErr: "at most 72"},
+		{name: "common", password: "password123", wantErr: ErrCommon.Error()},
+		{name: "common ignoring case", password: "PassWord123", wantErr: ErrCommon.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.password)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -1,25 +1,99 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/joaquinbian/workout-api-go/internal/app"
+	"github.com/joaquinbian/workout-api-go/internal/store"
+	"github.com/joaquinbian/workout-api-go/internal/utils"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
 
+	//cada request tiene un id (el X-Request-Id que mande el cliente o uno nuevo) que queda en los eventos de auditoria
+	r.Use(chimiddleware.RequestID)
+
+	//las rutas que no existen tambien responden con el formato de error de la api, no con el texto plano de chi
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteError(w, utils.NotFound("route not found"))
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteError(w, utils.NewAPIError(http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "method not allowed for this route"))
+	})
+
 	r.Group(func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
 
-		r.Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.GetWorkoutByID))
-		r.Get("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.GetWorkouts))
-		r.Post("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.CreateWorkout))
-		r.Put("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.UpdateWorkout))
-		r.Delete("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.DeleteWorkout))
+		//las rutas de workouts son las unicas que aceptan API keys, segun su scope
+		r.Get("/workouts/{id}", app.Middleware.AllowAPIKey(store.APIKeyScopeRead, app.Middleware.RequireUser(app.WorkoutHandler.GetWorkoutByID)))
+		r.Get("/workouts", app.Middleware.AllowAPIKey(store.APIKeyScopeRead, app.Middleware.RequireUser(app.WorkoutHandler.GetWorkouts)))
+		r.Get("/workouts/trash", app.Middleware.AllowAPIKey(store.APIKeyScopeRead, app.Middleware.RequireUser(app.WorkoutHandler.GetDeletedWorkouts)))
+		//crear contenido o seguir a otros usuarios pide el email verificado
+		r.Post("/workouts", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.CreateWorkout)))
+		r.Put("/workouts/{id}", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.UpdateWorkout)))
+		r.Delete("/workouts/{id}", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.DeleteWorkout)))
+		r.Patch("/workouts/{id}", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.PatchWorkout)))
+		r.Post("/workouts/{id}/restore", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.RestoreWorkout)))
+		r.Post("/workouts/{id}/entries", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.CreateWorkoutEntry)))
+		r.Put("/workouts/{id}/entries/order", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.ReorderWorkoutEntries)))
+		r.Patch("/workouts/{id}/entries/{entryID}", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.UpdateWorkoutEntry)))
+		r.Delete("/workouts/{id}/entries/{entryID}", app.Middleware.AllowAPIKey(store.APIKeyScopeWrite, app.Middleware.RequireVerifiedUser(app.WorkoutHandler.DeleteWorkoutEntry)))
+		r.Get("/workouts/{id}/history", app.Middleware.RequireUser(app.WorkoutHandler.GetWorkoutHistory))
+		r.Get("/workouts/{id}/notes", app.Middleware.RequireUser(app.WorkoutHandler.GetWorkoutNotes))
+		r.Post("/workouts/{id}/notes", app.Middleware.RequireVerifiedUser(app.WorkoutHandler.CreateWorkoutNote))
+
+		//para editar el perfil no se pide el email verificado, asi se puede corregir un email mal escrito.
+		//Estas rutas usan todo el usuario (no solo el id), con JWT lo tienen que cargar de la db
+		r.Get("/users/me", app.Middleware.RequireFullUser(app.UserHandler.HandleGetCurrentUser))
+		r.Patch("/users/me", app.Middleware.RequireFullUser(app.UserHandler.HandleUpdateCurrentUser))
+		r.Delete("/users/me", app.Middleware.RequireFullUser(app.UserHandler.HandleDeleteCurrentUser))
+		r.Put("/users/me/password", app.Middleware.RequireFullUser(app.UserHandler.HandleChangePassword))
+		r.Post("/users/me/2fa", app.Middleware.RequireFullUser(app.UserHandler.HandleSetupTwoFactor))
+		r.Post("/users/me/2fa/confirm", app.Middleware.RequireFullUser(app.UserHandler.HandleConfirmTwoFactor))
+		r.Delete("/users/me/2fa", app.Middleware.RequireFullUser(app.UserHandler.HandleDisableTwoFactor))
+		r.Post("/users/me/api-keys", app.Middleware.RequireUser(app.APIKeyHandler.HandleCreateAPIKey))
+		r.Get("/users/me/api-keys", app.Middleware.RequireUser(app.APIKeyHandler.HandleListAPIKeys))
+		r.Delete("/users/me/api-keys/{id}", app.Middleware.RequireUser(app.APIKeyHandler.HandleRevokeAPIKey))
+
+		r.Put("/users/{id}/follow", app.Middleware.RequireVerifiedUser(app.UserHandler.HandleFollowUser))
+		r.Delete("/users/{id}/follow", app.Middleware.RequireVerifiedUser(app.UserHandler.HandleUnfollowUser))
+		r.Delete("/users/me/followers/{id}", app.Middleware.RequireUser(app.UserHandler.HandleRemoveFollower))
+
+		r.Delete("/tokens/authentication", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeToken))
+		r.Delete("/tokens/authentication/all", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeAllTokens))
+		r.Get("/tokens/authentication/sessions", app.Middleware.RequireUser(app.TokenHandler.HandleListSessions))
+		r.Delete("/tokens/authentication/sessions/{id}", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeSession))
+
+		//COACHING: solo los coaches invitan, pero el atleta siempre puede aceptar o revocar
+		r.Get("/coaching", app.Middleware.RequireUser(app.CoachingHandler.HandleListCoachings))
+		r.Put("/coaching/athletes/{id}", app.Middleware.RequireRole(store.RoleCoach, app.CoachingHandler.HandleInviteAthlete))
+		r.Delete("/coaching/athletes/{id}", app.Middleware.RequireUser(app.CoachingHandler.HandleRemoveAthlete))
+		r.Put("/coaching/coaches/{id}", app.Middleware.RequireUser(app.CoachingHandler.HandleAcceptCoach))
+		r.Delete("/coaching/coaches/{id}", app.Middleware.RequireUser(app.CoachingHandler.HandleRemoveCoach))
+
+		//ADMIN
+		r.Get("/admin/users", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleListUsers))
+		r.Put("/admin/users/{id}/role", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleUpdateRole))
+		r.Put("/admin/users/{id}/suspension", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleSuspendUser))
+		r.Delete("/admin/users/{id}/suspension", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleUnsuspendUser))
+		r.Delete("/admin/users/{id}/tokens", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleRevokeUserTokens))
+		r.Get("/admin/workouts/{id}", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleGetWorkout))
+		r.Delete("/admin/workouts/{id}", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleDeleteWorkout))
+		r.Get("/admin/audit", app.Middleware.RequireRole(store.RoleAdmin, app.AdminHandler.HandleListAuditEvents))
 	})
 	//WORKOUTS
+	r.Get("/shared/workouts/{token}", app.WorkoutHandler.GetSharedWorkout)
 	r.Get("/health", app.HealthCheck)
 	r.Post("/users", app.UserHandler.HandleRegisterUser)
 	r.Post("/tokens/authentication", app.TokenHandler.HandleCreateToken)
+	r.Post("/tokens/refresh", app.TokenHandler.HandleRefreshToken)
+	r.Post("/tokens/mfa", app.TokenHandler.HandleVerifyMFA)
+	r.Post("/tokens/password-reset", app.TokenHandler.HandleCreatePasswordResetToken)
+	r.Put("/users/password", app.UserHandler.HandleResetPassword)
+	r.Post("/tokens/activation", app.TokenHandler.HandleCreateActivationToken)
+	r.Put("/users/activated", app.UserHandler.HandleActivateUser)
 	return r
 }
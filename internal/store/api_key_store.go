@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+)
+
+// Scopes de las API keys. Write incluye read
+const (
+	APIKeyScopeRead  = "workouts:read"
+	APIKeyScopeWrite = "workouts:write"
+)
+
+// APIKey es una key personal de larga duracion para scripts e integraciones
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	//solo se completa al crearla, despues solo queda el hash
+	Plaintext string `json:"key,omitempty"`
+	Hash      []byte `json:"-"`
+	Scope     string `json:"scope"`
+	//nil si no vence
+	Expiry     *time.Time `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Allows dice si la key alcanza para una ruta que pide scope
+func (k *APIKey) Allows(scope string) bool {
+	return k.Scope == scope || (k.Scope == APIKeyScopeWrite && scope == APIKeyScopeRead)
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// GetAPIKeysForUser devuelve todas las keys del usuario, incluidas las vencidas, las mas nuevas primero
+	GetAPIKeysForUser(ctx context.Context, userID int) ([]*APIKey, error)
+	// UseAPIKey busca la key sin vencer con ese texto y actualiza su last_used_at.
+	// Devuelve sql.ErrNoRows si no existe o vencio
+	UseAPIKey(ctx context.Context, plaintext string, now time.Time) (*APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int, id int) error
+}
+
+type PostgresAPIKeyStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+func NewPostgresAPIKeyStore(db *sql.DB, queryTimeout time.Duration) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+func (s *PostgresAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var expiry *time.Time
+	if key.Expiry != nil {
+		t := dbTime(*key.Expiry)
+		expiry = &t
+	}
+
+	query := `INSERT INTO api_keys (hash, user_id, name, scope, expiry, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	key.CreatedAt = dbTime(time.Now())
+
+	return s.db.QueryRowContext(ctx, query, key.Hash, key.UserID, key.Name, key.Scope, expiry, key.CreatedAt).Scan(&key.ID)
+}
+
+func (s *PostgresAPIKeyStore) GetAPIKeysForUser(ctx context.Context, userID int) ([]*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, scope, expiry, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []*APIKey{}
+
+	for rows.Next() {
+		key := &APIKey{}
+
+		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Scope, &key.Expiry, &key.LastUsedAt, &key.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, key)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *PostgresAPIKeyStore) UseAPIKey(ctx context.Context, plaintext string, now time.Time) (*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE api_keys SET last_used_at = $2
+	WHERE hash = $1 AND (expiry IS NULL OR expiry > $2)
+	RETURNING id, user_id, name, scope, expiry, last_used_at, created_at`
+
+	key := &APIKey{}
+
+	err := s.db.QueryRowContext(ctx, query, tokens.Hash(plaintext), dbTime(now)).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Scope, &key.Expiry, &key.LastUsedAt, &key.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// DeleteAPIKey revoca la key id del usuario. Devuelve sql.ErrNoRows si no existe o es de otro usuario
+func (s *PostgresAPIKeyStore) DeleteAPIKey(ctx context.Context, userID int, id int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return execAffectingRows(ctx, s.db, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+}
@@ -0,0 +1,370 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// tipos de target de los eventos de auditoria
+const (
+	AuditTargetWorkout = "workout"
+	AuditTargetUser    = "user"
+)
+
+// AuditActionWorkoutPurge es la accion de los eventos que guarda PurgeDeletedWorkouts, uno por workout.
+// Las demas acciones las define la api; esta no tiene request ni actor, la hace el server solo
+const AuditActionWorkoutPurge = "workout.purge"
+
+// AuditEvent es un cambio hecho por la api: quien lo hizo (ActorID, nil si fue un request sin usuario,
+// como el registro), que hizo (Action, por ej "workout.update") y sobre que (TargetType y TargetID).
+// Changes tiene los campos que cambiaron, con el valor de antes y el de despues
+type AuditEvent struct {
+	ID            int64                  `json:"id"`
+	ActorID       *int                   `json:"actor_id"`
+	ActorUsername *string                `json:"actor_username,omitempty"`
+	Action        string                 `json:"action"`
+	TargetType    string                 `json:"target_type"`
+	TargetID      int64                  `json:"target_id"`
+	Changes       map[string]AuditChange `json:"changes,omitempty"`
+	RequestID     string                 `json:"request_id,omitempty"`
+	IP            string                 `json:"ip,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+
+	before json.RawMessage
+	after  any
+	err    error
+}
+
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// campos que no se guardan en Changes: el token del link es un secreto, y version y updatedAt
+// cambian en cada cambio (y el store los actualiza despues de guardar el evento)
+var auditIgnoredFields = map[string]bool{
+	"share_token": true,
+	"version":     true,
+	"updatedAt":   true,
+}
+
+// SetBefore guarda el estado del target antes del cambio. Se serializa en el momento,
+// asi se puede seguir modificando el mismo objeto
+func (e *AuditEvent) SetBefore(v any) {
+	e.before, e.err = json.Marshal(v)
+}
+
+// SetAfter guarda el estado del target despues del cambio. Se serializa recien al guardar el evento,
+// asi incluye lo que completa el store (ids, fechas)
+func (e *AuditEvent) SetAfter(v any) {
+	e.after = v
+}
+
+type auditEventKey struct{}
+
+// WithAuditEvent agrega event al ctx. El store que hace el cambio lo guarda en la misma transaccion,
+// asi no queda un cambio sin su evento ni un evento de un cambio que fallo.
+// Se guarda una sola vez aunque el ctx se use para varios cambios
+func WithAuditEvent(ctx context.Context, event *AuditEvent) context.Context {
+	return context.WithValue(ctx, auditEventKey{}, event)
+}
+
+// pendingAuditEvent devuelve el evento del ctx si todavia no se guardo
+func pendingAuditEvent(ctx context.Context) *AuditEvent {
+	event, ok := ctx.Value(auditEventKey{}).(*AuditEvent)
+
+	if !ok || event == nil || event.ID != 0 {
+		return nil
+	}
+
+	return event
+}
+
+// prepare completa el evento antes de guardarlo. targetID es el id de la fila que cambio,
+// para los eventos que no lo sabian (por ej al crear un workout)
+func (e *AuditEvent) prepare(targetID int64) error {
+	if e.err != nil {
+		return fmt.Errorf("audit: %w", e.err)
+	}
+
+	if e.TargetID == 0 {
+		e.TargetID = targetID
+	}
+
+	changes, err := auditChanges(e.before, e.after)
+
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	e.Changes = changes
+	e.CreatedAt = dbTime(time.Now())
+
+	return nil
+}
+
+// auditChanges compara before y after campo por campo (las keys del json) y devuelve los que cambiaron
+func auditChanges(before json.RawMessage, after any) (map[string]AuditChange, error) {
+	beforeFields, err := jsonFields(before)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var afterJSON []byte
+
+	if after != nil {
+		afterJSON, err = json.Marshal(after)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	afterFields, err := jsonFields(afterJSON)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+
+	for field, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[field]) {
+			changes[field] = AuditChange{Before: value, After: afterFields[field]}
+		}
+	}
+
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok && value != nil {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+
+	for field := range auditIgnoredFields {
+		delete(changes, field)
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	return changes, nil
+}
+
+func jsonFields(data []byte) (map[string]any, error) {
+	fields := map[string]any{}
+
+	if len(data) == 0 {
+		return fields, nil
+	}
+
+	err := json.Unmarshal(data, &fields)
+
+	return fields, err
+}
+
+// recordAudit guarda con db (la transaccion del cambio) el evento del ctx, si hay uno pendiente
+func recordAudit(ctx context.Context, db dbExecutor, targetID int64) error {
+	event := pendingAuditEvent(ctx)
+
+	if event == nil {
+		return nil
+	}
+
+	err := event.prepare(targetID)
+
+	if err != nil {
+		return err
+	}
+
+	var changes sql.NullString
+
+	if event.Changes != nil {
+		data, err := json.Marshal(event.Changes)
+
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+
+		changes = sql.NullString{String: string(data), Valid: true}
+	}
+
+	query := `INSERT INTO audit_events (actor_id, action, target_type, target_id, changes, request_id, ip, created_at)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+  RETURNING id`
+
+	return db.QueryRowContext(ctx, query, event.ActorID, event.Action, event.TargetType, event.TargetID, changes,
+		event.RequestID, event.IP, event.CreatedAt).Scan(&event.ID)
+}
+
+// audited corre fn en una transaccion junto con el evento del ctx. Sin evento no hace falta
+// la transaccion y fn corre directo sobre db
+func audited(ctx context.Context, db *sql.DB, targetID int64, fn func(db dbExecutor) error) error {
+	if pendingAuditEvent(ctx) == nil {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(tx)
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, targetID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 200
+)
+
+// AuditFilter son los filtros de GetAuditEvents, los campos vacios no filtran. Los eventos van
+// del mas nuevo al mas viejo y la paginacion es por id: BeforeID es el id del ultimo evento de la pagina anterior
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   int64
+	Since      *time.Time
+	Until      *time.Time
+	BeforeID   int64
+	Limit      int
+}
+
+func (f *AuditFilter) normalize() {
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	if f.Limit > MaxAuditLimit {
+		f.Limit = MaxAuditLimit
+	}
+}
+
+// AuditStore es solo de lectura: los eventos los guardan los otros stores junto con cada cambio
+type AuditStore interface {
+	GetAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+type PostgresAuditStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+func NewPostgresAuditStore(db *sql.DB, queryTimeout time.Duration) *PostgresAuditStore {
+	return &PostgresAuditStore{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+func (s *PostgresAuditStore) GetAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	filter.normalize()
+
+	conditions := []string{"TRUE"}
+	args := []any{}
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != 0 {
+		add("e.actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		add("e.action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		add("e.target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		add("e.target_id = $%d", filter.TargetID)
+	}
+	if filter.Since != nil {
+		add("e.created_at >= $%d", dbTime(*filter.Since))
+	}
+	if filter.Until != nil {
+		add("e.created_at < $%d", dbTime(*filter.Until))
+	}
+	if filter.BeforeID != 0 {
+		add("e.id < $%d", filter.BeforeID)
+	}
+
+	args = append(args, filter.Limit)
+	//el username se busca aparte del actor_id, asi el evento queda aunque se borre el usuario
+	query := `SELECT e.id, e.actor_id, u.username, e.action, e.target_type, e.target_id, e.changes, e.request_id, e.ip, e.created_at
+  FROM audit_events e
+  LEFT JOIN users u ON u.id = e.actor_id
+  WHERE ` + strings.Join(conditions, " AND ") + fmt.Sprintf(" ORDER BY e.id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		event := &AuditEvent{}
+
+		var (
+			actorID  sql.NullInt64
+			username sql.NullString
+			changes  []byte
+		)
+
+		err := rows.Scan(&event.ID, &actorID, &username, &event.Action, &event.TargetType, &event.TargetID, &changes,
+			&event.RequestID, &event.IP, &event.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			event.ActorID = &id
+		}
+
+		if username.Valid {
+			event.ActorUsername = &username.String
+		}
+
+		if changes != nil {
+			err = json.Unmarshal(changes, &event.Changes)
+
+			if err != nil {
+				return nil, fmt.Errorf("audit event %d: %w", event.ID, err)
+			}
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Coaching es la relacion entre un coach y un atleta. Mientras AcceptedAt es nil es una
+// invitacion pendiente y el coach no tiene acceso a nada del atleta
+type Coaching struct {
+	CoachID         int        `json:"coach_id"`
+	CoachUsername   string     `json:"coach_username"`
+	AthleteID       int        `json:"athlete_id"`
+	AthleteUsername string     `json:"athlete_username"`
+	CreatedAt       time.Time  `json:"created_at"`
+	AcceptedAt      *time.Time `json:"accepted_at"`
+}
+
+func (c *Coaching) IsActive() bool {
+	return c.AcceptedAt != nil
+}
+
+type CoachingStore interface {
+	// InviteAthlete crea la invitacion del coach. Si ya existe (pendiente o aceptada) no hace nada.
+	// Devuelve sql.ErrNoRows si el atleta no existe
+	InviteAthlete(ctx context.Context, coachID, athleteID int) error
+	// AcceptInvite acepta la invitacion pendiente. Devuelve sql.ErrNoRows si no hay ninguna
+	AcceptInvite(ctx context.Context, coachID, athleteID int) error
+	// DeleteCoaching borra la relacion, este pendiente o aceptada. Devuelve sql.ErrNoRows si no existe
+	DeleteCoaching(ctx context.Context, coachID, athleteID int) error
+	// GetCoachings devuelve las relaciones en las que el usuario es coach o atleta, las mas viejas primero
+	GetCoachings(ctx context.Context, userID int) ([]*Coaching, error)
+	// IsCoach dice si coachID es coach de athleteID, el atleta ya lo acepto y coachID sigue teniendo
+	// el rol de coach (o es admin). Si le sacan el rol pierde el acceso, aunque el coaching quede
+	IsCoach(ctx context.Context, coachID, athleteID int) (bool, error)
+}
+
+type PostgresCoachingStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+func NewPostgresCoachingStore(db *sql.DB, queryTimeout time.Duration) *PostgresCoachingStore {
+	return &PostgresCoachingStore{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+func (s *PostgresCoachingStore) InviteAthlete(ctx context.Context, coachID, athleteID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, athleteID).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	query := `INSERT INTO coachings (coach_id, athlete_id, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, coachID, athleteID, dbTime(time.Now()))
+
+	if err != nil {
+		return constraintError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresCoachingStore) AcceptInvite(ctx context.Context, coachID, athleteID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE coachings SET accepted_at = $3 WHERE coach_id = $1 AND athlete_id = $2 AND accepted_at IS NULL`
+
+	return execAffectingRows(ctx, s.db, query, coachID, athleteID, dbTime(time.Now()))
+}
+
+func (s *PostgresCoachingStore) DeleteCoaching(ctx context.Context, coachID, athleteID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return execAffectingRows(ctx, s.db, `DELETE FROM coachings WHERE coach_id = $1 AND athlete_id = $2`, coachID, athleteID)
+}
+
+func (s *PostgresCoachingStore) GetCoachings(ctx context.Context, userID int) ([]*Coaching, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT c.coach_id, coach.username, c.athlete_id, athlete.username, c.created_at, c.accepted_at
+	FROM coachings c
+	INNER JOIN users coach ON coach.id = c.coach_id
+	INNER JOIN users athlete ON athlete.id = c.athlete_id
+	WHERE c.coach_id = $1 OR c.athlete_id = $1
+	ORDER BY c.created_at, c.coach_id, c.athlete_id`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []*Coaching{}
+
+	for rows.Next() {
+		c := &Coaching{}
+
+		err := rows.Scan(&c.CoachID, &c.CoachUsername, &c.AthleteID, &c.AthleteUsername, &c.CreatedAt, &c.AcceptedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *PostgresCoachingStore) IsCoach(ctx context.Context, coachID, athleteID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT EXISTS (SELECT 1 FROM coachings c
+	INNER JOIN users coach ON coach.id = c.coach_id
+	WHERE c.coach_id = $1 AND c.athlete_id = $2 AND c.accepted_at IS NOT NULL AND coach.role IN ('coach', 'admin'))`
+
+	var isCoach bool
+
+	err := s.db.QueryRowContext(ctx, query, coachID, athleteID).Scan(&isCoach)
+
+	if err != nil {
+		return false, err
+	}
+
+	return isCoach, nil
+}
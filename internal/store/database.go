@@ -1,44 +1,102 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
+	_ "modernc.org/sqlite"
 )
 
+// drivers soportados, se eligen al levantar la app
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// withTimeout limita cuanto puede tardar una llamada a un store: la query se corta cuando se
+// cancela el ctx del request (por ej. el cliente se desconecto) o cuando pasa el timeout.
+// Con timeout 0 solo cuenta el ctx
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
 // connect to our DB
-func Open() (*sql.DB, error) {
-	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable")
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
 
 	if err != nil {
 		return nil, fmt.Errorf("db: open %w", err)
 	}
 
-	fmt.Println("Successfully connected to Database")
+	return db, nil
+}
+
+// OpenSQLite abre (o crea) la base sqlite en path. sqlite no chequea foreign keys
+// por defecto, asi que las activamos para que ON DELETE CASCADE funcione igual que en postgres.
+// _txlock=immediate hace que las transacciones tomen el lock de escritura al empezar,
+// asi dos requests que escriben a la vez esperan (busy_timeout) en lugar de fallar.
+// _time_format=sqlite guarda los time.Time en un formato que entienden las funciones de fecha de sqlite
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate&_time_format=sqlite", path)
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("db: open sqlite %w", err)
+	}
 
 	return db, nil
 }
 
-func MigrateFS(db *sql.DB, migrationsFS fs.FS, dir string) error {
+// gooseDialect traduce nuestros drivers a los nombres de dialecto de goose
+func gooseDialect(driver string) (string, error) {
+	switch driver {
+	case DriverPostgres:
+		return "postgres", nil
+	case DriverSQLite:
+		return "sqlite3", nil
+	default:
+		return "", fmt.Errorf("unknown db driver %q", driver)
+	}
+}
+
+func MigrateFS(db *sql.DB, driver string, migrationsFS fs.FS, dir string) error {
 	goose.SetBaseFS(migrationsFS)
 
 	defer func() {
 		goose.SetBaseFS(nil)
 	}()
 
-	return Migrate(db, dir)
+	return Migrate(db, driver, dir)
 }
 
-func Migrate(db *sql.DB, dir string) error {
-	err := goose.SetDialect("postgres")
+func Migrate(db *sql.DB, driver string, dir string) error {
+	dialect, err := gooseDialect(driver)
+
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	err = goose.SetDialect(dialect)
 
 	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
 
 	err = goose.Up(db, dir)
+
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,21 @@
+package store
+
+// sqlDialect junta las pocas diferencias de sintaxis entre postgres y sqlite
+// que aparecen en las queries que se arman dinamicamente
+type sqlDialect struct {
+	//operador LIKE que no distingue mayusculas (en sqlite LIKE ya es case insensitive para ASCII)
+	ilike string
+	//timestamp envuelve una columna o parametro de fecha para poder compararlos entre si.
+	//sqlite guarda las fechas como texto y el formato del driver no es el mismo que el de CURRENT_TIMESTAMP
+	timestamp func(expr string) string
+}
+
+var postgresDialect = sqlDialect{
+	ilike:     "ILIKE",
+	timestamp: func(expr string) string { return expr },
+}
+
+var sqliteDialect = sqlDialect{
+	ilike:     "LIKE",
+	timestamp: func(expr string) string { return "datetime(" + expr + ")" },
+}
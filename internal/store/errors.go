@@ -0,0 +1,71 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Errores de constraints, iguales para todos los stores, asi quien llama no depende del driver.
+// Los errores que devuelven los stores los envuelven: se chequean con errors.Is
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrCheckViolation      = errors.New("check constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+)
+
+// constraintViolation es un error con mensaje propio que cumple errors.Is con su kind
+// (uno de los Err*Violation), como ErrDuplicateUsername con ErrUniqueViolation
+type constraintViolation struct {
+	message string
+	kind    error
+}
+
+func (e *constraintViolation) Error() string {
+	return e.message
+}
+
+func (e *constraintViolation) Unwrap() error {
+	return e.kind
+}
+
+// constraintError envuelve los errores de constraints de postgres o sqlite con el Err*Violation
+// que corresponde. Cualquier otro error se devuelve tal cual
+func constraintError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kind error
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			kind = ErrUniqueViolation
+		case "23514":
+			kind = ErrCheckViolation
+		case "23503":
+			kind = ErrForeignKeyViolation
+		}
+	} else {
+		//el driver de sqlite no exporta los codigos extendidos de forma comoda, alcanza con el mensaje
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "UNIQUE constraint failed"):
+			kind = ErrUniqueViolation
+		case strings.Contains(msg, "CHECK constraint failed"):
+			kind = ErrCheckViolation
+		case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+			kind = ErrForeignKeyViolation
+		}
+	}
+
+	if kind == nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", kind, err)
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LoginAttemptStore cuenta los logins fallidos por key (un username o una IP) y guarda
+// hasta cuando esta bloqueada cada una. Cuando y cuanto bloquear lo decide quien lo usa
+type LoginAttemptStore interface {
+	// RecordFailure suma un fallo a key y devuelve cuantos lleva. Si el ultimo fallo fue antes
+	// de resetBefore la cuenta arranca de nuevo
+	RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil devuelve el bloqueo mas largo que siga vigente entre keys, o el zero time si no hay
+	LockedUntil(ctx context.Context, now time.Time, keys ...string) (time.Time, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type PostgresLoginAttemptStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+func NewPostgresLoginAttemptStore(db *sql.DB, queryTimeout time.Duration) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+func (s *PostgresLoginAttemptStore) RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	//el upsert es atomico, asi dos intentos a la vez no pisan la cuenta
+	query := `INSERT INTO login_attempts (attempt_key, failures, last_failure_at) VALUES ($1, 1, $2)
+	ON CONFLICT (attempt_key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+		last_failure_at = $2
+	RETURNING failures`
+
+	var failures int
+
+	err := s.db.QueryRowContext(ctx, query, key, dbTime(now), dbTime(resetBefore)).Scan(&failures)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (s *PostgresLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return execAffectingRows(ctx, s.db, `UPDATE login_attempts SET locked_until = $1 WHERE attempt_key = $2`, dbTime(until), key)
+}
+
+func (s *PostgresLoginAttemptStore) LockedUntil(ctx context.Context, now time.Time, keys ...string) (time.Time, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var lockedUntil time.Time
+
+	for _, key := range keys {
+		var until time.Time
+
+		query := `SELECT locked_until FROM login_attempts WHERE attempt_key = $1 AND locked_until > $2`
+
+		err := s.db.QueryRowContext(ctx, query, key, dbTime(now)).Scan(&until)
+
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+func (s *PostgresLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE attempt_key = $1`, key)
+
+	return err
+}
+
+// dbTime pasa t a UTC y sin fracciones de segundo, como lo guardan las columnas TIMESTAMP(0).
+// En sqlite las fechas son texto, y con las fracciones la comparacion como texto no siempre da bien
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
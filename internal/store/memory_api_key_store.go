@@ -0,0 +1,106 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+)
+
+type MemoryAPIKeyStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryAPIKeyStore(db *MemoryDB) *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{db: db}
+}
+
+func (ms *MemoryAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[key.UserID]; !ok {
+		return errMemoryForeignKey
+	}
+
+	ms.db.lastAPIKeyID++
+	key.ID = ms.db.lastAPIKeyID
+	key.CreatedAt = time.Now()
+
+	ms.db.apiKeys[key.ID] = copyAPIKey(key)
+
+	return nil
+}
+
+func (ms *MemoryAPIKeyStore) GetAPIKeysForUser(ctx context.Context, userID int) ([]*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	result := []*APIKey{}
+	for _, k := range ms.db.apiKeys {
+		if k.UserID == userID {
+			result = append(result, copyAPIKey(k))
+		}
+	}
+
+	//los ids crecen con el tiempo, asi que es el mismo orden que created_at DESC, id DESC
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID > result[j].ID
+	})
+
+	return result, nil
+}
+
+func (ms *MemoryAPIKeyStore) UseAPIKey(ctx context.Context, plaintext string, now time.Time) (*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	hash := tokens.Hash(plaintext)
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	for _, k := range ms.db.apiKeys {
+		if !bytes.Equal(k.Hash, hash) {
+			continue
+		}
+		if k.Expiry != nil && !k.Expiry.After(now) {
+			return nil, sql.ErrNoRows
+		}
+
+		k.LastUsedAt = &now
+		return copyAPIKey(k), nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (ms *MemoryAPIKeyStore) DeleteAPIKey(ctx context.Context, userID int, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	k, ok := ms.db.apiKeys[id]
+	if !ok || k.UserID != userID {
+		return sql.ErrNoRows
+	}
+
+	delete(ms.db.apiKeys, id)
+
+	return nil
+}
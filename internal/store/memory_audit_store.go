@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+)
+
+type MemoryAuditStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryAuditStore(db *MemoryDB) *MemoryAuditStore {
+	return &MemoryAuditStore{db: db}
+}
+
+// recordAudit es el recordAudit de postgres para los stores en memoria. Se llama con el lock tomado,
+// despues del cambio: como no hay transaccion, solo puede fallar si el evento no se puede serializar
+func (db *MemoryDB) recordAudit(ctx context.Context, targetID int64) error {
+	event := pendingAuditEvent(ctx)
+
+	if event == nil {
+		return nil
+	}
+
+	err := event.prepare(targetID)
+
+	if err != nil {
+		return err
+	}
+
+	db.lastAuditID++
+	event.ID = db.lastAuditID
+
+	db.auditEvents = append(db.auditEvents, copyAuditEvent(event))
+
+	return nil
+}
+
+func (ms *MemoryAuditStore) GetAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filter.normalize()
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	events := []*AuditEvent{}
+
+	//del mas nuevo al mas viejo, como el ORDER BY id DESC de postgres
+	for i := len(ms.db.auditEvents) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		e := ms.db.auditEvents[i]
+
+		if !filter.matches(e) {
+			continue
+		}
+
+		copied := copyAuditEvent(e)
+
+		if e.ActorID != nil {
+			if u, ok := ms.db.users[*e.ActorID]; ok {
+				copied.ActorUsername = &u.Username
+			}
+		}
+
+		events = append(events, copied)
+	}
+
+	return events, nil
+}
+
+// copyAuditEvent copia solo lo que se guarda del evento, sin el estado de antes y despues
+func copyAuditEvent(e *AuditEvent) *AuditEvent {
+	return &AuditEvent{
+		ID:         e.ID,
+		ActorID:    copyPtr(e.ActorID),
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		Changes:    e.Changes,
+		RequestID:  e.RequestID,
+		IP:         e.IP,
+		CreatedAt:  e.CreatedAt,
+	}
+}
+
+// matches es el WHERE de GetAuditEvents en postgres
+func (f AuditFilter) matches(e *AuditEvent) bool {
+	switch {
+	case f.ActorID != 0 && (e.ActorID == nil || *e.ActorID != f.ActorID),
+		f.Action != "" && e.Action != f.Action,
+		f.TargetType != "" && e.TargetType != f.TargetType,
+		f.TargetID != 0 && e.TargetID != f.TargetID,
+		f.Since != nil && e.CreatedAt.Before(dbTime(*f.Since)),
+		f.Until != nil && !e.CreatedAt.Before(dbTime(*f.Until)),
+		f.BeforeID != 0 && e.ID >= f.BeforeID:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+)
+
+type MemoryCoachingStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryCoachingStore(db *MemoryDB) *MemoryCoachingStore {
+	return &MemoryCoachingStore{db: db}
+}
+
+func (ms *MemoryCoachingStore) InviteAthlete(ctx context.Context, coachID, athleteID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[athleteID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := ms.db.users[coachID]; !ok {
+		return errMemoryForeignKey
+	}
+	if coachID == athleteID {
+		return errMemorySelfCoaching
+	}
+
+	key := coaching{coachID: coachID, athleteID: athleteID}
+	if _, ok := ms.db.coachings[key]; !ok {
+		ms.db.coachings[key] = &Coaching{CoachID: coachID, AthleteID: athleteID, CreatedAt: time.Now()}
+	}
+
+	return nil
+}
+
+func (ms *MemoryCoachingStore) AcceptInvite(ctx context.Context, coachID, athleteID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	c, ok := ms.db.coachings[coaching{coachID: coachID, athleteID: athleteID}]
+	if !ok || c.IsActive() {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	c.AcceptedAt = &now
+
+	return nil
+}
+
+func (ms *MemoryCoachingStore) DeleteCoaching(ctx context.Context, coachID, athleteID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	key := coaching{coachID: coachID, athleteID: athleteID}
+	if _, ok := ms.db.coachings[key]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(ms.db.coachings, key)
+
+	return nil
+}
+
+func (ms *MemoryCoachingStore) GetCoachings(ctx context.Context, userID int) ([]*Coaching, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	result := []*Coaching{}
+	for _, c := range ms.db.coachings {
+		if c.CoachID != userID && c.AthleteID != userID {
+			continue
+		}
+
+		copied := *c
+		copied.AcceptedAt = copyPtr(c.AcceptedAt)
+		//los usernames salen del join con users
+		copied.CoachUsername = ms.db.users[c.CoachID].Username
+		copied.AthleteUsername = ms.db.users[c.AthleteID].Username
+		result = append(result, &copied)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		if result[i].CoachID != result[j].CoachID {
+			return result[i].CoachID < result[j].CoachID
+		}
+		return result[i].AthleteID < result[j].AthleteID
+	})
+
+	return result, nil
+}
+
+func (ms *MemoryCoachingStore) IsCoach(ctx context.Context, coachID, athleteID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	return ms.db.isCoach(coachID, athleteID), nil
+}
+
+// isCoach es IsCoach para usar desde otros stores en memoria. Se llama con el lock tomado
+func (db *MemoryDB) isCoach(coachID, athleteID int) bool {
+	c, ok := db.coachings[coaching{coachID: coachID, athleteID: athleteID}]
+
+	if !ok || !c.IsActive() {
+		return false
+	}
+
+	coach, ok := db.users[coachID]
+
+	return ok && coach.HasRole(RoleCoach)
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type loginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+type MemoryLoginAttemptStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryLoginAttemptStore(db *MemoryDB) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{db: db}
+}
+
+func (ms *MemoryLoginAttemptStore) RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	attempt, ok := ms.db.loginAttempts[key]
+	if !ok {
+		attempt = &loginAttempt{}
+		ms.db.loginAttempts[key] = attempt
+	}
+
+	if attempt.lastFailureAt.Before(dbTime(resetBefore)) {
+		attempt.failures = 0
+	}
+
+	attempt.failures++
+	attempt.lastFailureAt = dbTime(now)
+
+	return attempt.failures, nil
+}
+
+func (ms *MemoryLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	attempt, ok := ms.db.loginAttempts[key]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	attempt.lockedUntil = dbTime(until)
+
+	return nil
+}
+
+func (ms *MemoryLoginAttemptStore) LockedUntil(ctx context.Context, now time.Time, keys ...string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	var lockedUntil time.Time
+
+	for _, key := range keys {
+		attempt, ok := ms.db.loginAttempts[key]
+		if ok && attempt.lockedUntil.After(dbTime(now)) && attempt.lockedUntil.After(lockedUntil) {
+			lockedUntil = attempt.lockedUntil
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+func (ms *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	delete(ms.db.loginAttempts, key)
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+)
+
+// errores que imitan las constraints que en postgres definen las migraciones
+var (
+	errMemoryUniqueViolation = &constraintViolation{message: "memory store: unique constraint violation", kind: ErrUniqueViolation}
+	errMemoryForeignKey      = &constraintViolation{message: "memory store: foreign key violation", kind: ErrForeignKeyViolation}
+	errMemoryWorkoutEntry    = &constraintViolation{message: `memory store: check constraint "valid_workout_entry" violated`, kind: ErrCheckViolation}
+	errMemorySelfFollow      = &constraintViolation{message: `memory store: check constraint "no_self_follow" violated`, kind: ErrCheckViolation}
+	errMemorySelfCoaching    = &constraintViolation{message: `memory store: check constraint "no_self_coaching" violated`, kind: ErrCheckViolation}
+	errMemoryCheckViolation  = &constraintViolation{message: "memory store: check constraint violation", kind: ErrCheckViolation}
+)
+
+// MemoryDB guarda todas las "tablas" en memoria. Los stores en memoria comparten
+// una misma MemoryDB igual que los stores de postgres comparten el *sql.DB,
+// asi por ejemplo GetUserToken puede "joinear" users con tokens.
+// Todo acceso pasa por mu, por lo que es seguro usarla desde varios goroutines.
+// Los stores devuelven ctx.Err() si el ctx ya esta cancelado, igual que database/sql
+type MemoryDB struct {
+	mu sync.RWMutex
+
+	users         map[int]*User
+	workouts      map[int]*Workout
+	tokens        map[string]*tokens.Token //la key es el hash del token
+	follows       map[follow]time.Time
+	loginAttempts map[string]*loginAttempt
+	recoveryCodes map[string]int //hash del codigo -> id del usuario
+	totpSteps     map[int]int64  //id del usuario -> paso del ultimo codigo TOTP aceptado
+	apiKeys       map[int]*APIKey
+	coachings     map[coaching]*Coaching
+	workoutNotes  map[int]*WorkoutNote
+	auditEvents   []*AuditEvent //en orden de id, solo se agregan
+
+	lastUserID    int
+	lastWorkoutID int
+	lastEntryID   int
+	lastTokenID   int
+	lastAPIKeyID  int
+	lastNoteID    int
+	lastAuditID   int64
+}
+
+// follow es la primary key de la tabla follows
+type follow struct {
+	followerID int
+	followeeID int
+}
+
+// coaching es la primary key de la tabla coachings
+type coaching struct {
+	coachID   int
+	athleteID int
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:         make(map[int]*User),
+		workouts:      make(map[int]*Workout),
+		tokens:        make(map[string]*tokens.Token),
+		follows:       make(map[follow]time.Time),
+		loginAttempts: make(map[string]*loginAttempt),
+		recoveryCodes: make(map[string]int),
+		totpSteps:     make(map[int]int64),
+		apiKeys:       make(map[int]*APIKey),
+		coachings:     make(map[coaching]*Coaching),
+		workoutNotes:  make(map[int]*WorkoutNote),
+	}
+}
+
+// las funciones copy* evitan que quien llama al store modifique los datos guardados
+// (y viceversa), como pasa con los valores que devuelve una db real
+func copyUser(u *User) *User {
+	c := *u
+	c.PasswordHash = password{hash: append([]byte(nil), u.PasswordHash.hash...)}
+	c.EmailVerifiedAt = copyPtr(u.EmailVerifiedAt)
+	c.TOTPEnabledAt = copyPtr(u.TOTPEnabledAt)
+	c.SuspendedAt = copyPtr(u.SuspendedAt)
+	return &c
+}
+
+func copyWorkout(w *Workout) *Workout {
+	c := *w
+	c.ShareToken = copyPtr(w.ShareToken)
+	c.DeletedAt = copyPtr(w.DeletedAt)
+	c.Entries = copyEntries(w.Entries)
+	return &c
+}
+
+func copyEntries(entries []WorkoutEntry) []WorkoutEntry {
+	if entries == nil {
+		return nil
+	}
+
+	copied := make([]WorkoutEntry, len(entries))
+	for i, e := range entries {
+		copied[i] = e
+		copied[i].Reps = copyPtr(e.Reps)
+		copied[i].DurationSeconds = copyPtr(e.DurationSeconds)
+		copied[i].Weight = copyPtr(e.Weight)
+	}
+	return copied
+}
+
+func copyPtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyAPIKey(k *APIKey) *APIKey {
+	c := *k
+	c.Plaintext = ""
+	c.Hash = append([]byte(nil), k.Hash...)
+	c.Expiry = copyPtr(k.Expiry)
+	c.LastUsedAt = copyPtr(k.LastUsedAt)
+	return &c
+}
+
+func copyToken(t *tokens.Token) *tokens.Token {
+	c := *t
+	c.Hash = append([]byte(nil), t.Hash...)
+	c.UsedAt = copyPtr(t.UsedAt)
+	return &c
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemoryStores(t *testing.T) stores {
+	db := NewMemoryDB()
+	return stores{
+		workouts:      NewMemoryWorkoutStore(db),
+		users:         NewMemoryUserStore(db),
+		tokens:        NewMemoryTokenStore(db),
+		loginAttempts: NewMemoryLoginAttemptStore(db),
+		apiKeys:       NewMemoryAPIKeyStore(db),
+		coachings:     NewMemoryCoachingStore(db),
+		audit:         NewMemoryAuditStore(db),
+	}
+}
+
+func TestMemoryStores(t *testing.T) {
+	runStoreSuite(t, newMemoryStores)
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStores(t)
+	user := createTestUser(t, s, "joaquin")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: user.ID, Title: fmt.Sprintf("workout %d", i)})
+			if assert.NoError(t, err) {
+				_, err = s.workouts.GetWorkoutByID(ctx, int64(w.ID))
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Limit: MaxWorkoutsLimit})
+	require.NoError(t, err)
+	assert.Len(t, page.Workouts, 50)
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+)
+
+type MemoryTokenStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryTokenStore(db *MemoryDB) *MemoryTokenStore {
+	return &MemoryTokenStore{db: db}
+}
+
+func (ms *MemoryTokenStore) CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ms.Insert(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (ms *MemoryTokenStore) Insert(ctx context.Context, toInsert ...*tokens.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	err := ms.insertTokens(toInsert...)
+
+	if err != nil || len(toInsert) == 0 {
+		return err
+	}
+
+	return ms.db.recordAudit(ctx, int64(toInsert[0].UserID))
+}
+
+// insertTokens valida todos los tokens antes de guardar, asi si alguno falla no se guarda ninguno
+// (como la transaccion en postgres). Se llama con el lock tomado
+func (ms *MemoryTokenStore) insertTokens(toInsert ...*tokens.Token) error {
+	seen := make(map[string]bool, len(toInsert))
+
+	for _, token := range toInsert {
+		if _, ok := ms.db.users[token.UserID]; !ok {
+			return errMemoryForeignKey
+		}
+
+		key := string(token.Hash)
+		if _, ok := ms.db.tokens[key]; ok || seen[key] {
+			return errMemoryUniqueViolation
+		}
+		seen[key] = true
+	}
+
+	for _, token := range toInsert {
+		ms.db.lastTokenID++
+		token.ID = ms.db.lastTokenID
+		ms.db.tokens[string(token.Hash)] = copyToken(token)
+	}
+
+	return nil
+}
+
+func (ms *MemoryTokenStore) GetTokensForUser(ctx context.Context, userID int, scope string) ([]*tokens.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	now := time.Now()
+	result := []*tokens.Token{}
+	for _, t := range ms.db.tokens {
+		if t.UserID == userID && t.Scope == scope && t.Expiry.After(now) {
+			result = append(result, copyToken(t))
+		}
+	}
+
+	//igual que el ORDER BY created_at DESC, id DESC de postgres
+	sort.Slice(result, func(i, j int) bool {
+		if c := result[i].CreatedAt.Compare(result[j].CreatedAt); c != 0 {
+			return c > 0
+		}
+		return result[i].ID > result[j].ID
+	})
+
+	return result, nil
+}
+
+func (ms *MemoryTokenStore) RotateRefreshToken(ctx context.Context, plaintext string, auth, refresh *tokens.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	used, ok := ms.db.tokens[string(tokens.Hash(plaintext))]
+	if !ok || used.Scope != tokens.ScopeRefresh {
+		return sql.ErrNoRows
+	}
+
+	if used.UsedAt != nil {
+		ms.deleteTokens(func(t *tokens.Token) bool {
+			return used.FamilyID != "" && t.FamilyID == used.FamilyID
+		})
+		return ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	if !used.Expiry.After(now) {
+		return sql.ErrNoRows
+	}
+
+	for _, token := range []*tokens.Token{auth, refresh} {
+		token.UserID = used.UserID
+		token.FamilyID = used.FamilyID
+	}
+
+	err := ms.insertTokens(auth, refresh)
+	if err != nil {
+		return err
+	}
+
+	//los tokens de auth anteriores de la sesion dejan de servir
+	ms.deleteTokens(func(t *tokens.Token) bool {
+		return used.FamilyID != "" && t.FamilyID == used.FamilyID && t.Scope == tokens.ScopeAuth && t.ID != auth.ID
+	})
+
+	used.UsedAt = &now
+
+	return ms.db.recordAudit(ctx, int64(used.UserID))
+}
+
+func (ms *MemoryTokenStore) DeleteSession(ctx context.Context, userID int, tokenID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	for _, t := range ms.db.tokens {
+		if t.ID == tokenID && t.UserID == userID {
+			ms.deleteSession(t)
+			return ms.db.recordAudit(ctx, int64(userID))
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (ms *MemoryTokenStore) DeleteSessionByToken(ctx context.Context, scope string, plaintext string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	t, ok := ms.db.tokens[string(tokens.Hash(plaintext))]
+	if !ok || t.Scope != scope {
+		return sql.ErrNoRows
+	}
+
+	ms.deleteSession(t)
+
+	return ms.db.recordAudit(ctx, 0)
+}
+
+// deleteSession borra token y los de su familia. Se llama con el lock tomado
+func (ms *MemoryTokenStore) deleteSession(token *tokens.Token) {
+	ms.deleteTokens(func(t *tokens.Token) bool {
+		return t == token || (token.FamilyID != "" && t.FamilyID == token.FamilyID)
+	})
+}
+
+// deleteTokens borra los tokens para los que match devuelve true y dice cuantos borro.
+// Se llama con el lock tomado
+func (ms *MemoryTokenStore) deleteTokens(match func(t *tokens.Token) bool) int {
+	deleted := 0
+	for key, t := range ms.db.tokens {
+		if match(t) {
+			delete(ms.db.tokens, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (ms *MemoryTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	deleted := ms.deleteTokens(func(t *tokens.Token) bool {
+		return t.UserID == userID && t.Scope == scope
+	})
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return ms.db.recordAudit(ctx, int64(userID))
+}
@@ -0,0 +1,553 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+)
+
+type MemoryUserStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryUserStore(db *MemoryDB) *MemoryUserStore {
+	return &MemoryUserStore{db: db}
+}
+
+// uniqueViolation replica las constraints UNIQUE de username y email, con los mismos
+// errores que devuelven los stores de postgres y sqlite. Se llama con el lock tomado
+func (ms *MemoryUserStore) uniqueViolation(u *User) error {
+	for id, existing := range ms.db.users {
+		if id == u.ID {
+			continue
+		}
+		if existing.Username == u.Username {
+			return ErrDuplicateUsername
+		}
+		if existing.Email == u.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryUserStore) CreateUser(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	u.ID = 0
+	if err := ms.uniqueViolation(u); err != nil {
+		return err
+	}
+
+	ms.db.lastUserID++
+	now := time.Now()
+	u.ID = ms.db.lastUserID
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	u.EmailVerifiedAt = nil
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
+	ms.db.users[u.ID] = copyUser(u)
+
+	return ms.db.recordAudit(ctx, int64(u.ID))
+}
+
+func (ms *MemoryUserStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	u, ok := ms.db.users[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyUser(u), nil
+}
+
+func (ms *MemoryUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	for _, u := range ms.db.users {
+		if u.Username == username {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	for _, u := range ms.db.users {
+		if u.Email == email {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *MemoryUserStore) UpdateUser(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[u.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if err := ms.uniqueViolation(u); err != nil {
+		return err
+	}
+
+	if saved.Email != u.Email {
+		saved.EmailVerifiedAt = nil
+		ms.deleteTokens(u.ID, tokens.ScopeActivation)
+	}
+
+	saved.Username = u.Username
+	saved.Email = u.Email
+	saved.Bio = u.Bio
+	saved.UpdatedAt = time.Now()
+
+	u.UpdatedAt = saved.UpdatedAt
+	u.EmailVerifiedAt = copyPtr(saved.EmailVerifiedAt)
+
+	return ms.db.recordAudit(ctx, int64(u.ID))
+}
+
+func (ms *MemoryUserStore) VerifyEmail(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[u.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	saved.EmailVerifiedAt = &now
+	saved.UpdatedAt = now
+	u.EmailVerifiedAt = copyPtr(saved.EmailVerifiedAt)
+
+	ms.deleteTokens(u.ID, tokens.ScopeActivation)
+
+	return ms.db.recordAudit(ctx, int64(u.ID))
+}
+
+// DeleteUser borra el usuario y todo lo suyo, como el ON DELETE CASCADE de las migraciones
+func (ms *MemoryUserStore) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(ms.db.users, id)
+	delete(ms.db.totpSteps, id)
+
+	//las entries estan dentro de cada workout, se van con el
+	deletedWorkouts := map[int]bool{}
+	for workoutID, w := range ms.db.workouts {
+		if w.UserID == id {
+			delete(ms.db.workouts, workoutID)
+			deletedWorkouts[workoutID] = true
+		}
+	}
+
+	ms.db.deleteWorkoutNotes(func(n *WorkoutNote) bool { return n.AuthorID == id || deletedWorkouts[n.WorkoutID] })
+
+	for c := range ms.db.coachings {
+		if c.coachID == id || c.athleteID == id {
+			delete(ms.db.coachings, c)
+		}
+	}
+
+	for key, t := range ms.db.tokens {
+		if t.UserID == id {
+			delete(ms.db.tokens, key)
+		}
+	}
+
+	for f := range ms.db.follows {
+		if f.followerID == id || f.followeeID == id {
+			delete(ms.db.follows, f)
+		}
+	}
+
+	ms.deleteRecoveryCodes(id)
+
+	for keyID, k := range ms.db.apiKeys {
+		if k.UserID == id {
+			delete(ms.db.apiKeys, keyID)
+		}
+	}
+
+	return ms.db.recordAudit(ctx, int64(id))
+}
+
+func (ms *MemoryUserStore) ListUsers(ctx context.Context, filter UserFilter) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filter.normalize()
+	query := strings.ToLower(filter.Query)
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	result := []*User{}
+	for _, u := range ms.db.users {
+		if u.ID <= filter.AfterID {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(u.Username), query) && !strings.Contains(strings.ToLower(u.Email), query) {
+			continue
+		}
+		if filter.Role != "" && u.Role != filter.Role {
+			continue
+		}
+		if filter.Suspended != nil && u.IsSuspended() != *filter.Suspended {
+			continue
+		}
+		result = append(result, copyUser(u))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	if len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result, nil
+}
+
+func (ms *MemoryUserStore) UpdateRole(ctx context.Context, id int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	saved.Role = role
+	saved.UpdatedAt = time.Now()
+
+	return ms.db.recordAudit(ctx, int64(id))
+}
+
+func (ms *MemoryUserStore) SetSuspended(ctx context.Context, id int, suspended bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	saved.UpdatedAt = time.Now()
+
+	if !suspended {
+		saved.SuspendedAt = nil
+		return ms.db.recordAudit(ctx, int64(id))
+	}
+
+	if saved.SuspendedAt == nil {
+		now := time.Now()
+		saved.SuspendedAt = &now
+	}
+
+	for key, t := range ms.db.tokens {
+		if t.UserID == id {
+			delete(ms.db.tokens, key)
+		}
+	}
+
+	return ms.db.recordAudit(ctx, int64(id))
+}
+
+// deleteTokens borra los tokens de userID con ese scope. Se llama con el lock tomado
+func (ms *MemoryUserStore) deleteTokens(userID int, scope string) {
+	for key, t := range ms.db.tokens {
+		if t.UserID == userID && t.Scope == scope {
+			delete(ms.db.tokens, key)
+		}
+	}
+}
+
+func (ms *MemoryUserStore) UpdatePassword(ctx context.Context, u *User, keepSession string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[u.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	saved.PasswordHash.hash = append([]byte(nil), u.PasswordHash.hash...)
+	saved.UpdatedAt = time.Now()
+
+	var keep *tokens.Token
+	if keepSession != "" {
+		if t, ok := ms.db.tokens[string(tokens.Hash(keepSession))]; ok && t.Scope == tokens.ScopeAuth {
+			keep = t
+		}
+	}
+
+	for key, t := range ms.db.tokens {
+		if t.UserID != u.ID {
+			continue
+		}
+		if keep != nil && (t == keep || (keep.FamilyID != "" && t.FamilyID == keep.FamilyID)) {
+			continue
+		}
+		delete(ms.db.tokens, key)
+	}
+
+	return ms.db.recordAudit(ctx, int64(u.ID))
+}
+
+func (ms *MemoryUserStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	saved.TOTPSecret = secret
+	saved.UpdatedAt = time.Now()
+
+	return ms.db.recordAudit(ctx, int64(userID))
+}
+
+func (ms *MemoryUserStore) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[userID]
+	if !ok || saved.TOTPSecret == "" {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	saved.TOTPEnabledAt = &now
+	saved.UpdatedAt = now
+
+	ms.deleteRecoveryCodes(userID)
+	for _, hash := range recoveryCodeHashes {
+		ms.db.recoveryCodes[string(hash)] = userID
+	}
+
+	return ms.db.recordAudit(ctx, int64(userID))
+}
+
+func (ms *MemoryUserStore) DisableTOTP(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, ok := ms.db.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	saved.TOTPSecret = ""
+	saved.TOTPEnabledAt = nil
+	saved.UpdatedAt = time.Now()
+
+	ms.deleteRecoveryCodes(userID)
+
+	return ms.db.recordAudit(ctx, int64(userID))
+}
+
+func (ms *MemoryUserStore) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	owner, ok := ms.db.recoveryCodes[string(codeHash)]
+	if !ok || owner != userID {
+		return sql.ErrNoRows
+	}
+
+	delete(ms.db.recoveryCodes, string(codeHash))
+
+	return ms.db.recordAudit(ctx, int64(userID))
+}
+
+func (ms *MemoryUserStore) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[userID]; !ok || ms.db.totpSteps[userID] >= step {
+		return sql.ErrNoRows
+	}
+
+	ms.db.totpSteps[userID] = step
+
+	return nil
+}
+
+// deleteRecoveryCodes borra los codigos de recuperacion de userID. Se llama con el lock tomado
+func (ms *MemoryUserStore) deleteRecoveryCodes(userID int) {
+	for hash, owner := range ms.db.recoveryCodes {
+		if owner == userID {
+			delete(ms.db.recoveryCodes, hash)
+		}
+	}
+}
+
+func (ms *MemoryUserStore) GetUserToken(ctx context.Context, scope string, plainText string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokenHash := tokens.Hash(plainText)
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	t, ok := ms.db.tokens[string(tokenHash)]
+	if !ok || t.Scope != scope || !t.Expiry.After(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+
+	u, ok := ms.db.users[t.UserID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyUser(u), nil
+}
+
+func (ms *MemoryUserStore) FollowUser(ctx context.Context, followerID, followeeID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[followeeID]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := ms.db.users[followerID]; !ok {
+		return errMemoryForeignKey
+	}
+	if followerID == followeeID {
+		return errMemorySelfFollow
+	}
+
+	key := follow{followerID: followerID, followeeID: followeeID}
+	if _, ok := ms.db.follows[key]; !ok {
+		ms.db.follows[key] = time.Now()
+	}
+
+	return ms.db.recordAudit(ctx, int64(followeeID))
+}
+
+func (ms *MemoryUserStore) UnfollowUser(ctx context.Context, followerID, followeeID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	key := follow{followerID: followerID, followeeID: followeeID}
+	if _, ok := ms.db.follows[key]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(ms.db.follows, key)
+
+	return ms.db.recordAudit(ctx, int64(followeeID))
+}
+
+func (ms *MemoryUserStore) IsFollowing(ctx context.Context, followerID, followeeID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	_, ok := ms.db.follows[follow{followerID: followerID, followeeID: followeeID}]
+
+	return ok, nil
+}
@@ -0,0 +1,702 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+type MemoryWorkoutStore struct {
+	db *MemoryDB
+}
+
+func NewMemoryWorkoutStore(db *MemoryDB) *MemoryWorkoutStore {
+	return &MemoryWorkoutStore{db: db}
+}
+
+// validEntry replica la constraint valid_workout_entry: reps o duration_seconds, pero no ambos
+func validEntry(e WorkoutEntry) bool {
+	return (e.Reps != nil) != (e.DurationSeconds != nil)
+}
+
+// checkWorkout replica el CHECK de visibility y el indice unico de share_token.
+// Se llama con el lock tomado
+func (ms *MemoryWorkoutStore) checkWorkout(w *Workout) error {
+	if !ValidVisibility(w.Visibility) {
+		return errMemoryCheckViolation
+	}
+
+	if w.ShareToken == nil {
+		return nil
+	}
+
+	for id, other := range ms.db.workouts {
+		if id != w.ID && other.ShareToken != nil && *other.ShareToken == *w.ShareToken {
+			return errMemoryUniqueViolation
+		}
+	}
+
+	return nil
+}
+
+// insertEntries le asigna ids a las entries (en el slice que recibe) y devuelve la copia
+// que se guarda ordenada por order_index. Como en postgres, si alguna entry es invalida
+// no se guarda ninguna
+func (ms *MemoryWorkoutStore) insertEntries(entries []WorkoutEntry) ([]WorkoutEntry, error) {
+	for _, e := range entries {
+		if !validEntry(e) {
+			return nil, errMemoryWorkoutEntry
+		}
+	}
+
+	for i := range entries {
+		ms.db.lastEntryID++
+		entries[i].ID = ms.db.lastEntryID
+	}
+
+	inserted := copyEntries(entries)
+	sortEntries(inserted)
+
+	return inserted, nil
+}
+
+// syncEntries replica syncWorkoutEntries: las entries con id reemplazan a las guardadas, las nuevas
+// reciben un id (en el slice que recibe) y las que no vinieron se descartan. Devuelve la copia a guardar
+func (ms *MemoryWorkoutStore) syncEntries(saved, entries []WorkoutEntry) ([]WorkoutEntry, error) {
+	existing := make(map[int]bool, len(saved))
+	for _, e := range saved {
+		existing[e.ID] = false
+	}
+
+	for _, e := range entries {
+		if !validEntry(e) {
+			return nil, errMemoryWorkoutEntry
+		}
+
+		if e.ID == 0 {
+			continue
+		}
+
+		kept, ok := existing[e.ID]
+		if !ok || kept {
+			return nil, ErrInvalidEntryID
+		}
+		existing[e.ID] = true
+	}
+
+	for i := range entries {
+		if entries[i].ID == 0 {
+			ms.db.lastEntryID++
+			entries[i].ID = ms.db.lastEntryID
+		}
+	}
+
+	synced := copyEntries(entries)
+	sortEntries(synced)
+
+	return synced, nil
+}
+
+// sortEntries ordena como loadWorkoutEntries: por order_index y despues por id
+func sortEntries(entries []WorkoutEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].OrderIndex != entries[j].OrderIndex {
+			return entries[i].OrderIndex < entries[j].OrderIndex
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+func (ms *MemoryWorkoutStore) CreateWorkout(ctx context.Context, w *Workout) (*Workout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.users[w.UserID]; !ok {
+		return nil, errMemoryForeignKey
+	}
+
+	if w.Visibility == "" {
+		w.Visibility = VisibilityPrivate
+	}
+
+	err := ms.checkWorkout(w)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ms.insertEntries(w.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.db.lastWorkoutID++
+	w.ID = ms.db.lastWorkoutID
+	w.CreatedAt = time.Now().UTC()
+	w.Version = 1
+
+	saved := copyWorkout(w)
+	saved.Entries = entries
+	//como en postgres, deleted_at no se toma del workout que llega
+	saved.DeletedAt = nil
+	ms.db.workouts[w.ID] = saved
+
+	if err := ms.db.recordAudit(ctx, int64(w.ID)); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (ms *MemoryWorkoutStore) GetWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	w, ok := ms.liveWorkout(int(id))
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyWorkout(w), nil
+}
+
+func (ms *MemoryWorkoutStore) GetWorkoutByShareToken(ctx context.Context, token string) (*Workout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	for _, w := range ms.db.workouts {
+		if w.DeletedAt == nil && w.Visibility == VisibilityPublicLink && w.ShareToken != nil && *w.ShareToken == token {
+			return copyWorkout(w), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (ms *MemoryWorkoutStore) GetWorkouts(ctx context.Context, filter WorkoutFilter) (*WorkoutPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filter.normalize()
+
+	cursor, err := filter.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	workouts := []*Workout{}
+	for _, w := range ms.db.workouts {
+		if w.DeletedAt == nil && ms.visibleInList(w, filter.ViewerID) && matchesWorkoutFilter(w, filter) && afterCursor(w, filter, cursor) {
+			workouts = append(workouts, copyWorkout(w))
+		}
+	}
+
+	sort.Slice(workouts, func(i, j int) bool {
+		return compareWorkouts(workouts[i], workouts[j], filter) < 0
+	})
+
+	page := &WorkoutPage{Workouts: workouts}
+
+	if len(workouts) > filter.Limit {
+		page.Workouts = workouts[:filter.Limit]
+		page.NextCursor = filter.cursorAfter(page.Workouts[filter.Limit-1])
+	}
+
+	return page, nil
+}
+
+// visibleInList replica la condicion de visibilidad del listado en postgres. Se llama con el lock tomado
+func (ms *MemoryWorkoutStore) visibleInList(w *Workout, viewerID int) bool {
+	if w.UserID == viewerID {
+		return true
+	}
+
+	if ms.db.isCoach(viewerID, w.UserID) {
+		return true
+	}
+
+	_, following := ms.db.follows[follow{followerID: viewerID, followeeID: w.UserID}]
+
+	return w.Visibility == VisibilityFollowers && following
+}
+
+func matchesWorkoutFilter(w *Workout, f WorkoutFilter) bool {
+	if f.UserID != 0 && w.UserID != f.UserID {
+		return false
+	}
+	if f.Title != "" && !strings.Contains(strings.ToLower(w.Title), strings.ToLower(f.Title)) {
+		return false
+	}
+	if f.MinDuration != nil && w.DurationMinutes < *f.MinDuration {
+		return false
+	}
+	if f.MaxDuration != nil && w.DurationMinutes > *f.MaxDuration {
+		return false
+	}
+	if f.MinCalories != nil && w.CaloriesBurned < *f.MinCalories {
+		return false
+	}
+	if f.MaxCalories != nil && w.CaloriesBurned > *f.MaxCalories {
+		return false
+	}
+	return true
+}
+
+// compareWorkouts ordena como el ORDER BY de postgres: por la columna de orden y despues por id
+func compareWorkouts(a, b *Workout, f WorkoutFilter) int {
+	var c int
+	switch f.SortBy {
+	case WorkoutSortDuration:
+		c = cmp.Compare(a.DurationMinutes, b.DurationMinutes)
+	case WorkoutSortCalories:
+		c = cmp.Compare(a.CaloriesBurned, b.CaloriesBurned)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+
+	if c == 0 {
+		c = cmp.Compare(a.ID, b.ID)
+	}
+
+	if f.Descending {
+		return -c
+	}
+	return c
+}
+
+func afterCursor(w *Workout, f WorkoutFilter, cursor *workoutCursor) bool {
+	if cursor == nil {
+		return true
+	}
+
+	//armamos un workout "falso" con los valores del cursor para reusar compareWorkouts
+	last := &Workout{ID: cursor.ID}
+	value, _ := cursor.value()
+	switch v := value.(type) {
+	case time.Time:
+		last.CreatedAt = v
+	case int:
+		last.DurationMinutes = v
+		last.CaloriesBurned = v
+	}
+
+	return compareWorkouts(w, last, f) > 0
+}
+
+func (ms *MemoryWorkoutStore) UpdateWorkout(ctx context.Context, w *Workout) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, err := ms.savedVersion(w)
+	if err != nil {
+		return err
+	}
+
+	err = ms.checkWorkout(w)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ms.syncEntries(saved.Entries, w.Entries)
+	if err != nil {
+		return err
+	}
+
+	updated := copyWorkout(w)
+	updated.UserID = saved.UserID
+	updated.CreatedAt = saved.CreatedAt
+	updated.DeletedAt = saved.DeletedAt
+	updated.Entries = entries
+	updated.Version = saved.Version + 1
+	ms.db.workouts[w.ID] = updated
+	w.Version = updated.Version
+
+	return ms.db.recordAudit(ctx, int64(w.ID))
+}
+
+func (ms *MemoryWorkoutStore) GetWorkoutOwner(ctx context.Context, id int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	w, ok := ms.liveWorkout(int(id))
+	if !ok {
+		return -1, sql.ErrNoRows
+	}
+
+	return w.UserID, nil
+}
+
+func (ms *MemoryWorkoutStore) DeleteWorkout(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	w, ok := ms.liveWorkout(int(id))
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	deletedAt := dbTime(time.Now())
+	w.DeletedAt = &deletedAt
+	w.Version++
+
+	return ms.db.recordAudit(ctx, id)
+}
+
+func (ms *MemoryWorkoutStore) GetDeletedWorkouts(ctx context.Context, userID int) ([]*Workout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	workouts := []*Workout{}
+	for _, w := range ms.db.workouts {
+		if w.UserID == userID && w.DeletedAt != nil {
+			workouts = append(workouts, copyWorkout(w))
+		}
+	}
+
+	//igual que el ORDER BY deleted_at DESC, id DESC de postgres
+	sort.Slice(workouts, func(i, j int) bool {
+		if c := workouts[i].DeletedAt.Compare(*workouts[j].DeletedAt); c != 0 {
+			return c > 0
+		}
+		return workouts[i].ID > workouts[j].ID
+	})
+
+	return workouts, nil
+}
+
+func (ms *MemoryWorkoutStore) GetDeletedWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	w, ok := ms.db.workouts[int(id)]
+	if !ok || w.DeletedAt == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyWorkout(w), nil
+}
+
+func (ms *MemoryWorkoutStore) RestoreWorkout(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	w, ok := ms.db.workouts[int(id)]
+	if !ok || w.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+
+	w.DeletedAt = nil
+	w.Version++
+
+	return ms.db.recordAudit(ctx, id)
+}
+
+func (ms *MemoryWorkoutStore) PurgeWorkout(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.db.workouts[int(id)]; !ok {
+		return sql.ErrNoRows
+	}
+
+	ms.purgeWorkout(int(id))
+
+	return ms.db.recordAudit(ctx, id)
+}
+
+func (ms *MemoryWorkoutStore) PurgeDeletedWorkouts(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	var purged int64
+	for id, w := range ms.db.workouts {
+		if w.DeletedAt != nil && w.DeletedAt.Before(before) {
+			ms.purgeWorkout(id)
+			purged++
+
+			event := &AuditEvent{Action: AuditActionWorkoutPurge, TargetType: AuditTargetWorkout}
+
+			if err := ms.db.recordAudit(WithAuditEvent(ctx, event), int64(id)); err != nil {
+				return purged, err
+			}
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeWorkout borra el workout con sus notas. Se llama con el lock tomado
+func (ms *MemoryWorkoutStore) purgeWorkout(id int) {
+	//las entries viven dentro del workout, asi que se borran con el (ON DELETE CASCADE)
+	delete(ms.db.workouts, id)
+	ms.db.deleteWorkoutNotes(func(n *WorkoutNote) bool { return n.WorkoutID == id })
+}
+
+// liveWorkout devuelve el workout guardado si existe y no esta en la papelera. Se llama con el lock tomado
+func (ms *MemoryWorkoutStore) liveWorkout(id int) (*Workout, bool) {
+	w, ok := ms.db.workouts[id]
+	if !ok || w.DeletedAt != nil {
+		return nil, false
+	}
+
+	return w, true
+}
+
+func (ms *MemoryWorkoutStore) CreateWorkoutNote(ctx context.Context, note *WorkoutNote) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	if _, ok := ms.liveWorkout(note.WorkoutID); !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := ms.db.users[note.AuthorID]; !ok {
+		return errMemoryForeignKey
+	}
+
+	ms.db.lastNoteID++
+	note.ID = ms.db.lastNoteID
+	note.CreatedAt = time.Now()
+
+	copied := *note
+	ms.db.workoutNotes[note.ID] = &copied
+
+	return ms.db.recordAudit(ctx, int64(note.WorkoutID))
+}
+
+func (ms *MemoryWorkoutStore) GetWorkoutNotes(ctx context.Context, workoutID int64) ([]*WorkoutNote, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.db.mu.RLock()
+	defer ms.db.mu.RUnlock()
+
+	notes := []*WorkoutNote{}
+	for _, n := range ms.db.workoutNotes {
+		if n.WorkoutID == int(workoutID) {
+			copied := *n
+			notes = append(notes, &copied)
+		}
+	}
+
+	//los ids son incrementales, ordenar por id es ordenar por fecha de creacion
+	sort.Slice(notes, func(i, j int) bool { return notes[i].ID < notes[j].ID })
+
+	return notes, nil
+}
+
+// deleteWorkoutNotes borra las notas que cumplen match. Se llama con el lock tomado
+func (db *MemoryDB) deleteWorkoutNotes(match func(n *WorkoutNote) bool) {
+	for id, n := range db.workoutNotes {
+		if match(n) {
+			delete(db.workoutNotes, id)
+		}
+	}
+}
+
+// savedVersion devuelve el workout guardado si su version sigue siendo w.Version, como
+// bumpWorkoutVersion. Se llama con el lock tomado
+func (ms *MemoryWorkoutStore) savedVersion(w *Workout) (*Workout, error) {
+	saved, ok := ms.liveWorkout(w.ID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	if saved.Version != w.Version {
+		return nil, ErrEditConflict
+	}
+
+	return saved, nil
+}
+
+// bumpVersion sube la version del workout guardado y se la asigna a w. Se llama con el lock tomado
+func bumpVersion(saved, w *Workout) {
+	saved.Version++
+	w.Version = saved.Version
+}
+
+func (ms *MemoryWorkoutStore) CreateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, err := ms.savedVersion(w)
+	if err != nil {
+		return err
+	}
+
+	if !validEntry(*e) {
+		return errMemoryWorkoutEntry
+	}
+
+	ms.db.lastEntryID++
+	e.ID = ms.db.lastEntryID
+
+	saved.Entries = append(saved.Entries, copyEntries([]WorkoutEntry{*e})...)
+	sortEntries(saved.Entries)
+	bumpVersion(saved, w)
+
+	return ms.db.recordAudit(ctx, int64(w.ID))
+}
+
+func (ms *MemoryWorkoutStore) UpdateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, err := ms.savedVersion(w)
+	if err != nil {
+		return err
+	}
+
+	i := findEntry(saved, int64(e.ID))
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+
+	if !validEntry(*e) {
+		return errMemoryWorkoutEntry
+	}
+
+	saved.Entries[i] = copyEntries([]WorkoutEntry{*e})[0]
+	sortEntries(saved.Entries)
+	bumpVersion(saved, w)
+
+	return ms.db.recordAudit(ctx, int64(w.ID))
+}
+
+func (ms *MemoryWorkoutStore) DeleteWorkoutEntry(ctx context.Context, w *Workout, entryID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, err := ms.savedVersion(w)
+	if err != nil {
+		return err
+	}
+
+	i := findEntry(saved, entryID)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+
+	saved.Entries = append(saved.Entries[:i:i], saved.Entries[i+1:]...)
+	bumpVersion(saved, w)
+
+	return ms.db.recordAudit(ctx, int64(w.ID))
+}
+
+func (ms *MemoryWorkoutStore) ReorderWorkoutEntries(ctx context.Context, w *Workout, entryIDs []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.db.mu.Lock()
+	defer ms.db.mu.Unlock()
+
+	saved, err := ms.savedVersion(w)
+	if err != nil {
+		return err
+	}
+
+	if len(entryIDs) != len(saved.Entries) {
+		return ErrInvalidEntryOrder
+	}
+
+	position := make(map[int]int, len(entryIDs))
+	for i, id := range entryIDs {
+		if _, repeated := position[id]; repeated {
+			return ErrInvalidEntryOrder
+		}
+		position[id] = i
+	}
+
+	//primero se chequean todas, asi un orden invalido no deja nada a medias
+	for _, e := range saved.Entries {
+		if _, ok := position[e.ID]; !ok {
+			return ErrInvalidEntryOrder
+		}
+	}
+
+	for i := range saved.Entries {
+		saved.Entries[i].OrderIndex = position[saved.Entries[i].ID]
+	}
+	sortEntries(saved.Entries)
+	bumpVersion(saved, w)
+
+	return ms.db.recordAudit(ctx, int64(w.ID))
+}
+
+// findEntry devuelve la posicion de la entry en el workout, o -1 si no es suya
+func findEntry(w *Workout, entryID int64) int {
+	for i, e := range w.Entries {
+		if e.ID == int(entryID) {
+			return i
+		}
+	}
+
+	return -1
+}
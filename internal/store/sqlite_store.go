@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Los stores de sqlite reutilizan las queries de los stores de postgres: son SQL estandar,
+// sqlite soporta RETURNING y el driver (modernc.org/sqlite) acepta los placeholders $1, $2...
+// Si alguna query deja de ser compatible, se sobreescribe el metodo en el store de sqlite.
+
+type SQLiteWorkoutStore struct {
+	*PostgresWorkoutStore
+}
+
+func NewSQLiteWorkoutStore(db *sql.DB, queryTimeout time.Duration) *SQLiteWorkoutStore {
+	return &SQLiteWorkoutStore{PostgresWorkoutStore: NewPostgresWorkoutStore(db, queryTimeout)}
+}
+
+func (s *SQLiteWorkoutStore) GetWorkouts(ctx context.Context, filter WorkoutFilter) (*WorkoutPage, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return getWorkouts(ctx, s.db, filter, sqliteDialect)
+}
+
+type SQLiteUserStore struct {
+	*PostgresUserStore
+}
+
+func NewSQLiteUserStore(db *sql.DB, queryTimeout time.Duration) *SQLiteUserStore {
+	return &SQLiteUserStore{PostgresUserStore: NewPostgresUserStore(db, queryTimeout)}
+}
+
+type SQLiteTokenStore struct {
+	*PostgresTokenStore
+}
+
+func NewSQLiteTokenStore(db *sql.DB, queryTimeout time.Duration) *SQLiteTokenStore {
+	return &SQLiteTokenStore{PostgresTokenStore: NewPostgresTokenStore(db, queryTimeout)}
+}
+
+type SQLiteLoginAttemptStore struct {
+	*PostgresLoginAttemptStore
+}
+
+func NewSQLiteLoginAttemptStore(db *sql.DB, queryTimeout time.Duration) *SQLiteLoginAttemptStore {
+	return &SQLiteLoginAttemptStore{PostgresLoginAttemptStore: NewPostgresLoginAttemptStore(db, queryTimeout)}
+}
+
+type SQLiteAPIKeyStore struct {
+	*PostgresAPIKeyStore
+}
+
+func NewSQLiteAPIKeyStore(db *sql.DB, queryTimeout time.Duration) *SQLiteAPIKeyStore {
+	return &SQLiteAPIKeyStore{PostgresAPIKeyStore: NewPostgresAPIKeyStore(db, queryTimeout)}
+}
+
+type SQLiteCoachingStore struct {
+	*PostgresCoachingStore
+}
+
+func NewSQLiteCoachingStore(db *sql.DB, queryTimeout time.Duration) *SQLiteCoachingStore {
+	return &SQLiteCoachingStore{PostgresCoachingStore: NewPostgresCoachingStore(db, queryTimeout)}
+}
+
+type SQLiteAuditStore struct {
+	*PostgresAuditStore
+}
+
+func NewSQLiteAuditStore(db *sql.DB, queryTimeout time.Duration) *SQLiteAuditStore {
+	return &SQLiteAuditStore{PostgresAuditStore: NewPostgresAuditStore(db, queryTimeout)}
+}
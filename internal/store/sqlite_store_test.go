@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupSQLiteDB crea una base sqlite nueva por test, no hace falta ningun servidor
+func setupSQLiteDB(t testing.TB) *sql.DB {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+
+	if err != nil {
+		t.Fatalf("Connect to DB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = Migrate(db, DriverSQLite, "../../migrations/sqlite")
+
+	if err != nil {
+		t.Fatalf("Running migrations: %v", err)
+	}
+
+	return db
+}
+
+func newSQLiteStores(t *testing.T) stores {
+	db := setupSQLiteDB(t)
+	return stores{
+		workouts:      NewSQLiteWorkoutStore(db, 0),
+		users:         NewSQLiteUserStore(db, 0),
+		tokens:        NewSQLiteTokenStore(db, 0),
+		loginAttempts: NewSQLiteLoginAttemptStore(db, 0),
+		apiKeys:       NewSQLiteAPIKeyStore(db, 0),
+		coachings:     NewSQLiteCoachingStore(db, 0),
+		audit:         NewSQLiteAuditStore(db, 0),
+	}
+}
+
+func TestSQLiteStores(t *testing.T) {
+	runStoreSuite(t, newSQLiteStores)
+}
+
+func TestSQLiteQueryTimeout(t *testing.T) {
+	db := setupSQLiteDB(t)
+	workouts := NewSQLiteWorkoutStore(db, time.Nanosecond)
+
+	_, err := workouts.GetWorkouts(context.Background(), WorkoutFilter{ViewerID: 1})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// BenchmarkSQLiteGetWorkouts mide cuanto tarda listar una pagina de workouts (con 5 entries cada uno)
+// segun el tamaño de la pagina. Las entries se cargan en una sola query, asi que el costo
+// crece con las filas leidas y no con la cantidad de round trips
+func BenchmarkSQLiteGetWorkouts(b *testing.B) {
+	for _, size := range []int{10, 50, MaxWorkoutsLimit} {
+		b.Run(fmt.Sprintf("workouts=%d", size), func(b *testing.B) {
+			db := setupSQLiteDB(b)
+			s := stores{workouts: NewSQLiteWorkoutStore(db, 0), users: NewSQLiteUserStore(db, 0)}
+			user := createTestUser(b, s, "joaquin")
+			ctx := context.Background()
+
+			for i := 0; i < size; i++ {
+				w := &Workout{UserID: user.ID, Title: fmt.Sprintf("workout %d", i), DurationMinutes: 60}
+				for j := 0; j < 5; j++ {
+					w.Entries = append(w.Entries, WorkoutEntry{ExerciseName: "Squat", Sets: 3, Reps: IntPtr(10), OrderIndex: j})
+				}
+
+				_, err := s.workouts.CreateWorkout(ctx, w)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			filter := WorkoutFilter{ViewerID: user.ID, Limit: size}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				page, err := s.workouts.GetWorkouts(ctx, filter)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(page.Workouts) != size {
+					b.Fatalf("got %d workouts, want %d", len(page.Workouts), size)
+				}
+			}
+		})
+	}
+}
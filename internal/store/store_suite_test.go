@@ -0,0 +1,1260 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stores agrupa una implementacion de cada interface sobre la misma db,
+// asi las mismas pruebas corren contra memoria, sqlite, etc
+type stores struct {
+	workouts      WorkoutStore
+	users         UserStore
+	tokens        TokenStore
+	loginAttempts LoginAttemptStore
+	apiKeys       APIKeyStore
+	coachings     CoachingStore
+	audit         AuditStore
+}
+
+func createTestUser(t testing.TB, s stores, username string) *User {
+	t.Helper()
+
+	user := &User{Username: username, Email: username + "@mail.com"}
+	//no usamos Set para no pagar bcrypt en cada test
+	user.PasswordHash.hash = []byte("not-a-real-hash")
+
+	require.NoError(t, s.users.CreateUser(context.Background(), user))
+	return user
+}
+
+// runStoreSuite corre el comportamiento que esperamos de cualquier implementacion de los stores
+func runStoreSuite(t *testing.T, newStores func(t *testing.T) stores) {
+	ctx := context.Background()
+
+	t.Run("workouts", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		created, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID:          user.ID,
+			Title:           "push day",
+			DurationMinutes: 60,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Bench press", Sets: 3, Reps: IntPtr(10), Weight: FloatPtr(80.5), OrderIndex: 2},
+				{ExerciseName: "Plank", Sets: 3, DurationSeconds: IntPtr(60), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		assert.NotZero(t, created.ID)
+		assert.NotZero(t, created.Entries[0].ID)
+
+		retrieved, err := s.workouts.GetWorkoutByID(ctx, int64(created.ID))
+		require.NoError(t, err)
+		assert.Equal(t, "push day", retrieved.Title)
+		require.Len(t, retrieved.Entries, 2)
+		assert.Equal(t, "Plank", retrieved.Entries[0].ExerciseName)
+		assert.Equal(t, 80.5, *retrieved.Entries[1].Weight)
+
+		owner, err := s.workouts.GetWorkoutOwner(ctx, int64(created.ID))
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, owner)
+
+		retrieved.Title = "pull day"
+		retrieved.Entries = []WorkoutEntry{{ExerciseName: "Row", Sets: 4, Reps: IntPtr(8), OrderIndex: 1}}
+		require.NoError(t, s.workouts.UpdateWorkout(ctx, retrieved))
+
+		updated, err := s.workouts.GetWorkoutByID(ctx, int64(created.ID))
+		require.NoError(t, err)
+		assert.Equal(t, "pull day", updated.Title)
+		require.Len(t, updated.Entries, 1)
+		assert.Equal(t, "Row", updated.Entries[0].ExerciseName)
+
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID})
+		require.NoError(t, err)
+		assert.Len(t, page.Workouts, 1)
+
+		require.NoError(t, s.workouts.DeleteWorkout(ctx, int64(created.ID)))
+		_, err = s.workouts.GetWorkoutByID(ctx, int64(created.ID))
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("entry with reps and duration", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		_, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID: user.ID,
+			Title:  "invalid",
+			Entries: []WorkoutEntry{
+				{ExerciseName: "squats", Sets: 4, Reps: IntPtr(12), DurationSeconds: IntPtr(60)},
+			},
+		})
+		assert.ErrorIs(t, err, ErrCheckViolation)
+
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID})
+		require.NoError(t, err)
+		assert.Empty(t, page.Workouts)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		s := newStores(t)
+
+		_, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: 999, Title: "orphan"})
+		assert.ErrorIs(t, err, ErrForeignKeyViolation)
+	})
+
+	t.Run("missing workout", func(t *testing.T) {
+		s := newStores(t)
+
+		_, err := s.workouts.GetWorkoutByID(ctx, 999)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = s.workouts.GetWorkoutOwner(ctx, 999)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		assert.ErrorIs(t, s.workouts.UpdateWorkout(ctx, &Workout{ID: 999}), sql.ErrNoRows)
+		assert.ErrorIs(t, s.workouts.DeleteWorkout(ctx, 999), sql.ErrNoRows)
+	})
+
+	t.Run("workout entries", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		workout, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID: user.ID,
+			Title:  "legs",
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 5, Reps: IntPtr(5), OrderIndex: 0},
+				{ExerciseName: "Lunge", Sets: 3, Reps: IntPtr(10), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		squatID, lungeID := workout.Entries[0].ID, workout.Entries[1].ID
+		workoutID := int64(workout.ID)
+
+		//el update conserva el id de las entries que vienen con id
+		workout.Entries[0].Sets = 4
+		workout.Entries = []WorkoutEntry{workout.Entries[0], {ExerciseName: "Calf raise", Sets: 3, Reps: IntPtr(15), OrderIndex: 2}}
+		require.NoError(t, s.workouts.UpdateWorkout(ctx, workout))
+		assert.NotZero(t, workout.Entries[1].ID)
+
+		retrieved, err := s.workouts.GetWorkoutByID(ctx, workoutID)
+		require.NoError(t, err)
+		require.Len(t, retrieved.Entries, 2)
+		assert.Equal(t, squatID, retrieved.Entries[0].ID)
+		assert.Equal(t, 4, retrieved.Entries[0].Sets)
+		assert.Equal(t, workout.Entries[1].ID, retrieved.Entries[1].ID)
+
+		//una entry borrada (o de otro workout) no se puede mandar por id
+		retrieved.Entries = append(retrieved.Entries, WorkoutEntry{ID: lungeID, ExerciseName: "Lunge", Sets: 3, Reps: IntPtr(10)})
+		assert.ErrorIs(t, s.workouts.UpdateWorkout(ctx, retrieved), ErrInvalidEntryID)
+		retrieved.Entries = []WorkoutEntry{retrieved.Entries[0], retrieved.Entries[0]}
+		assert.ErrorIs(t, s.workouts.UpdateWorkout(ctx, retrieved), ErrInvalidEntryID)
+
+		plank := &WorkoutEntry{ExerciseName: "Plank", Sets: 3, DurationSeconds: IntPtr(60), OrderIndex: 3}
+		require.NoError(t, s.workouts.CreateWorkoutEntry(ctx, workout, plank))
+		assert.NotZero(t, plank.ID)
+		assert.ErrorIs(t, s.workouts.CreateWorkoutEntry(ctx, &Workout{ID: 999}, &WorkoutEntry{ExerciseName: "x", Sets: 1, Reps: IntPtr(1)}), sql.ErrNoRows)
+		assert.ErrorIs(t, s.workouts.CreateWorkoutEntry(ctx, workout, &WorkoutEntry{ExerciseName: "x", Sets: 1}), ErrCheckViolation)
+
+		plank.DurationSeconds = IntPtr(90)
+		require.NoError(t, s.workouts.UpdateWorkoutEntry(ctx, workout, plank))
+		assert.ErrorIs(t, s.workouts.UpdateWorkoutEntry(ctx, workout, &WorkoutEntry{ID: lungeID, ExerciseName: "x", Sets: 1, Reps: IntPtr(1)}), sql.ErrNoRows)
+
+		retrieved, err = s.workouts.GetWorkoutByID(ctx, workoutID)
+		require.NoError(t, err)
+		require.Len(t, retrieved.Entries, 3)
+		assert.Equal(t, 90, *retrieved.Entries[2].DurationSeconds)
+
+		ids := []int{retrieved.Entries[2].ID, retrieved.Entries[0].ID, retrieved.Entries[1].ID}
+		assert.ErrorIs(t, s.workouts.ReorderWorkoutEntries(ctx, workout, ids[:2]), ErrInvalidEntryOrder)
+		assert.ErrorIs(t, s.workouts.ReorderWorkoutEntries(ctx, workout, []int{ids[0], ids[0], ids[1]}), ErrInvalidEntryOrder)
+		assert.ErrorIs(t, s.workouts.ReorderWorkoutEntries(ctx, workout, []int{ids[0], ids[1], lungeID}), ErrInvalidEntryOrder)
+		assert.ErrorIs(t, s.workouts.ReorderWorkoutEntries(ctx, &Workout{ID: 999}, nil), sql.ErrNoRows)
+		require.NoError(t, s.workouts.ReorderWorkoutEntries(ctx, workout, ids))
+
+		retrieved, err = s.workouts.GetWorkoutByID(ctx, workoutID)
+		require.NoError(t, err)
+		for i, entry := range retrieved.Entries {
+			assert.Equal(t, ids[i], entry.ID)
+			assert.Equal(t, i, entry.OrderIndex)
+		}
+
+		require.NoError(t, s.workouts.DeleteWorkoutEntry(ctx, workout, int64(plank.ID)))
+		assert.ErrorIs(t, s.workouts.DeleteWorkoutEntry(ctx, workout, int64(plank.ID)), sql.ErrNoRows)
+
+		retrieved, err = s.workouts.GetWorkoutByID(ctx, workoutID)
+		require.NoError(t, err)
+		assert.Len(t, retrieved.Entries, 2)
+		//create, update y reorder, cada uno sube la version (los que fallaron no)
+		assert.Equal(t, 6, retrieved.Version)
+		assert.Equal(t, retrieved.Version, workout.Version)
+	})
+
+	t.Run("workout versions", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		workout, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID:  user.ID,
+			Title:   "legs",
+			Entries: []WorkoutEntry{{ExerciseName: "Squat", Sets: 5, Reps: IntPtr(5)}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, workout.Version)
+
+		stale, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+		require.NoError(t, err)
+
+		workout.Title = "leg day"
+		require.NoError(t, s.workouts.UpdateWorkout(ctx, workout))
+		assert.Equal(t, 2, workout.Version)
+
+		//otra copia leida antes del cambio ya no se puede guardar
+		stale.Title = "overwritten"
+		assert.ErrorIs(t, s.workouts.UpdateWorkout(ctx, stale), ErrEditConflict)
+		assert.ErrorIs(t, s.workouts.CreateWorkoutEntry(ctx, stale, &WorkoutEntry{ExerciseName: "Lunge", Sets: 3, Reps: IntPtr(10)}), ErrEditConflict)
+		assert.ErrorIs(t, s.workouts.DeleteWorkoutEntry(ctx, stale, int64(stale.Entries[0].ID)), ErrEditConflict)
+		assert.ErrorIs(t, s.workouts.ReorderWorkoutEntries(ctx, stale, []int{stale.Entries[0].ID}), ErrEditConflict)
+		assert.Equal(t, 1, stale.Version)
+
+		retrieved, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+		require.NoError(t, err)
+		assert.Equal(t, "leg day", retrieved.Title)
+		assert.Len(t, retrieved.Entries, 1)
+		assert.Equal(t, 2, retrieved.Version)
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		workout, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID:  user.ID,
+			Title:   "legs",
+			Entries: []WorkoutEntry{{ExerciseName: "Squat", Sets: 5, Reps: IntPtr(5)}},
+		})
+		require.NoError(t, err)
+		id := int64(workout.ID)
+		require.NoError(t, s.workouts.CreateWorkoutNote(ctx, &WorkoutNote{WorkoutID: workout.ID, AuthorID: user.ID, Body: "good job"}))
+
+		require.NoError(t, s.workouts.DeleteWorkout(ctx, id))
+		assert.ErrorIs(t, s.workouts.DeleteWorkout(ctx, id), sql.ErrNoRows)
+
+		//en la papelera no se ve ni se puede modificar
+		_, err = s.workouts.GetWorkoutByID(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = s.workouts.GetWorkoutOwner(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID})
+		require.NoError(t, err)
+		assert.Empty(t, page.Workouts)
+		assert.ErrorIs(t, s.workouts.UpdateWorkout(ctx, workout), sql.ErrNoRows)
+		assert.ErrorIs(t, s.workouts.CreateWorkoutNote(ctx, &WorkoutNote{WorkoutID: workout.ID, AuthorID: user.ID, Body: "hidden"}), sql.ErrNoRows)
+
+		trash, err := s.workouts.GetDeletedWorkouts(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, trash, 1)
+		assert.NotNil(t, trash[0].DeletedAt)
+		assert.Len(t, trash[0].Entries, 1)
+
+		deleted, err := s.workouts.GetDeletedWorkoutByID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "legs", deleted.Title)
+
+		//restaurarlo lo deja como estaba, con entries y notas
+		require.NoError(t, s.workouts.RestoreWorkout(ctx, id))
+		assert.ErrorIs(t, s.workouts.RestoreWorkout(ctx, id), sql.ErrNoRows)
+		restored, err := s.workouts.GetWorkoutByID(ctx, id)
+		require.NoError(t, err)
+		assert.Nil(t, restored.DeletedAt)
+		assert.Len(t, restored.Entries, 1)
+		assert.Equal(t, 3, restored.Version)
+		notes, err := s.workouts.GetWorkoutNotes(ctx, id)
+		require.NoError(t, err)
+		assert.Len(t, notes, 1)
+		_, err = s.workouts.GetDeletedWorkoutByID(ctx, id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//la purga solo borra los que estan en la papelera desde antes de la fecha
+		other, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: user.ID, Title: "arms"})
+		require.NoError(t, err)
+		require.NoError(t, s.workouts.DeleteWorkout(ctx, int64(other.ID)))
+
+		purged, err := s.workouts.PurgeDeletedWorkouts(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, purged)
+
+		purged, err = s.workouts.PurgeDeletedWorkouts(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		_, err = s.workouts.GetDeletedWorkoutByID(ctx, int64(other.ID))
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = s.workouts.GetWorkoutByID(ctx, id)
+		require.NoError(t, err)
+
+		require.NoError(t, s.workouts.PurgeWorkout(ctx, id))
+		assert.ErrorIs(t, s.workouts.PurgeWorkout(ctx, id), sql.ErrNoRows)
+		assert.ErrorIs(t, s.workouts.RestoreWorkout(ctx, id), sql.ErrNoRows)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		actorID := user.ID
+
+		//el evento de un create no sabe el id del workout, lo completa el store
+		created := &AuditEvent{ActorID: &actorID, Action: "workout.create", TargetType: AuditTargetWorkout, RequestID: "req-1", IP: "10.0.0.1"}
+		workout := &Workout{UserID: user.ID, Title: "legs", Entries: []WorkoutEntry{{ExerciseName: "Squat", Sets: 5, Reps: IntPtr(5)}}}
+		created.SetAfter(workout)
+		_, err := s.workouts.CreateWorkout(WithAuditEvent(ctx, created), workout)
+		require.NoError(t, err)
+		assert.NotZero(t, created.ID)
+		assert.Equal(t, int64(workout.ID), created.TargetID)
+
+		updated := &AuditEvent{ActorID: &actorID, Action: "workout.update", TargetType: AuditTargetWorkout, TargetID: int64(workout.ID)}
+		updated.SetBefore(workout)
+		workout.Title = "leg day"
+		updated.SetAfter(workout)
+		require.NoError(t, s.workouts.UpdateWorkout(WithAuditEvent(ctx, updated), workout))
+
+		//un cambio que falla no deja evento
+		failed := &AuditEvent{ActorID: &actorID, Action: "workout.delete", TargetType: AuditTargetWorkout, TargetID: 999}
+		assert.ErrorIs(t, s.workouts.DeleteWorkout(WithAuditEvent(ctx, failed), 999), sql.ErrNoRows)
+		assert.Zero(t, failed.ID)
+
+		//y los cambios sin evento tampoco
+		require.NoError(t, s.workouts.DeleteWorkout(ctx, int64(workout.ID)))
+
+		events, err := s.audit.GetAuditEvents(ctx, AuditFilter{TargetType: AuditTargetWorkout, TargetID: int64(workout.ID)})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		//del mas nuevo al mas viejo, y solo con los campos que cambiaron
+		assert.Equal(t, "workout.update", events[0].Action)
+		assert.Equal(t, map[string]AuditChange{"title": {Before: "legs", After: "leg day"}}, events[0].Changes)
+		require.NotNil(t, events[0].ActorUsername)
+		assert.Equal(t, "joaquin", *events[0].ActorUsername)
+
+		assert.Equal(t, "workout.create", events[1].Action)
+		assert.Equal(t, "req-1", events[1].RequestID)
+		assert.Equal(t, "10.0.0.1", events[1].IP)
+		assert.Equal(t, "legs", events[1].Changes["title"].After)
+		assert.Nil(t, events[1].Changes["title"].Before)
+		assert.NotContains(t, events[1].Changes, "share_token")
+
+		//la purga de la papelera deja un evento por workout, sin actor
+		purged, err := s.workouts.PurgeDeletedWorkouts(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), purged)
+
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{Action: AuditActionWorkoutPurge})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, int64(workout.ID), events[0].TargetID)
+		assert.Equal(t, AuditTargetWorkout, events[0].TargetType)
+		assert.Nil(t, events[0].ActorID)
+
+		//los filtros y la paginacion por id
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{Action: "workout.create"})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, created.ID, events[0].ID)
+
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{ActorID: user.ID, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, updated.ID, events[0].ID)
+
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{ActorID: user.ID, BeforeID: updated.ID})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, created.ID, events[0].ID)
+
+		future := time.Now().Add(time.Hour)
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{Since: &future})
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		//el evento queda aunque se borre el usuario que lo hizo
+		deleted := &AuditEvent{ActorID: &actorID, Action: "user.delete", TargetType: AuditTargetUser, TargetID: int64(user.ID)}
+		require.NoError(t, s.users.DeleteUser(WithAuditEvent(ctx, deleted), user.ID))
+
+		events, err = s.audit.GetAuditEvents(ctx, AuditFilter{TargetType: AuditTargetUser})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.NotNil(t, events[0].ActorID)
+		assert.Equal(t, user.ID, *events[0].ActorID)
+		assert.Nil(t, events[0].ActorUsername)
+	})
+
+	t.Run("list workouts", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		seed := []struct {
+			title    string
+			duration int
+			calories int
+		}{
+			{"Push day", 60, 500},
+			{"Pull day", 45, 300},
+			{"Leg day", 90, 700},
+			{"push 100%", 30, 200},
+			{"Cardio", 45, 400},
+		}
+		for _, w := range seed {
+			_, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: user.ID, Title: w.title, DurationMinutes: w.duration, CaloriesBurned: w.calories})
+			require.NoError(t, err)
+		}
+
+		titles := func(workouts []*Workout) []string {
+			var result []string
+			for _, w := range workouts {
+				result = append(result, w.Title)
+			}
+			return result
+		}
+
+		//por defecto los mas nuevos primero
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Cardio", "push 100%", "Leg day", "Pull day", "Push day"}, titles(page.Workouts))
+		assert.Empty(t, page.NextCursor)
+
+		//allPages recorre todas las paginas de a 2
+		allPages := func(filter WorkoutFilter) []string {
+			filter.Limit = 2
+			var all []*Workout
+			for {
+				page, err := s.workouts.GetWorkouts(ctx, filter)
+				require.NoError(t, err)
+				all = append(all, page.Workouts...)
+				if page.NextCursor == "" {
+					return titles(all)
+				}
+				require.Len(t, page.Workouts, 2)
+				filter.Cursor = page.NextCursor
+			}
+		}
+
+		assert.Equal(t, []string{"Cardio", "push 100%", "Leg day", "Pull day", "Push day"}, allPages(WorkoutFilter{ViewerID: user.ID}))
+		assert.Equal(t, []string{"Push day", "Pull day", "Leg day", "push 100%", "Cardio"}, allPages(WorkoutFilter{ViewerID: user.ID, SortBy: WorkoutSortCreatedAt}))
+		//los empates se ordenan por id
+		assert.Equal(t, []string{"push 100%", "Pull day", "Cardio", "Push day", "Leg day"}, allPages(WorkoutFilter{ViewerID: user.ID, SortBy: WorkoutSortDuration}))
+
+		filter := WorkoutFilter{ViewerID: user.ID, Limit: 2, SortBy: WorkoutSortCalories, Descending: true}
+		page, err = s.workouts.GetWorkouts(ctx, filter)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Leg day", "Push day"}, titles(page.Workouts))
+		filter.Cursor = page.NextCursor
+		page, err = s.workouts.GetWorkouts(ctx, filter)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Cardio", "Pull day"}, titles(page.Workouts))
+
+		page, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Title: "PUSH", SortBy: WorkoutSortDuration})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"push 100%", "Push day"}, titles(page.Workouts))
+
+		//el % se busca literal, no como comodin
+		page, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Title: "0%"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"push 100%"}, titles(page.Workouts))
+
+		page, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{
+			ViewerID:    user.ID,
+			SortBy:      WorkoutSortDuration,
+			MinDuration: IntPtr(45),
+			MaxDuration: IntPtr(60),
+			MinCalories: IntPtr(350),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Cardio", "Push day"}, titles(page.Workouts))
+
+		_, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+
+		//un cursor solo sirve con el mismo orden con el que se genero
+		page, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Limit: 1, SortBy: WorkoutSortDuration})
+		require.NoError(t, err)
+		_, err = s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, Limit: 1, SortBy: WorkoutSortCalories, Cursor: page.NextCursor})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("visibility", func(t *testing.T) {
+		s := newStores(t)
+		owner := createTestUser(t, s, "joaquin")
+		follower := createTestUser(t, s, "follower")
+		stranger := createTestUser(t, s, "stranger")
+
+		shareToken := "share-token"
+		for _, w := range []*Workout{
+			{UserID: owner.ID, Title: "private"},
+			{UserID: owner.ID, Title: "followers", Visibility: VisibilityFollowers},
+			{UserID: owner.ID, Title: "public", Visibility: VisibilityPublicLink, ShareToken: &shareToken},
+		} {
+			_, err := s.workouts.CreateWorkout(ctx, w)
+			require.NoError(t, err)
+		}
+
+		_, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: owner.ID, Title: "invalid", Visibility: "everyone"})
+		assert.ErrorIs(t, err, ErrCheckViolation)
+
+		_, err = s.workouts.CreateWorkout(ctx, &Workout{UserID: stranger.ID, Title: "same token", Visibility: VisibilityPublicLink, ShareToken: &shareToken})
+		assert.ErrorIs(t, err, ErrUniqueViolation)
+
+		require.NoError(t, s.users.FollowUser(ctx, follower.ID, owner.ID))
+		//seguir dos veces no es un error
+		require.NoError(t, s.users.FollowUser(ctx, follower.ID, owner.ID))
+		assert.ErrorIs(t, s.users.FollowUser(ctx, owner.ID, owner.ID), ErrCheckViolation)
+		assert.ErrorIs(t, s.users.FollowUser(ctx, follower.ID, 999), sql.ErrNoRows)
+
+		following, err := s.users.IsFollowing(ctx, follower.ID, owner.ID)
+		require.NoError(t, err)
+		assert.True(t, following)
+
+		following, err = s.users.IsFollowing(ctx, owner.ID, follower.ID)
+		require.NoError(t, err)
+		assert.False(t, following)
+
+		count := func(viewer *User) int {
+			page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: viewer.ID})
+			require.NoError(t, err)
+			return len(page.Workouts)
+		}
+
+		assert.Equal(t, 3, count(owner))
+		assert.Equal(t, 1, count(follower))
+		assert.Equal(t, 0, count(stranger))
+
+		shared, err := s.workouts.GetWorkoutByShareToken(ctx, shareToken)
+		require.NoError(t, err)
+		assert.Equal(t, "public", shared.Title)
+
+		_, err = s.workouts.GetWorkoutByShareToken(ctx, "unknown")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//si el workout deja de ser publico el link deja de funcionar
+		shared.Visibility = VisibilityPrivate
+		require.NoError(t, s.workouts.UpdateWorkout(ctx, shared))
+		_, err = s.workouts.GetWorkoutByShareToken(ctx, shareToken)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		require.NoError(t, s.users.UnfollowUser(ctx, follower.ID, owner.ID))
+		assert.ErrorIs(t, s.users.UnfollowUser(ctx, follower.ID, owner.ID), sql.ErrNoRows)
+		assert.Equal(t, 0, count(follower))
+	})
+
+	t.Run("coaching", func(t *testing.T) {
+		s := newStores(t)
+		coach := createTestUser(t, s, "coach")
+		athlete := createTestUser(t, s, "athlete")
+		require.NoError(t, s.users.UpdateRole(ctx, coach.ID, RoleCoach))
+
+		workout, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: athlete.ID, Title: "private"})
+		require.NoError(t, err)
+
+		count := func(filter WorkoutFilter) int {
+			page, err := s.workouts.GetWorkouts(ctx, filter)
+			require.NoError(t, err)
+			return len(page.Workouts)
+		}
+
+		require.NoError(t, s.coachings.InviteAthlete(ctx, coach.ID, athlete.ID))
+		//invitar dos veces no es un error
+		require.NoError(t, s.coachings.InviteAthlete(ctx, coach.ID, athlete.ID))
+		assert.Error(t, s.coachings.InviteAthlete(ctx, coach.ID, coach.ID))
+		assert.ErrorIs(t, s.coachings.InviteAthlete(ctx, coach.ID, 999), sql.ErrNoRows)
+
+		//la invitacion pendiente no da acceso
+		isCoach, err := s.coachings.IsCoach(ctx, coach.ID, athlete.ID)
+		require.NoError(t, err)
+		assert.False(t, isCoach)
+		assert.Equal(t, 0, count(WorkoutFilter{ViewerID: coach.ID}))
+
+		require.NoError(t, s.coachings.AcceptInvite(ctx, coach.ID, athlete.ID))
+		assert.ErrorIs(t, s.coachings.AcceptInvite(ctx, coach.ID, athlete.ID), sql.ErrNoRows)
+		assert.ErrorIs(t, s.coachings.AcceptInvite(ctx, athlete.ID, coach.ID), sql.ErrNoRows)
+
+		isCoach, err = s.coachings.IsCoach(ctx, coach.ID, athlete.ID)
+		require.NoError(t, err)
+		assert.True(t, isCoach)
+
+		//el coach ve todos los workouts del atleta, sin importar la visibilidad, pero no al reves
+		assert.Equal(t, 1, count(WorkoutFilter{ViewerID: coach.ID}))
+		assert.Equal(t, 1, count(WorkoutFilter{ViewerID: coach.ID, UserID: athlete.ID}))
+		assert.Equal(t, 0, count(WorkoutFilter{ViewerID: coach.ID, UserID: coach.ID}))
+		_, err = s.workouts.CreateWorkout(ctx, &Workout{UserID: coach.ID, Title: "coach's own"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count(WorkoutFilter{ViewerID: athlete.ID}))
+
+		//sin el rol de coach pierde el acceso aunque el coaching siga aceptado
+		require.NoError(t, s.users.UpdateRole(ctx, coach.ID, RoleUser))
+		isCoach, err = s.coachings.IsCoach(ctx, coach.ID, athlete.ID)
+		require.NoError(t, err)
+		assert.False(t, isCoach)
+		assert.Equal(t, 0, count(WorkoutFilter{ViewerID: coach.ID, UserID: athlete.ID}))
+		require.NoError(t, s.users.UpdateRole(ctx, coach.ID, RoleCoach))
+
+		for _, user := range []*User{coach, athlete} {
+			coachings, err := s.coachings.GetCoachings(ctx, user.ID)
+			require.NoError(t, err)
+			require.Len(t, coachings, 1)
+			assert.Equal(t, "coach", coachings[0].CoachUsername)
+			assert.Equal(t, "athlete", coachings[0].AthleteUsername)
+			assert.True(t, coachings[0].IsActive())
+		}
+
+		note := &WorkoutNote{WorkoutID: workout.ID, AuthorID: coach.ID, Body: "more rest between sets"}
+		require.NoError(t, s.workouts.CreateWorkoutNote(ctx, note))
+		assert.NotZero(t, note.ID)
+		require.NoError(t, s.workouts.CreateWorkoutNote(ctx, &WorkoutNote{WorkoutID: workout.ID, AuthorID: athlete.ID, Body: "ok"}))
+		assert.ErrorIs(t, s.workouts.CreateWorkoutNote(ctx, &WorkoutNote{WorkoutID: 999, AuthorID: coach.ID, Body: "x"}), sql.ErrNoRows)
+
+		notes, err := s.workouts.GetWorkoutNotes(ctx, int64(workout.ID))
+		require.NoError(t, err)
+		require.Len(t, notes, 2)
+		assert.Equal(t, "more rest between sets", notes[0].Body)
+		assert.Equal(t, "ok", notes[1].Body)
+
+		//el atleta puede revocar el acceso en cualquier momento
+		require.NoError(t, s.coachings.DeleteCoaching(ctx, coach.ID, athlete.ID))
+		assert.ErrorIs(t, s.coachings.DeleteCoaching(ctx, coach.ID, athlete.ID), sql.ErrNoRows)
+		assert.Equal(t, 0, count(WorkoutFilter{ViewerID: coach.ID, UserID: athlete.ID}))
+
+		//las notas se borran cuando se purga el workout
+		require.NoError(t, s.workouts.PurgeWorkout(ctx, int64(workout.ID)))
+		notes, err = s.workouts.GetWorkoutNotes(ctx, int64(workout.ID))
+		require.NoError(t, err)
+		assert.Empty(t, notes)
+	})
+
+	t.Run("list workouts with entries", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		//el ultimo workout queda sin entries
+		for i, count := range []int{3, 2, 0} {
+			w := &Workout{UserID: user.ID, Title: fmt.Sprintf("workout %d", i+1)}
+			for j := 0; j < count; j++ {
+				w.Entries = append(w.Entries, WorkoutEntry{ExerciseName: fmt.Sprintf("exercise %d.%d", i+1, j), Sets: 3, Reps: IntPtr(10), OrderIndex: count - j})
+			}
+			_, err := s.workouts.CreateWorkout(ctx, w)
+			require.NoError(t, err)
+		}
+
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID, SortBy: WorkoutSortCreatedAt})
+		require.NoError(t, err)
+		require.Len(t, page.Workouts, 3)
+
+		names := func(w *Workout) []string {
+			var result []string
+			for _, e := range w.Entries {
+				result = append(result, e.ExerciseName)
+			}
+			return result
+		}
+
+		//cada workout recibe solo sus entries, ordenadas por order_index
+		assert.Equal(t, []string{"exercise 1.2", "exercise 1.1", "exercise 1.0"}, names(page.Workouts[0]))
+		assert.Equal(t, []string{"exercise 2.1", "exercise 2.0"}, names(page.Workouts[1]))
+		assert.Empty(t, page.Workouts[2].Entries)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := s.workouts.CreateWorkout(cancelled, &Workout{UserID: user.ID, Title: "never saved"})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = s.workouts.GetWorkouts(cancelled, WorkoutFilter{ViewerID: user.ID})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = s.users.GetUserByUsername(cancelled, "joaquin")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		page, err := s.workouts.GetWorkouts(ctx, WorkoutFilter{ViewerID: user.ID})
+		require.NoError(t, err)
+		assert.Empty(t, page.Workouts)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		assert.NotZero(t, user.ID)
+		assert.False(t, user.CreatedAt.IsZero())
+
+		assert.Error(t, s.users.CreateUser(ctx, &User{Username: "joaquin", Email: "other@mail.com"}))
+		assert.Error(t, s.users.CreateUser(ctx, &User{Username: "other", Email: "joaquin@mail.com"}))
+
+		user.Bio = "hola"
+		require.NoError(t, s.users.UpdateUser(ctx, user))
+
+		got, err := s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.Equal(t, "hola", got.Bio)
+		assert.Equal(t, user.PasswordHash.hash, got.PasswordHash.hash)
+
+		got, err = s.users.GetUserByUsername(ctx, "nobody")
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		valid, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		expired, err := s.tokens.CreateNewToken(ctx, user.ID, -time.Minute, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		got, err := s.users.GetUserToken(ctx, tokens.ScopeAuth, valid.Plaintext)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, expired.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = s.users.GetUserToken(ctx, "other-scope", valid.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("password reset", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		other := createTestUser(t, s, "other")
+
+		found, err := s.users.GetUserByEmail(ctx, "joaquin@mail.com")
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, user.ID, found.ID)
+
+		found, err = s.users.GetUserByEmail(ctx, "nobody@mail.com")
+		require.NoError(t, err)
+		assert.Nil(t, found)
+
+		reset, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+		session, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		othersSession, err := s.tokens.CreateNewToken(ctx, other.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		found, err = s.users.GetUserToken(ctx, tokens.ScopePasswordReset, reset.Plaintext)
+		require.NoError(t, err)
+		found.PasswordHash.hash = []byte("new-hash")
+		require.NoError(t, s.users.UpdatePassword(ctx, found, ""))
+
+		saved, err := s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new-hash"), saved.PasswordHash.hash)
+
+		//cambiar la password borra todos los tokens del usuario, pero no los de otros
+		for _, token := range []*tokens.Token{reset, session} {
+			_, err = s.users.GetUserToken(ctx, token.Scope, token.Plaintext)
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, othersSession.Plaintext)
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, s.users.UpdatePassword(ctx, &User{ID: 9999}, ""), sql.ErrNoRows)
+	})
+
+	t.Run("update password keeping a session", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		//newSession guarda el token de auth y el refresh de una sesion
+		newSession := func(familyID string) (*tokens.Token, *tokens.Token) {
+			auth, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+			refresh, err := tokens.GenerateToken(user.ID, 24*time.Hour, tokens.ScopeRefresh)
+			require.NoError(t, err)
+			auth.FamilyID, refresh.FamilyID = familyID, familyID
+			require.NoError(t, s.tokens.Insert(ctx, auth, refresh))
+			return auth, refresh
+		}
+
+		current, currentRefresh := newSession("current")
+		other, _ := newSession("other")
+		reset, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+
+		user.PasswordHash.hash = []byte("new-hash")
+		require.NoError(t, s.users.UpdatePassword(ctx, user, current.Plaintext))
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, current.Plaintext)
+		assert.NoError(t, err)
+		refreshTokens, err := s.tokens.GetTokensForUser(ctx, user.ID, tokens.ScopeRefresh)
+		require.NoError(t, err)
+		require.Len(t, refreshTokens, 1)
+		assert.Equal(t, currentRefresh.ID, refreshTokens[0].ID)
+
+		for _, token := range []*tokens.Token{other, reset} {
+			_, err = s.users.GetUserToken(ctx, token.Scope, token.Plaintext)
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+	})
+
+	t.Run("email verification", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		assert.False(t, user.IsVerified())
+
+		activation, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeActivation)
+		require.NoError(t, err)
+		session, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		require.NoError(t, s.users.VerifyEmail(ctx, user))
+		assert.True(t, user.IsVerified())
+
+		saved, err := s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.True(t, saved.IsVerified())
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeActivation, activation.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//cambiar la bio no toca la verificacion
+		saved.Bio = "hola"
+		require.NoError(t, s.users.UpdateUser(ctx, saved))
+		assert.True(t, saved.IsVerified())
+
+		//cambiar el email si: hay que volver a verificarlo y los tokens pendientes eran para el email viejo
+		activation, err = s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeActivation)
+		require.NoError(t, err)
+
+		saved.Email = "new@mail.com"
+		require.NoError(t, s.users.UpdateUser(ctx, saved))
+		assert.False(t, saved.IsVerified())
+
+		saved, err = s.users.GetUserByEmail(ctx, "new@mail.com")
+		require.NoError(t, err)
+		assert.False(t, saved.IsVerified())
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeActivation, activation.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, session.Plaintext)
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, s.users.VerifyEmail(ctx, &User{ID: 9999}), sql.ErrNoRows)
+		assert.ErrorIs(t, s.users.UpdateUser(ctx, &User{ID: 9999}), sql.ErrNoRows)
+	})
+
+	t.Run("duplicate users", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		createTestUser(t, s, "other")
+
+		dup := &User{Username: "joaquin", Email: "new@mail.com"}
+		dup.PasswordHash.hash = []byte("not-a-real-hash")
+		assert.ErrorIs(t, s.users.CreateUser(ctx, dup), ErrDuplicateUsername)
+
+		dup = &User{Username: "new", Email: "joaquin@mail.com"}
+		dup.PasswordHash.hash = []byte("not-a-real-hash")
+		assert.ErrorIs(t, s.users.CreateUser(ctx, dup), ErrDuplicateEmail)
+
+		user.Username = "other"
+		assert.ErrorIs(t, s.users.UpdateUser(ctx, user), ErrDuplicateUsername)
+		user.Username = "joaquin"
+		user.Email = "other@mail.com"
+		assert.ErrorIs(t, s.users.UpdateUser(ctx, user), ErrDuplicateEmail)
+		assert.ErrorIs(t, ErrDuplicateEmail, ErrUniqueViolation)
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		other := createTestUser(t, s, "other")
+
+		workout, err := s.workouts.CreateWorkout(ctx, &Workout{
+			UserID:  user.ID,
+			Title:   "push day",
+			Entries: []WorkoutEntry{{ExerciseName: "Bench press", Sets: 3, Reps: IntPtr(10), OrderIndex: 1}},
+		})
+		require.NoError(t, err)
+		othersWorkout, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: other.ID, Title: "pull day"})
+		require.NoError(t, err)
+
+		session, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		require.NoError(t, s.users.FollowUser(ctx, other.ID, user.ID))
+
+		require.NoError(t, s.users.DeleteUser(ctx, user.ID))
+		assert.ErrorIs(t, s.users.DeleteUser(ctx, user.ID), sql.ErrNoRows)
+
+		found, err := s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.Nil(t, found)
+
+		_, err = s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, session.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		following, err := s.users.IsFollowing(ctx, other.ID, user.ID)
+		require.NoError(t, err)
+		assert.False(t, following)
+
+		//lo de otros usuarios no se toca
+		_, err = s.workouts.GetWorkoutByID(ctx, int64(othersWorkout.ID))
+		assert.NoError(t, err)
+	})
+
+	t.Run("two factor", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		other := createTestUser(t, s, "other")
+		assert.False(t, user.HasTwoFactor())
+
+		//sin secreto no hay nada que activar
+		assert.ErrorIs(t, s.users.EnableTOTP(ctx, user.ID, nil), sql.ErrNoRows)
+
+		require.NoError(t, s.users.SetTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"))
+		saved, err := s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.Equal(t, "JBSWY3DPEHPK3PXP", saved.TOTPSecret)
+		assert.False(t, saved.HasTwoFactor())
+
+		codes := [][]byte{tokens.Hash("code-1"), tokens.Hash("code-2")}
+		require.NoError(t, s.users.EnableTOTP(ctx, user.ID, codes))
+
+		saved, err = s.users.GetUserByEmail(ctx, "joaquin@mail.com")
+		require.NoError(t, err)
+		assert.True(t, saved.HasTwoFactor())
+
+		//GetUserToken tambien trae las columnas de 2FA
+		session, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		saved, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, session.Plaintext)
+		require.NoError(t, err)
+		assert.True(t, saved.HasTwoFactor())
+		assert.Equal(t, "JBSWY3DPEHPK3PXP", saved.TOTPSecret)
+
+		//los codigos son de un solo uso y de un solo usuario
+		assert.ErrorIs(t, s.users.UseRecoveryCode(ctx, other.ID, tokens.Hash("code-1")), sql.ErrNoRows)
+		used := &AuditEvent{ActorID: &user.ID, Action: "user.recovery_code_use", TargetType: AuditTargetUser}
+		require.NoError(t, s.users.UseRecoveryCode(WithAuditEvent(ctx, used), user.ID, tokens.Hash("code-1")))
+		assert.NotZero(t, used.ID)
+		assert.Equal(t, int64(user.ID), used.TargetID)
+		assert.ErrorIs(t, s.users.UseRecoveryCode(ctx, user.ID, tokens.Hash("code-1")), sql.ErrNoRows)
+
+		//cada paso de TOTP se acepta una sola vez, y despues solo los posteriores
+		require.NoError(t, s.users.UseTOTPStep(ctx, user.ID, 100))
+		assert.ErrorIs(t, s.users.UseTOTPStep(ctx, user.ID, 100), sql.ErrNoRows)
+		assert.ErrorIs(t, s.users.UseTOTPStep(ctx, user.ID, 99), sql.ErrNoRows)
+		require.NoError(t, s.users.UseTOTPStep(ctx, user.ID, 101))
+		require.NoError(t, s.users.UseTOTPStep(ctx, other.ID, 100))
+		assert.ErrorIs(t, s.users.UseTOTPStep(ctx, 9999, 100), sql.ErrNoRows)
+
+		//activar de nuevo reemplaza los codigos
+		require.NoError(t, s.users.EnableTOTP(ctx, user.ID, [][]byte{tokens.Hash("code-3")}))
+		assert.ErrorIs(t, s.users.UseRecoveryCode(ctx, user.ID, tokens.Hash("code-2")), sql.ErrNoRows)
+
+		require.NoError(t, s.users.DisableTOTP(ctx, user.ID))
+		saved, err = s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.False(t, saved.HasTwoFactor())
+		assert.Empty(t, saved.TOTPSecret)
+		assert.ErrorIs(t, s.users.UseRecoveryCode(ctx, user.ID, tokens.Hash("code-3")), sql.ErrNoRows)
+
+		assert.ErrorIs(t, s.users.SetTOTPSecret(ctx, 9999, "JBSWY3DPEHPK3PXP"), sql.ErrNoRows)
+		assert.ErrorIs(t, s.users.DisableTOTP(ctx, 9999), sql.ErrNoRows)
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		other := createTestUser(t, s, "other")
+		now := time.Now()
+
+		newKey := func(userID int, name string, expiry *time.Time) (*APIKey, string) {
+			plaintext, err := tokens.GenerateAPIKey()
+			require.NoError(t, err)
+
+			key := &APIKey{UserID: userID, Name: name, Hash: tokens.Hash(plaintext), Scope: APIKeyScopeRead, Expiry: expiry}
+			require.NoError(t, s.apiKeys.CreateAPIKey(ctx, key))
+			assert.NotZero(t, key.ID)
+
+			return key, plaintext
+		}
+
+		key, plaintext := newKey(user.ID, "script", nil)
+		expired := now.Add(-time.Hour)
+		_, expiredPlaintext := newKey(user.ID, "old script", &expired)
+		othersKey, _ := newKey(other.ID, "other script", nil)
+
+		used, err := s.apiKeys.UseAPIKey(ctx, plaintext, now)
+		require.NoError(t, err)
+		assert.Equal(t, key.ID, used.ID)
+		assert.Equal(t, user.ID, used.UserID)
+		assert.Equal(t, APIKeyScopeRead, used.Scope)
+		require.NotNil(t, used.LastUsedAt)
+		assert.WithinDuration(t, now, *used.LastUsedAt, time.Second)
+
+		_, err = s.apiKeys.UseAPIKey(ctx, expiredPlaintext, now)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = s.apiKeys.UseAPIKey(ctx, "wapi_not-a-key", now)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//la lista incluye las vencidas, las mas nuevas primero
+		keys, err := s.apiKeys.GetAPIKeysForUser(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, "old script", keys[0].Name)
+		assert.Equal(t, "script", keys[1].Name)
+		assert.NotNil(t, keys[1].LastUsedAt)
+		assert.Empty(t, keys[1].Plaintext)
+
+		//no se pueden borrar las keys de otro usuario
+		assert.ErrorIs(t, s.apiKeys.DeleteAPIKey(ctx, user.ID, othersKey.ID), sql.ErrNoRows)
+		require.NoError(t, s.apiKeys.DeleteAPIKey(ctx, user.ID, key.ID))
+		assert.ErrorIs(t, s.apiKeys.DeleteAPIKey(ctx, user.ID, key.ID), sql.ErrNoRows)
+		_, err = s.apiKeys.UseAPIKey(ctx, plaintext, now)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		found, err := s.users.GetUserByID(ctx, other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "other", found.Username)
+
+		//las keys se van con el usuario
+		require.NoError(t, s.users.DeleteUser(ctx, other.ID))
+		keys, err = s.apiKeys.GetAPIKeysForUser(ctx, other.ID)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+
+		found, err = s.users.GetUserByID(ctx, other.ID)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+	})
+
+	t.Run("roles and suspension", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		coach := createTestUser(t, s, "Coach_Ana")
+		createTestUser(t, s, "other")
+		assert.Equal(t, RoleUser, user.Role)
+
+		require.NoError(t, s.users.UpdateRole(ctx, coach.ID, RoleCoach))
+		assert.ErrorIs(t, s.users.UpdateRole(ctx, 9999, RoleCoach), sql.ErrNoRows)
+
+		saved, err := s.users.GetUserByID(ctx, coach.ID)
+		require.NoError(t, err)
+		assert.Equal(t, RoleCoach, saved.Role)
+
+		session, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		require.NoError(t, s.users.SetSuspended(ctx, user.ID, true))
+		saved, err = s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		require.True(t, saved.IsSuspended())
+		suspendedAt := *saved.SuspendedAt
+
+		//suspender cierra las sesiones
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, session.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//suspender de nuevo no cambia la fecha
+		require.NoError(t, s.users.SetSuspended(ctx, user.ID, true))
+		saved, err = s.users.GetUserByUsername(ctx, "joaquin")
+		require.NoError(t, err)
+		assert.True(t, suspendedAt.Equal(*saved.SuspendedAt))
+
+		usernames := func(filter UserFilter) []string {
+			t.Helper()
+
+			users, err := s.users.ListUsers(ctx, filter)
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+			return names
+		}
+
+		yes, no := true, false
+		assert.Equal(t, []string{"joaquin", "Coach_Ana", "other"}, usernames(UserFilter{}))
+		assert.Equal(t, []string{"Coach_Ana"}, usernames(UserFilter{Query: "coach"}))
+		assert.Equal(t, []string{"Coach_Ana"}, usernames(UserFilter{Query: "ANA@MAIL"}))
+		assert.Equal(t, []string{"Coach_Ana"}, usernames(UserFilter{Role: RoleCoach}))
+		assert.Equal(t, []string{"joaquin"}, usernames(UserFilter{Suspended: &yes}))
+		assert.Equal(t, []string{"Coach_Ana", "other"}, usernames(UserFilter{Suspended: &no}))
+		assert.Equal(t, []string{"joaquin", "Coach_Ana"}, usernames(UserFilter{Limit: 2}))
+		assert.Equal(t, []string{"other"}, usernames(UserFilter{AfterID: coach.ID}))
+
+		require.NoError(t, s.users.SetSuspended(ctx, user.ID, false))
+		assert.Equal(t, []string{}, usernames(UserFilter{Suspended: &yes}))
+		assert.ErrorIs(t, s.users.SetSuspended(ctx, 9999, true), sql.ErrNoRows)
+	})
+
+	t.Run("login attempts", func(t *testing.T) {
+		s := newStores(t)
+		now := time.Now()
+		window := 15 * time.Minute
+
+		for i := 1; i <= 3; i++ {
+			failures, err := s.loginAttempts.RecordFailure(ctx, "user:joaquin", now, now.Add(-window))
+			require.NoError(t, err)
+			assert.Equal(t, i, failures)
+		}
+
+		//cada key cuenta por separado
+		failures, err := s.loginAttempts.RecordFailure(ctx, "ip:10.0.0.1", now, now.Add(-window))
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures)
+
+		lockedUntil, err := s.loginAttempts.LockedUntil(ctx, now, "user:joaquin", "ip:10.0.0.1")
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero())
+
+		require.NoError(t, s.loginAttempts.Lock(ctx, "user:joaquin", now.Add(time.Minute)))
+		require.NoError(t, s.loginAttempts.Lock(ctx, "ip:10.0.0.1", now.Add(time.Hour)))
+		assert.ErrorIs(t, s.loginAttempts.Lock(ctx, "user:unknown", now.Add(time.Hour)), sql.ErrNoRows)
+
+		//devuelve el bloqueo mas largo
+		lockedUntil, err = s.loginAttempts.LockedUntil(ctx, now, "user:joaquin", "ip:10.0.0.1")
+		require.NoError(t, err)
+		assert.WithinDuration(t, now.Add(time.Hour), lockedUntil, time.Second)
+
+		lockedUntil, err = s.loginAttempts.LockedUntil(ctx, now.Add(2*time.Minute), "user:joaquin")
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero(), "the lock already expired")
+
+		//si el ultimo fallo es viejo la cuenta arranca de nuevo
+		later := now.Add(time.Hour)
+		failures, err = s.loginAttempts.RecordFailure(ctx, "user:joaquin", later, later.Add(-window))
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures)
+
+		require.NoError(t, s.loginAttempts.Reset(ctx, "ip:10.0.0.1"))
+		lockedUntil, err = s.loginAttempts.LockedUntil(ctx, now, "ip:10.0.0.1")
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero())
+		failures, err = s.loginAttempts.RecordFailure(ctx, "ip:10.0.0.1", now, now.Add(-window))
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+		other := createTestUser(t, s, "other")
+
+		first, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		first.UserAgent = "phone"
+		require.NoError(t, s.tokens.Insert(ctx, first))
+		assert.NotZero(t, first.ID)
+
+		second, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		_, err = s.tokens.CreateNewToken(ctx, user.ID, -time.Minute, tokens.ScopeAuth)
+		require.NoError(t, err)
+		othersToken, err := s.tokens.CreateNewToken(ctx, other.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+
+		//los vencidos y los de otros usuarios no aparecen
+		sessions, err := s.tokens.GetTokensForUser(ctx, user.ID, tokens.ScopeAuth)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, second.ID, sessions[0].ID)
+		assert.Equal(t, "phone", sessions[1].UserAgent)
+		assert.Equal(t, first.Hash, sessions[1].Hash)
+
+		assert.ErrorIs(t, s.tokens.DeleteSession(ctx, user.ID, othersToken.ID), sql.ErrNoRows)
+		require.NoError(t, s.tokens.DeleteSession(ctx, user.ID, first.ID))
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, first.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		assert.ErrorIs(t, s.tokens.DeleteSessionByToken(ctx, "other-scope", second.Plaintext), sql.ErrNoRows)
+		require.NoError(t, s.tokens.DeleteSessionByToken(ctx, tokens.ScopeAuth, second.Plaintext))
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, second.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//el vencido sigue en la tabla hasta que se borran todos
+		require.NoError(t, s.tokens.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeAuth))
+		assert.ErrorIs(t, s.tokens.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeAuth), sql.ErrNoRows)
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, othersToken.Plaintext)
+		assert.NoError(t, err)
+	})
+
+	t.Run("refresh tokens", func(t *testing.T) {
+		s := newStores(t)
+		user := createTestUser(t, s, "joaquin")
+
+		//newSession genera el token de auth y el refresh de una sesion, sin guardarlos
+		newSession := func(userID int, familyID string) (*tokens.Token, *tokens.Token) {
+			auth, err := tokens.GenerateToken(userID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+			refresh, err := tokens.GenerateToken(userID, 24*time.Hour, tokens.ScopeRefresh)
+			require.NoError(t, err)
+			auth.FamilyID, refresh.FamilyID = familyID, familyID
+			return auth, refresh
+		}
+
+		auth, refresh := newSession(user.ID, "family")
+		require.NoError(t, s.tokens.Insert(ctx, auth, refresh))
+
+		otherAuth, otherRefresh := newSession(user.ID, "other family")
+		require.NoError(t, s.tokens.Insert(ctx, otherAuth, otherRefresh))
+
+		newAuth, newRefresh := newSession(0, "")
+		require.NoError(t, s.tokens.RotateRefreshToken(ctx, refresh.Plaintext, newAuth, newRefresh))
+		assert.Equal(t, user.ID, newAuth.UserID)
+		assert.Equal(t, "family", newRefresh.FamilyID)
+
+		_, err := s.users.GetUserToken(ctx, tokens.ScopeAuth, newAuth.Plaintext)
+		require.NoError(t, err)
+		//el token de auth anterior de la sesion deja de servir
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, auth.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		//un token de auth no sirve como refresh
+		a, r := newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, newAuth.Plaintext, a, r), sql.ErrNoRows)
+		a, r = newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, "unknown", a, r), sql.ErrNoRows)
+
+		//reusar el refresh viejo revoca toda la familia, pero no las otras sesiones
+		a, r = newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, refresh.Plaintext, a, r), ErrRefreshTokenReused)
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, newAuth.Plaintext)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		a, r = newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, newRefresh.Plaintext, a, r), sql.ErrNoRows)
+
+		_, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, otherAuth.Plaintext)
+		assert.NoError(t, err)
+
+		//cerrar la sesion tambien borra su refresh token
+		require.NoError(t, s.tokens.DeleteSessionByToken(ctx, tokens.ScopeAuth, otherAuth.Plaintext))
+		a, r = newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, otherRefresh.Plaintext, a, r), sql.ErrNoRows)
+
+		expiredAuth, expiredRefresh := newSession(user.ID, "expired")
+		expiredRefresh.Expiry = time.Now().UTC().Add(-time.Minute)
+		require.NoError(t, s.tokens.Insert(ctx, expiredAuth, expiredRefresh))
+		a, r = newSession(0, "")
+		assert.ErrorIs(t, s.tokens.RotateRefreshToken(ctx, expiredRefresh.Plaintext, a, r), sql.ErrNoRows)
+	})
+}
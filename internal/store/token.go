@@ -1,74 +1,294 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/joaquinbian/workout-api-go/internal/tokens"
 )
 
+// ErrRefreshTokenReused se devuelve cuando alguien usa un refresh token que ya se roto.
+// Significa que el token se filtro, asi que se revoca toda la sesion
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
 type PostgresTokenStore struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
+func NewPostgresTokenStore(db *sql.DB, queryTimeout time.Duration) *PostgresTokenStore {
 	return &PostgresTokenStore{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout,
 	}
 }
 
+// Una sesion es un token de autenticacion y su refresh token, que comparten FamilyID.
+// Cerrar una sesion borra todos los tokens de la familia
 type TokenStore interface {
-	Insert(token *tokens.Token) error
-	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
-	DeleteAllTokensForUser(userID int, scope string) error
+	Insert(ctx context.Context, toInsert ...*tokens.Token) error
+	CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error)
+	GetTokensForUser(ctx context.Context, userID int, scope string) ([]*tokens.Token, error)
+	RotateRefreshToken(ctx context.Context, plaintext string, auth, refresh *tokens.Token) error
+	DeleteSession(ctx context.Context, userID int, tokenID int) error
+	DeleteSessionByToken(ctx context.Context, scope string, plaintext string) error
+	DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error
 }
 
-func (ts *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+func (ts *PostgresTokenStore) CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
 	token, err := tokens.GenerateToken(userID, ttl, scope)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = ts.Insert(token)
+	err = ts.Insert(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
 
 	return token, nil
 }
 
-func (ts *PostgresTokenStore) Insert(token *tokens.Token) error {
+// Insert guarda todos los tokens en una transaccion, por ej. el de auth y el refresh de un login
+func (ts *PostgresTokenStore) Insert(ctx context.Context, toInsert ...*tokens.Token) error {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
 
-	tx, err := ts.db.Begin()
+	tx, err := ts.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return err
 	}
 
-	defer tx.Commit()
-
-	query := `INSERT INTO tokens(hash, user_id, expiry, scope) VALUES($1, $2, $3, $4);`
+	defer tx.Rollback()
 
-	_, err = tx.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	err = insertTokens(ctx, tx, toInsert...)
 
 	if err != nil {
 		return err
 	}
 
+	if len(toInsert) > 0 {
+		err = recordAudit(ctx, tx, int64(toInsert[0].UserID))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertTokens(ctx context.Context, tx *sql.Tx, toInsert ...*tokens.Token) error {
+	query := `INSERT INTO tokens(hash, user_id, expiry, scope, created_at, user_agent, family_id) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	for _, token := range toInsert {
+		familyID := sql.NullString{String: token.FamilyID, Valid: token.FamilyID != ""}
+
+		err := tx.QueryRowContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.CreatedAt, token.UserAgent, familyID).Scan(&token.ID)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (ts *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
-	tx, err := ts.db.Begin()
+// GetTokensForUser devuelve los tokens sin vencer del usuario, los mas nuevos primero.
+// Para el scope de autenticacion son sus sesiones abiertas
+func (ts *PostgresTokenStore) GetTokensForUser(ctx context.Context, userID int, scope string) ([]*tokens.Token, error) {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
+	query := `SELECT id, hash, user_id, scope, expiry, created_at, user_agent
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > $3
+	ORDER BY created_at DESC, id DESC`
+
+	rows, err := ts.db.QueryContext(ctx, query, userID, scope, time.Now().UTC())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []*tokens.Token{}
+
+	for rows.Next() {
+		token := &tokens.Token{}
+
+		err := rows.Scan(&token.ID, &token.Hash, &token.UserID, &token.Scope, &token.Expiry, &token.CreatedAt, &token.UserAgent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, token)
+	}
+
+	return result, rows.Err()
+}
+
+// RotateRefreshToken marca como usado el refresh token plaintext y guarda en su lugar auth y refresh,
+// completandoles UserID y FamilyID con los del token usado. Los tokens de auth anteriores de la sesion se borran.
+// Si el token no existe o vencio devuelve sql.ErrNoRows. Si ya se habia usado revoca la sesion
+// entera y devuelve ErrRefreshTokenReused
+func (ts *PostgresTokenStore) RotateRefreshToken(ctx context.Context, plaintext string, auth, refresh *tokens.Token) error {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
+	tx, err := ts.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	hash := tokens.Hash(plaintext)
+	now := time.Now().UTC()
+
+	//el UPDATE es atomico: si dos requests usan el mismo token a la vez solo una lo marca como usado
+	query := `UPDATE tokens SET used_at = $1
+	WHERE hash = $2 AND scope = $3 AND used_at IS NULL AND expiry > $1
+	RETURNING user_id, family_id`
+
+	var userID int
+	var familyID sql.NullString
+
+	err = tx.QueryRowContext(ctx, query, now, hash, tokens.ScopeRefresh).Scan(&userID, &familyID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ts.checkRefreshTokenReuse(ctx, tx, hash)
+	}
 
 	if err != nil {
 		return err
 	}
 
-	defer tx.Commit()
-	query := `DELETE FROM hash WHERE user_id = $1 AND scope = $2;`
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1 AND scope = $2`, familyID, tokens.ScopeAuth)
+
+	if err != nil {
+		return err
+	}
+
+	for _, token := range []*tokens.Token{auth, refresh} {
+		token.UserID = userID
+		token.FamilyID = familyID.String
+	}
+
+	err = insertTokens(ctx, tx, auth, refresh)
 
-	res, err := tx.Exec(query, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(userID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkRefreshTokenReuse se llama cuando no se pudo rotar el token: si existe y ya estaba
+// usado borra su familia (y commitea, aunque devuelva error)
+func (ts *PostgresTokenStore) checkRefreshTokenReuse(ctx context.Context, tx *sql.Tx, hash []byte) error {
+	var familyID sql.NullString
+	var used bool
+
+	query := `SELECT family_id, used_at IS NOT NULL FROM tokens WHERE hash = $1 AND scope = $2`
+
+	err := tx.QueryRowContext(ctx, query, hash, tokens.ScopeRefresh).Scan(&familyID, &used)
+
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		//existe pero esta vencido
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// DeleteSession borra el token tokenID y el resto de su sesion, solo si es de userID.
+// Si no devuelve sql.ErrNoRows
+func (ts *PostgresTokenStore) DeleteSession(ctx context.Context, userID int, tokenID int) error {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM tokens
+	WHERE user_id = $1 AND (id = $2 OR family_id = (SELECT family_id FROM tokens WHERE id = $2 AND user_id = $1))`
+
+	return audited(ctx, ts.db, int64(userID), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, userID, tokenID)
+	})
+}
+
+// DeleteSessionByToken borra el token plaintext y el resto de su sesion (logout)
+func (ts *PostgresTokenStore) DeleteSessionByToken(ctx context.Context, scope string, plaintext string) error {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM tokens
+	WHERE (hash = $1 AND scope = $2) OR family_id = (SELECT family_id FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	//el usuario del token no se conoce aca, el evento tiene que traer su TargetID
+	return audited(ctx, ts.db, 0, func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, tokens.Hash(plaintext), scope)
+	})
+}
+
+func (ts *PostgresTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error {
+	ctx, cancel := withTimeout(ctx, ts.queryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`
+
+	return audited(ctx, ts.db, int64(userID), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, userID, scope)
+	})
+}
+
+// dbExecutor lo cumplen *sql.DB y *sql.Tx, para usar las mismas queries dentro y fuera de una transaccion
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// execAffectingRows corre un UPDATE/DELETE y devuelve sql.ErrNoRows si no toco ninguna fila
+func execAffectingRows(ctx context.Context, db dbExecutor, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+
+	if err != nil {
+		return err
+	}
 
-	rowsAffected, err := res.RowsAffected()
+	rowsAffected, err := result.RowsAffected()
 
 	if err != nil {
 		return err
@@ -1,21 +1,42 @@
 package store
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/joaquinbian/workout-api-go/internal/tokens"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// CreateUser y UpdateUser devuelven estos errores cuando el username o el email ya los usa otro usuario.
+// Los dos cumplen errors.Is con ErrUniqueViolation
+var (
+	ErrDuplicateUsername error = &constraintViolation{message: "username already taken", kind: ErrUniqueViolation}
+	ErrDuplicateEmail    error = &constraintViolation{message: "email already in use", kind: ErrUniqueViolation}
+)
+
 type password struct {
 	text *string
 	hash []byte
 }
 
+// bcryptCost es el costo con el que Set hashea las passwords, se configura al arrancar la app
+var bcryptCost = 12
+
+// SetBcryptCost cambia el costo de los hashes nuevos. Los hashes ya guardados siguen
+// validando porque bcrypt guarda el costo dentro del hash
+func SetBcryptCost(cost int) {
+	bcryptCost = cost
+}
+
 func (p *password) Set(plaintextPass string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPass), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPass), bcryptCost)
 	if err != nil {
 		return err
 	}
@@ -25,6 +46,29 @@ func (p *password) Set(plaintextPass string) error {
 	return nil
 }
 
+// dummyHash es un hash de una password cualquiera, con el costo actual, para SimulatePasswordCheck
+var dummyHash struct {
+	mu   sync.Mutex
+	hash []byte
+	cost int
+}
+
+// SimulatePasswordCheck hace una comparacion de bcrypt que siempre falla. Se usa cuando el usuario
+// no existe, asi el login tarda lo mismo que con una password incorrecta y no sirve para saber que usernames existen
+func SimulatePasswordCheck(plaintextPass string) {
+	dummyHash.mu.Lock()
+	if dummyHash.hash == nil || dummyHash.cost != bcryptCost {
+		hash, err := bcrypt.GenerateFromPassword([]byte("not the password of anyone"), bcryptCost)
+		if err == nil {
+			dummyHash.hash, dummyHash.cost = hash, bcryptCost
+		}
+	}
+	hash := dummyHash.hash
+	dummyHash.mu.Unlock()
+
+	bcrypt.CompareHashAndPassword(hash, []byte(plaintextPass))
+}
+
 func (p *password) Matches(plaintextPass string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPass))
 
@@ -47,34 +91,123 @@ type User struct {
 	Bio          string    `json:"bio"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
+	//nil hasta que el usuario confirma su email con el token de activacion
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	//secreto de TOTP, "" si nunca empezo a activar 2FA. Solo pide el codigo si TOTPEnabledAt no es nil
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"twoFactorEnabledAt"`
+	Role          string     `json:"role"`
+	//nil si la cuenta esta activa. Las cuentas suspendidas no pueden loguearse ni usar sus tokens
+	SuspendedAt *time.Time `json:"suspendedAt"`
 }
 
+// roles de los usuarios
+const (
+	RoleUser  = "user"
+	RoleCoach = "coach"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleUser, RoleCoach, RoleAdmin}
+
 var AnonymousUser = &User{}
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) IsVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// HasRole dice si el usuario tiene role. Los admins tienen todos los roles
+func (u *User) HasRole(role string) bool {
+	return u.Role == role || u.Role == RoleAdmin
+}
+
+// userColumns son las columnas que lee scanUser, con el alias u de la tabla users
+const userColumns = `u.id, u.username, u.email, u.password_hash, u.bio, u.created_at, u.updated_at, u.email_verified_at, u.totp_secret, u.totp_enabled_at, u.role, u.suspended_at`
+
+// scanUser lee una fila con userColumns, de un *sql.Row o de un *sql.Rows
+func scanUser(row interface{ Scan(dest ...any) error }) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
+
+	var totpSecret sql.NullString
+
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.EmailVerifiedAt,
+		&totpSecret,
+		&user.TOTPEnabledAt,
+		&user.Role,
+		&user.SuspendedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = totpSecret.String
+
+	return user, nil
+}
+
 type PostgresUserStore struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+func NewPostgresUserStore(db *sql.DB, queryTimeout time.Duration) *PostgresUserStore {
 	return &PostgresUserStore{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout,
 	}
 }
 
 type UserStore interface {
-	CreateUser(*User) error
-	GetUserByUsername(username string) (*User, error)
-	UpdateUser(*User) error
-	GetUserToken(scope string, plainTextToken string) (*User, error)
+	CreateUser(ctx context.Context, u *User) error
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, u *User) error
+	UpdatePassword(ctx context.Context, u *User, keepSession string) error
+	VerifyEmail(ctx context.Context, u *User) error
+	DeleteUser(ctx context.Context, id int) error
+	ListUsers(ctx context.Context, filter UserFilter) ([]*User, error)
+	UpdateRole(ctx context.Context, id int, role string) error
+	SetSuspended(ctx context.Context, id int, suspended bool) error
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	GetUserToken(ctx context.Context, scope string, plainTextToken string) (*User, error)
+	FollowUser(ctx context.Context, followerID, followeeID int) error
+	UnfollowUser(ctx context.Context, followerID, followeeID int) error
+	IsFollowing(ctx context.Context, followerID, followeeID int) (bool, error)
 }
 
-func (s *PostgresUserStore) CreateUser(u *User) error {
+func (s *PostgresUserStore) CreateUser(ctx context.Context, u *User) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return err
@@ -82,9 +215,19 @@ func (s *PostgresUserStore) CreateUser(u *User) error {
 
 	defer tx.Rollback()
 
-	query := `INSERT INTO USERS (username, email, password_hash, bio) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
+	query := `INSERT INTO USERS (username, email, password_hash, bio, role) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at, email_verified_at`
+
+	err = tx.QueryRowContext(ctx, query, u.Username, u.Email, u.PasswordHash.hash, u.Bio, u.Role).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.EmailVerifiedAt)
+
+	if err != nil {
+		return duplicateUserError(err)
+	}
 
-	err = tx.QueryRow(query, u.Username, u.Email, u.PasswordHash.hash, u.Bio).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	err = recordAudit(ctx, tx, int64(u.ID))
 
 	if err != nil {
 		return err
@@ -98,22 +241,50 @@ func (s *PostgresUserStore) CreateUser(u *User) error {
 	return nil
 }
 
-func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
+// GetUserByID devuelve nil, nil si el usuario no existe, igual que GetUserByUsername
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
 
-	user := &User{
-		PasswordHash: password{},
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
 
-	query := `SELECT id, username, email, password_hash, bio, created_at, updated_at FROM users WHERE username = $1`
-	err := s.db.QueryRow(query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.username = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, username))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail igual que GetUserByUsername devuelve nil, nil si no hay usuario con ese email
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.email = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -126,9 +297,14 @@ func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
-func (s *PostgresUserStore) UpdateUser(u *User) error {
+// UpdateUser guarda username, email y bio. Si cambia el email, el usuario tiene que volver
+// a verificarlo: se limpia email_verified_at y se borran los tokens de activacion pendientes,
+// que eran para el email anterior
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, u *User) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return err
@@ -136,13 +312,179 @@ func (s *PostgresUserStore) UpdateUser(u *User) error {
 
 	defer tx.Rollback()
 
+	var currentEmail string
+
+	err = tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1`, u.ID).Scan(&currentEmail)
+
+	if err != nil {
+		return err
+	}
+
 	query := `
 	UPDATE USERS 
-	SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP
-	WHERE id = $4;
+	SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP,
+		email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
+	WHERE id = $4
+	RETURNING updated_at, email_verified_at
 	`
-	//ejecuta la query sin devolver filas
-	result, err := tx.Exec(query, u.Username, u.Email, u.Bio, u.ID)
+
+	err = tx.QueryRowContext(ctx, query, u.Username, u.Email, u.Bio, u.ID).Scan(&u.UpdatedAt, &u.EmailVerifiedAt)
+
+	if err != nil {
+		return duplicateUserError(err)
+	}
+
+	if currentEmail != u.Email {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, u.ID, tokens.ScopeActivation)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordAudit(ctx, tx, int64(u.ID))
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+	return nil
+
+}
+
+// DeleteUser borra el usuario. Sus workouts, tokens, follows y coachings se borran en cascada
+// (ON DELETE CASCADE en las migraciones). Devuelve sql.ErrNoRows si no existe
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return audited(ctx, s.db, int64(id), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, `DELETE FROM users WHERE id = $1`, id)
+	})
+}
+
+const (
+	DefaultUsersLimit = 50
+	MaxUsersLimit     = 200
+)
+
+// UserFilter son los parametros del listado de usuarios de los admins. La paginacion es por id:
+// AfterID es el id del ultimo usuario de la pagina anterior
+type UserFilter struct {
+	//busca en el username y el email, sin distinguir mayusculas
+	Query     string
+	Role      string
+	Suspended *bool
+	AfterID   int
+	Limit     int
+}
+
+func (f *UserFilter) normalize() {
+	if f.Limit <= 0 {
+		f.Limit = DefaultUsersLimit
+	}
+	if f.Limit > MaxUsersLimit {
+		f.Limit = MaxUsersLimit
+	}
+}
+
+// ListUsers devuelve los usuarios que cumplen filter, ordenados por id
+func (s *PostgresUserStore) ListUsers(ctx context.Context, filter UserFilter) ([]*User, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	filter.normalize()
+
+	conditions := []string{"u.id > $1"}
+	args := []any{filter.AfterID}
+
+	if filter.Query != "" {
+		args = append(args, "%"+strings.ToLower(filter.Query)+"%")
+		n := len(args)
+		conditions = append(conditions, fmt.Sprintf("(LOWER(u.username) LIKE $%d OR LOWER(u.email) LIKE $%d)", n, n))
+	}
+
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conditions = append(conditions, fmt.Sprintf("u.role = $%d", len(args)))
+	}
+
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			conditions = append(conditions, "u.suspended_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "u.suspended_at IS NULL")
+		}
+	}
+
+	args = append(args, filter.Limit)
+	query := `SELECT ` + userColumns + ` FROM users u WHERE ` + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY u.id LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []*User{}
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, user)
+	}
+
+	return result, rows.Err()
+}
+
+// UpdateRole cambia el rol del usuario. Devuelve sql.ErrNoRows si no existe
+func (s *PostgresUserStore) UpdateRole(ctx context.Context, id int, role string) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+
+	return audited(ctx, s.db, int64(id), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, role, id)
+	})
+}
+
+// SetSuspended suspende o reactiva la cuenta. Al suspenderla se borran todos sus tokens,
+// asi se cierran sus sesiones. Devuelve sql.ErrNoRows si no existe
+func (s *PostgresUserStore) SetSuspended(ctx context.Context, id int, suspended bool) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var result sql.Result
+
+	if suspended {
+		//COALESCE para no pisar la fecha si ya estaba suspendida
+		query := `UPDATE users SET suspended_at = COALESCE(suspended_at, $1), updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+		result, err = tx.ExecContext(ctx, query, dbTime(time.Now()), id)
+	} else {
+		query := `UPDATE users SET suspended_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+		result, err = tx.ExecContext(ctx, query, id)
+	}
 
 	if err != nil {
 		return err
@@ -157,43 +499,368 @@ func (s *PostgresUserStore) UpdateUser(u *User) error {
 	if affectedRows == 0 {
 		return sql.ErrNoRows
 	}
-	err = tx.Commit()
+
+	if suspended {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordAudit(ctx, tx, int64(id))
 
 	if err != nil {
 		return err
 	}
-	return nil
 
+	return tx.Commit()
+}
+
+// duplicateUserError traduce las violaciones de UNIQUE de la tabla users (de postgres o sqlite)
+// a ErrDuplicateUsername o ErrDuplicateEmail. Cualquier otro error pasa por constraintError
+func duplicateUserError(err error) error {
+	var constraint string
+
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		constraint = pgErr.ConstraintName
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: users."):
+		constraint = err.Error()
+	default:
+		return constraintError(err)
+	}
+
+	switch {
+	case strings.Contains(constraint, "username"):
+		return ErrDuplicateUsername
+	case strings.Contains(constraint, "email"):
+		return ErrDuplicateEmail
+	}
+
+	return constraintError(err)
 }
 
-func (us *PostgresUserStore) GetUserToken(scope string, plainText string) (*User, error) {
+// VerifyEmail marca el email de u como verificado y borra sus tokens de activacion
+func (s *PostgresUserStore) VerifyEmail(ctx context.Context, u *User) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
 
-	tokenHash := sha256.Sum256([]byte(plainText))
+	tx, err := s.db.BeginTx(ctx, nil)
 
-	var user = &User{
-		PasswordHash: password{},
+	if err != nil {
+		return err
 	}
-	query := `SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.created_at, u.updated_at
+
+	defer tx.Rollback()
+
+	query := `UPDATE users SET email_verified_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING email_verified_at`
+
+	err = tx.QueryRowContext(ctx, query, time.Now().UTC(), u.ID).Scan(&u.EmailVerifiedAt)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, u.ID, tokens.ScopeActivation)
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(u.ID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdatePassword guarda el hash de u.PasswordHash y borra todos los tokens del usuario
+// (sesiones y tokens de reset), asi quien tuviera la password vieja queda afuera.
+// Si keepSession no esta vacio, la sesion de ese token de auth (y su refresh token) se mantiene.
+// Devuelve sql.ErrNoRows si el usuario no existe
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, u *User, keepSession string) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+
+	result, err := tx.ExecContext(ctx, query, u.PasswordHash.hash, u.ID)
+
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affectedRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	if keepSession == "" {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, u.ID)
+	} else {
+		//el COALESCE es para los tokens sin familia: NULL IN (...) es NULL y NOT NULL no borraria nada
+		query = `DELETE FROM tokens
+		WHERE user_id = $1 AND NOT (
+			hash = $2 OR COALESCE(family_id IN (SELECT family_id FROM tokens WHERE hash = $2 AND scope = $3 AND family_id IS NOT NULL), FALSE)
+		)`
+		_, err = tx.ExecContext(ctx, query, u.ID, tokens.Hash(keepSession), tokens.ScopeAuth)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(u.ID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetTOTPSecret guarda el secreto de una activacion de 2FA pendiente. No la activa, eso lo hace
+// EnableTOTP cuando el usuario confirma con un codigo. Devuelve sql.ErrNoRows si el usuario no existe
+func (s *PostgresUserStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE users SET totp_secret = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+
+	return audited(ctx, s.db, int64(userID), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, secret, userID)
+	})
+}
+
+// EnableTOTP activa el 2FA con el secreto guardado y reemplaza los codigos de recuperacion
+// del usuario por los de recoveryCodeHashes
+func (s *PostgresUserStore) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_enabled_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND totp_secret IS NOT NULL`
+
+	result, err := tx.ExecContext(ctx, query, time.Now().UTC(), userID)
+
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affectedRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordAudit(ctx, tx, int64(userID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP desactiva el 2FA, borra el secreto y los codigos de recuperacion que queden
+func (s *PostgresUserStore) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, userID)
+
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affectedRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(userID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode borra el codigo de recuperacion del usuario con ese hash, asi no se puede volver
+// a usar. Devuelve sql.ErrNoRows si no existe (o ya se uso)
+func (s *PostgresUserStore) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM recovery_codes WHERE hash = $1 AND user_id = $2`
+
+	return audited(ctx, s.db, int64(userID), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, codeHash, userID)
+	})
+}
+
+// UseTOTPStep guarda step como el paso del ultimo codigo TOTP aceptado. Devuelve sql.ErrNoRows si
+// el usuario ya uso un codigo de ese paso o de uno posterior, o si no existe
+func (s *PostgresUserStore) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	//la condicion va en el UPDATE, asi dos requests con el mismo codigo no pasan las dos
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`
+
+	return execAffectingRows(ctx, s.db, query, step, userID)
+}
+
+func (us *PostgresUserStore) GetUserToken(ctx context.Context, scope string, plainText string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, us.queryTimeout)
+	defer cancel()
+
+	tokenHash := tokens.Hash(plainText)
+
+	query := `SELECT ` + userColumns + `
 	 FROM users u 
 	 INNER JOIN tokens t ON u.id = t.user_id 
-	 WHERE t.hash LIKE $1 AND t.scope LIKE $2 AND t.expiry > $3`
+	 WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3`
 
-	err := us.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	if err == sql.ErrNoRows {
+	//las fechas siempre van en UTC para que sqlite (que las guarda como texto) las compare bien
+	user, err := scanUser(us.db.QueryRowContext(ctx, query, tokenHash, scope, time.Now().UTC()))
+	if err != nil {
 		return nil, err
 	}
 
+	return user, nil
+}
+
+// FollowUser devuelve sql.ErrNoRows si el usuario a seguir no existe. Seguir dos veces
+// al mismo usuario no es un error
+func (s *PostgresUserStore) FollowUser(ctx context.Context, followerID, followeeID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return user, nil
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, followeeID).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	query := `INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, followerID, followeeID)
+
+	if err != nil {
+		return constraintError(err)
+	}
+
+	err = recordAudit(ctx, tx, int64(followeeID))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresUserStore) UnfollowUser(ctx context.Context, followerID, followeeID int) error {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`
+
+	return audited(ctx, s.db, int64(followeeID), func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, followerID, followeeID)
+	})
+}
+
+func (s *PostgresUserStore) IsFollowing(ctx context.Context, followerID, followeeID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	query := `SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)`
+
+	var following bool
+
+	err := s.db.QueryRowContext(ctx, query, followerID, followeeID).Scan(&following)
+
+	if err != nil {
+		return false, err
+	}
+
+	return following, nil
 }
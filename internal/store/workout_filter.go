@@ -0,0 +1,128 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// campos por los que se puede ordenar GET /workouts
+const (
+	WorkoutSortCreatedAt = "created_at"
+	WorkoutSortDuration  = "duration"
+	WorkoutSortCalories  = "calories"
+)
+
+const (
+	DefaultWorkoutsLimit = 20
+	MaxWorkoutsLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// WorkoutFilter son los parametros de un listado de workouts.
+// La paginacion es por keyset: Cursor es el NextCursor de la pagina anterior
+// y solo es valido con el mismo SortBy/Descending con el que se genero
+type WorkoutFilter struct {
+	//usuario que pide el listado, solo se devuelven los workouts que puede ver
+	ViewerID int
+	//si no es 0, solo los workouts de ese usuario (por ej. un coach viendo el historial de un atleta)
+	UserID int
+
+	Limit      int
+	Cursor     string
+	SortBy     string
+	Descending bool
+
+	Title       string
+	MinDuration *int
+	MaxDuration *int
+	MinCalories *int
+	MaxCalories *int
+}
+
+type WorkoutPage struct {
+	Workouts []*Workout
+	//vacio si no hay mas paginas
+	NextCursor string
+}
+
+// normalize completa los valores por defecto, asi todos los stores se comportan igual
+func (f *WorkoutFilter) normalize() {
+	if f.Limit <= 0 {
+		f.Limit = DefaultWorkoutsLimit
+	}
+	if f.Limit > MaxWorkoutsLimit {
+		f.Limit = MaxWorkoutsLimit
+	}
+	if f.SortBy == "" {
+		f.SortBy = WorkoutSortCreatedAt
+		f.Descending = true
+	}
+}
+
+// workoutCursor es lo que va codificado (base64 de un json) en el cursor que ve el cliente:
+// el valor de la columna de orden y el id del ultimo workout de la pagina
+type workoutCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         int    `json:"id"`
+}
+
+func (f WorkoutFilter) cursorAfter(w *Workout) string {
+	c := workoutCursor{SortBy: f.SortBy, Descending: f.Descending, ID: w.ID}
+
+	switch f.SortBy {
+	case WorkoutSortDuration:
+		c.Value = strconv.Itoa(w.DurationMinutes)
+	case WorkoutSortCalories:
+		c.Value = strconv.Itoa(w.CaloriesBurned)
+	default:
+		c.Value = w.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor devuelve nil si el filtro no tiene cursor
+func (f WorkoutFilter) decodeCursor() (*workoutCursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c workoutCursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.SortBy != f.SortBy || c.Descending != f.Descending {
+		return nil, ErrInvalidCursor
+	}
+
+	_, err = c.value()
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// value devuelve el valor del cursor con el tipo de la columna de orden
+func (c *workoutCursor) value() (any, error) {
+	if c.SortBy == WorkoutSortCreatedAt {
+		return time.Parse(time.RFC3339Nano, c.Value)
+	}
+	return strconv.Atoi(c.Value)
+}
+
+// escapeLike escapa los comodines de LIKE para buscar el texto tal cual (se usa con ESCAPE '\')
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,9 +1,88 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joaquinbian/workout-api-go/internal/validator"
+)
+
+// quien puede ver un workout ademas de su dueño
+const (
+	VisibilityPrivate    = "private"
+	VisibilityFollowers  = "followers"
+	VisibilityPublicLink = "public_link"
 )
 
+func ValidVisibility(v string) bool {
+	return v == VisibilityPrivate || v == VisibilityFollowers || v == VisibilityPublicLink
+}
+
+// limites de los workouts, los largos son los de las columnas VARCHAR(255) y el peso el de DECIMAL(5, 2)
+const (
+	MaxWorkoutTitleLength     = 255
+	MaxWorkoutDurationMinutes = 24 * 60
+	MaxWorkoutCalories        = 100_000
+	MaxExerciseNameLength     = 255
+	MaxEntrySets              = 100
+	MaxEntryReps              = 10_000
+	MaxEntryDurationSeconds   = 24 * 60 * 60
+	MaxEntryWeight            = 999.99
+)
+
+// ValidateWorkout chequea el workout tal como se va a guardar. Es la misma regla para crear,
+// modificar o importar workouts, y cubre los checks de la db para que no lleguen a ser un error del store
+func ValidateWorkout(v *validator.Validator, w *Workout) {
+	v.Check(validator.NotBlank(w.Title), "title", "is required")
+	v.Check(validator.MaxChars(w.Title, MaxWorkoutTitleLength), "title", fmt.Sprintf("must be at most %d characters long", MaxWorkoutTitleLength))
+	v.Check(validator.Between(w.DurationMinutes, 0, MaxWorkoutDurationMinutes), "duration_minutes", fmt.Sprintf("must be between 0 and %d", MaxWorkoutDurationMinutes))
+	v.Check(validator.Between(w.CaloriesBurned, 0, MaxWorkoutCalories), "calories_burned", fmt.Sprintf("must be between 0 and %d", MaxWorkoutCalories))
+	v.Check(ValidVisibility(w.Visibility), "visibility", "must be private, followers or public_link")
+
+	for i := range w.Entries {
+		ValidateWorkoutEntry(v, validator.Field("entries", i, ""), &w.Entries[i])
+	}
+}
+
+// ValidateWorkoutEntry chequea una entry. prefix es el campo de la entry en el payload, por ej "entries[2]",
+// o "" si el payload es solo la entry. Los errores de la entry completa van a prefix, o a "entry"
+func ValidateWorkoutEntry(v *validator.Validator, prefix string, e *WorkoutEntry) {
+	field := func(name string) string {
+		switch {
+		case prefix == "" && name == "":
+			return "entry"
+		case prefix == "":
+			return name
+		case name == "":
+			return prefix
+		}
+		return prefix + "." + name
+	}
+
+	v.Check(validator.NotBlank(e.ExerciseName), field("exercise_name"), "is required")
+	v.Check(validator.MaxChars(e.ExerciseName, MaxExerciseNameLength), field("exercise_name"), fmt.Sprintf("must be at most %d characters long", MaxExerciseNameLength))
+	v.Check(validator.Between(e.Sets, 1, MaxEntrySets), field("sets"), fmt.Sprintf("must be between 1 and %d", MaxEntrySets))
+	v.Check(e.OrderIndex >= 0, field("order_index"), "must not be negative")
+
+	//el mismo check que valid_workout_entry: o reps o duration_seconds, nunca los dos
+	v.Check(e.Reps != nil || e.DurationSeconds != nil, field(""), "must have reps or duration_seconds")
+	v.Check(e.Reps == nil || e.DurationSeconds == nil, field(""), "must have reps or duration_seconds, not both")
+
+	if e.Reps != nil {
+		v.Check(validator.Between(*e.Reps, 1, MaxEntryReps), field("reps"), fmt.Sprintf("must be between 1 and %d", MaxEntryReps))
+	}
+	if e.DurationSeconds != nil {
+		v.Check(validator.Between(*e.DurationSeconds, 1, MaxEntryDurationSeconds), field("duration_seconds"), fmt.Sprintf("must be between 1 and %d", MaxEntryDurationSeconds))
+	}
+	if e.Weight != nil {
+		v.Check(validator.Between(*e.Weight, 0, MaxEntryWeight), field("weight"), fmt.Sprintf("must be between 0 and %v", MaxEntryWeight))
+	}
+}
+
 type Workout struct {
 	ID              int            `json:"id"`
 	UserID          int            `json:"user_id"`
@@ -11,6 +90,11 @@ type Workout struct {
 	Description     string         `json:"description"`
 	DurationMinutes int            `json:"duration_minutes"`
 	CaloriesBurned  int            `json:"calories_burned"`
+	Visibility      string         `json:"visibility"`
+	ShareToken      *string        `json:"share_token,omitempty"` //solo lo ve el dueño
+	CreatedAt       time.Time      `json:"created_at"`
+	Version         int            `json:"version"`              //sube en cada cambio, ver ErrEditConflict
+	DeletedAt       *time.Time     `json:"deleted_at,omitempty"` //solo en los workouts de la papelera
 	Entries         []WorkoutEntry `json:"entries"`
 }
 
@@ -25,28 +109,95 @@ type WorkoutEntry struct {
 	OrderIndex      int      `json:"order_index"`
 }
 
+// WorkoutNote es un comentario sobre un workout, del dueño o de uno de sus coaches
+type WorkoutNote struct {
+	ID        int       `json:"id"`
+	WorkoutID int       `json:"workout_id"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// workoutColumns son las columnas que lee scanWorkout, en el mismo orden
+const workoutColumns = `id, user_id, title, description, duration_minutes, calories_burned, visibility, share_token, created_at, version, deleted_at`
+
+// rowScanner lo cumplen *sql.Row y *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWorkout(row rowScanner, w *Workout) error {
+	return row.Scan(&w.ID, &w.UserID, &w.Title, &w.Description, &w.DurationMinutes, &w.CaloriesBurned, &w.Visibility, &w.ShareToken, &w.CreatedAt, &w.Version, &w.DeletedAt)
+}
+
 type PostgresWorkoutStore struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
-	return &PostgresWorkoutStore{db: db}
+func NewPostgresWorkoutStore(db *sql.DB, queryTimeout time.Duration) *PostgresWorkoutStore {
+	return &PostgresWorkoutStore{db: db, queryTimeout: queryTimeout}
 }
 
 // asi es como tiene que verse nuestro store
 // si en lugar de usar postgres usamos otra db, solo tiene que respetar esta interface
 // la app trabajara con esta interface
 type WorkoutStore interface {
-	CreateWorkout(*Workout) (*Workout, error)
-	GetWorkoutByID(id int64) (*Workout, error)
-	GetWorkouts() ([]*Workout, error)
-	UpdateWorkout(*Workout) error
-	GetWorkoutOwner(id int64) (int, error)
-	DeleteWorkout(id int64) error
+	CreateWorkout(ctx context.Context, w *Workout) (*Workout, error)
+	GetWorkoutByID(ctx context.Context, id int64) (*Workout, error)
+	GetWorkoutByShareToken(ctx context.Context, token string) (*Workout, error)
+	GetWorkouts(ctx context.Context, filter WorkoutFilter) (*WorkoutPage, error)
+	// UpdateWorkout guarda el workout y sincroniza sus entries: las que traen id se modifican, las que no
+	// se insertan y las que faltan se borran. Devuelve ErrInvalidEntryID si alguna trae el id de una entry
+	// de otro workout o repetido
+	UpdateWorkout(ctx context.Context, w *Workout) error
+	GetWorkoutOwner(ctx context.Context, id int64) (int, error)
+	// DeleteWorkout manda el workout a la papelera: el resto de los metodos lo ignoran
+	// hasta que se restaura, o hasta que PurgeDeletedWorkouts lo borra para siempre
+	DeleteWorkout(ctx context.Context, id int64) error
+	// GetDeletedWorkouts devuelve los workouts de userID que estan en la papelera, los ultimos borrados primero
+	GetDeletedWorkouts(ctx context.Context, userID int) ([]*Workout, error)
+	// GetDeletedWorkoutByID es GetWorkoutByID para los workouts de la papelera
+	GetDeletedWorkoutByID(ctx context.Context, id int64) (*Workout, error)
+	// RestoreWorkout saca el workout de la papelera. Devuelve sql.ErrNoRows si no estaba en la papelera
+	RestoreWorkout(ctx context.Context, id int64) error
+	// PurgeWorkout borra el workout para siempre, este o no en la papelera
+	PurgeWorkout(ctx context.Context, id int64) error
+	// PurgeDeletedWorkouts borra para siempre los workouts que estan en la papelera desde antes de before
+	// y devuelve cuantos borro
+	PurgeDeletedWorkouts(ctx context.Context, before time.Time) (int64, error)
+	// CreateWorkoutNote devuelve sql.ErrNoRows si el workout no existe
+	CreateWorkoutNote(ctx context.Context, note *WorkoutNote) error
+	// GetWorkoutNotes devuelve las notas del workout, las mas viejas primero
+	GetWorkoutNotes(ctx context.Context, workoutID int64) ([]*WorkoutNote, error)
+
+	// Las entries se modifican a traves de su workout, que sube de version igual que con UpdateWorkout.
+	// CreateWorkoutEntry agrega la entry y le asigna el id. Devuelve sql.ErrNoRows si el workout no existe
+	CreateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error
+	// UpdateWorkoutEntry devuelve sql.ErrNoRows si la entry no es del workout
+	UpdateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error
+	// DeleteWorkoutEntry devuelve sql.ErrNoRows si la entry no es del workout
+	DeleteWorkoutEntry(ctx context.Context, w *Workout, entryID int64) error
+	// ReorderWorkoutEntries le pone a cada entry como order_index su posicion en entryIDs. entryIDs tiene
+	// que tener todas las entries del workout una sola vez, si no devuelve ErrInvalidEntryOrder
+	ReorderWorkoutEntries(ctx context.Context, w *Workout, entryIDs []int) error
 }
 
-func (pg *PostgresWorkoutStore) CreateWorkout(w *Workout) (*Workout, error) {
-	tx, err := pg.db.Begin()
+// Los metodos que modifican un workout ya guardado reciben el workout tal como se leyo y solo lo guardan
+// si su Version sigue siendo la de la db. Si no, devuelven ErrEditConflict sin cambiar nada.
+// Al guardar le asignan a w la version nueva
+var ErrEditConflict = errors.New("the workout was modified by another request")
+
+var (
+	ErrInvalidEntryID    = errors.New("entry ids must belong to the workout and not be repeated")
+	ErrInvalidEntryOrder = errors.New("the order must have every entry of the workout exactly once")
+)
+
+func (pg *PostgresWorkoutStore) CreateWorkout(ctx context.Context, w *Workout) (*Workout, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return nil, err
@@ -57,29 +208,40 @@ func (pg *PostgresWorkoutStore) CreateWorkout(w *Workout) (*Workout, error) {
 	//hace rollback
 	defer tx.Rollback()
 
-	query := `INSERT INTO workouts (title, user_id, description, duration_minutes, calories_burned)
-	VALUES($1, $2, $3, $4, $5)
-	RETURNING id
+	if w.Visibility == "" {
+		w.Visibility = VisibilityPrivate
+	}
+
+	query := `INSERT INTO workouts (title, user_id, description, duration_minutes, calories_burned, visibility, share_token)
+	VALUES($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at, version
 	`
 	//Scan es el mecanismo que copia y convierte las columnas de la query en tus variables Go.
 	//En .Scan(&w.ID) cada argumento debe ser un puntero a la variable donde querés guardar la columna.
-	err = tx.QueryRow(query, w.Title, w.UserID, w.Description, w.DurationMinutes, w.CaloriesBurned).Scan(&w.ID)
+	err = tx.QueryRowContext(ctx, query, w.Title, w.UserID, w.Description, w.DurationMinutes, w.CaloriesBurned, w.Visibility, w.ShareToken).Scan(&w.ID, &w.CreatedAt, &w.Version)
 	if err != nil {
-		return nil, err
+		return nil, constraintError(err)
 	}
 
-	for _, entry := range w.Entries {
+	for i, entry := range w.Entries {
 		query := `INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
     VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
     RETURNING id
     `
-		err = tx.QueryRow(query, w.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		//escaneamos sobre w.Entries[i] y no sobre entry, que es una copia
+		err = tx.QueryRowContext(ctx, query, w.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&w.Entries[i].ID)
 
 		if err != nil {
-			return nil, err
+			return nil, constraintError(err)
 		}
 	}
 
+	err = recordAudit(ctx, tx, int64(w.ID))
+
+	if err != nil {
+		return nil, err
+	}
+
 	err = tx.Commit()
 
 	if err != nil {
@@ -90,15 +252,29 @@ func (pg *PostgresWorkoutStore) CreateWorkout(w *Workout) (*Workout, error) {
 
 }
 
-func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
+func (pg *PostgresWorkoutStore) GetWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + workoutColumns + ` FROM workouts WHERE id = $1 AND deleted_at IS NULL`
+
+	return getWorkout(ctx, pg.db, query, id)
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutByShareToken(ctx context.Context, token string) (*Workout, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + workoutColumns + ` FROM workouts WHERE share_token = $1 AND visibility = 'public_link' AND deleted_at IS NULL`
+
+	return getWorkout(ctx, pg.db, query, token)
+}
+
+// getWorkout corre una query que devuelve un solo workout (con workoutColumns) y le carga las entries
+func getWorkout(ctx context.Context, db *sql.DB, query string, args ...any) (*Workout, error) {
 	w := &Workout{}
-	query := `
-  SELECT id, title, description, duration_minutes, calories_burned
-  FROM workouts
-  WHERE id = $1
-  `
 
-	err := pg.db.QueryRow(query, id).Scan(&w.ID, &w.Title, &w.Description, &w.DurationMinutes, &w.CaloriesBurned)
+	err := scanWorkout(db.QueryRowContext(ctx, query, args...), w)
 
 	if err == sql.ErrNoRows {
 		return nil, sql.ErrNoRows
@@ -108,36 +284,122 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
 		return nil, err
 	}
 
-	workoutEntries, err := getWorkoutEntriesOfWorkout(pg.db, id)
+	err = loadWorkoutEntries(ctx, db, []*Workout{w})
 
 	if err != nil {
 		return nil, err
 	}
 
-	w.Entries = workoutEntries
-
 	return w, nil
 }
 
-func (pg *PostgresWorkoutStore) GetWorkouts() ([]*Workout, error) {
-	var workouts []*Workout
+func (pg *PostgresWorkoutStore) GetWorkouts(ctx context.Context, filter WorkoutFilter) (*WorkoutPage, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
 
-	query := `
-  SELECT id, title, description, duration_minutes, calories_burned
-  FROM workouts;
+	return getWorkouts(ctx, pg.db, filter, postgresDialect)
+}
 
-  `
-	rows, err := pg.db.Query(query)
+// getWorkouts arma la query del listado segun el filtro. La paginacion es por keyset:
+// en vez de OFFSET se filtra por (columna de orden, id) mayor/menor al del cursor,
+// asi cada pagina cuesta lo mismo sin importar cuantas se hayan leido antes
+func getWorkouts(ctx context.Context, db *sql.DB, filter WorkoutFilter, dialect sqlDialect) (*WorkoutPage, error) {
+	filter.normalize()
 
-	defer rows.Close()
+	cursor, err := filter.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	var args []any
+
+	//arg agrega el valor a args y devuelve su placeholder ($1, $2...)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	//solo los workouts propios, los que comparten con sus seguidores los usuarios que sigue
+	//y todos los de sus atletas. Los public_link no se listan, solo se ven con el link
+	viewer := arg(filter.ViewerID)
+	conditions = append(conditions, "deleted_at IS NULL")
+	conditions = append(conditions, fmt.Sprintf(`(user_id = %s OR (visibility = 'followers' AND EXISTS (
+    SELECT 1 FROM follows f WHERE f.follower_id = %s AND f.followee_id = workouts.user_id)) OR EXISTS (
+    SELECT 1 FROM coachings c INNER JOIN users coach ON coach.id = c.coach_id WHERE c.coach_id = %s
+    AND c.athlete_id = workouts.user_id AND c.accepted_at IS NOT NULL AND coach.role IN ('coach', 'admin')))`, viewer, viewer, viewer))
+
+	if filter.UserID != 0 {
+		conditions = append(conditions, "user_id = "+arg(filter.UserID))
+	}
+
+	if filter.Title != "" {
+		conditions = append(conditions, fmt.Sprintf(`title %s %s ESCAPE '\'`, dialect.ilike, arg("%"+escapeLike(filter.Title)+"%")))
+	}
+	if filter.MinDuration != nil {
+		conditions = append(conditions, "duration_minutes >= "+arg(*filter.MinDuration))
+	}
+	if filter.MaxDuration != nil {
+		conditions = append(conditions, "duration_minutes <= "+arg(*filter.MaxDuration))
+	}
+	if filter.MinCalories != nil {
+		conditions = append(conditions, "calories_burned >= "+arg(*filter.MinCalories))
+	}
+	if filter.MaxCalories != nil {
+		conditions = append(conditions, "calories_burned <= "+arg(*filter.MaxCalories))
+	}
+
+	var sortColumn string
+	switch filter.SortBy {
+	case WorkoutSortDuration:
+		sortColumn = "duration_minutes"
+	case WorkoutSortCalories:
+		sortColumn = "calories_burned"
+	default:
+		sortColumn = dialect.timestamp("created_at")
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if cursor != nil {
+		value, _ := cursor.value()
+		valueArg := arg(value)
+		if filter.SortBy == WorkoutSortCreatedAt {
+			valueArg = dialect.timestamp(valueArg)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", sortColumn, comparison, valueArg, arg(cursor.ID)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	//pedimos uno de mas para saber si hay otra pagina sin hacer un COUNT
+	query := fmt.Sprintf(`
+  SELECT `+workoutColumns+`
+  FROM workouts
+  %s
+  ORDER BY %s %s, id %s
+  LIMIT %s
+  `, where, sortColumn, direction, direction, arg(filter.Limit+1))
+
+	rows, err := db.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
+
+	workouts := []*Workout{}
+
 	for rows.Next() {
 		workout := &Workout{}
-		err := rows.Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+		err := scanWorkout(rows, workout)
 
 		if err != nil {
 			return nil, err
@@ -146,23 +408,34 @@ func (pg *PostgresWorkoutStore) GetWorkouts() ([]*Workout, error) {
 		workouts = append(workouts, workout)
 	}
 
-	for _, w := range workouts {
-		workoutsEntries, err := getWorkoutEntriesOfWorkout(pg.db, int64(w.ID))
-		if err != nil {
-			return nil, err
-		}
-		w.Entries = workoutsEntries
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &WorkoutPage{Workouts: workouts}
 
+	if len(workouts) > filter.Limit {
+		page.Workouts = workouts[:filter.Limit]
+		page.NextCursor = filter.cursorAfter(page.Workouts[filter.Limit-1])
 	}
 
-	return workouts, nil
+	//las entries de toda la pagina se traen en una sola query
+	err = loadWorkoutEntries(ctx, db, page.Workouts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
 }
 
-func (pg *PostgresWorkoutStore) UpdateWorkout(w *Workout) error {
+func (pg *PostgresWorkoutStore) UpdateWorkout(ctx context.Context, w *Workout) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
 
 	//como es algo que cambia la bd, hacemos una transaccion
 
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return err
@@ -171,104 +444,466 @@ func (pg *PostgresWorkoutStore) UpdateWorkout(w *Workout) error {
 	defer tx.Rollback()
 
 	query := `UPDATE workouts
-  SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4
-  WHERE id = $5
+  SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4, visibility = $5, share_token = $6, version = version + 1
+  WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+  RETURNING version
   `
 
-	result, err := pg.db.Exec(query, w.Title, w.Description, w.DurationMinutes, w.CaloriesBurned, w.ID)
+	var version int
+
+	err = tx.QueryRowContext(ctx, query, w.Title, w.Description, w.DurationMinutes, w.CaloriesBurned, w.Visibility, w.ShareToken, w.ID, w.Version).Scan(&version)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return workoutVersionError(ctx, tx, w.ID)
+	}
+
+	if err != nil {
+		return constraintError(err)
+	}
+
+	err = syncWorkoutEntries(ctx, tx, w)
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(w.ID))
 
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = tx.Commit()
+
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
+	w.Version = version
+
+	return nil
+}
+
+// bumpWorkoutVersion sube la version del workout si todavia es w.Version y devuelve la nueva.
+// Se usa en la misma transaccion que el cambio, asi dos cambios a la vez no se pisan
+func bumpWorkoutVersion(ctx context.Context, db dbExecutor, w *Workout) (int, error) {
+	var version int
+
+	err := db.QueryRowContext(ctx, `UPDATE workouts SET version = version + 1 WHERE id = $1 AND version = $2 AND deleted_at IS NULL RETURNING version`, w.ID, w.Version).Scan(&version)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, workoutVersionError(ctx, db, w.ID)
+	}
+
+	return version, err
+}
+
+// workoutVersionError explica por que un UPDATE ... WHERE version = $n no encontro el workout:
+// ErrEditConflict si existe con otra version, sql.ErrNoRows si no existe o esta en la papelera
+func workoutVersionError(ctx context.Context, db dbExecutor, id int) error {
+	var exists bool
+
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM workouts WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
 		return sql.ErrNoRows
 	}
 
-	//para actualizar los workout entries hacemos:
-	//borramos todos los workout entries del workout que acabamos de actualizar
-	_, err = pg.db.Exec(`DELETE FROM workout_entries WHERE workout_id = $1`, w.ID)
+	return ErrEditConflict
+}
+
+// syncWorkoutEntries deja en la db las entries de w comparando con las que ya tiene, asi las que
+// no cambiaron conservan su id: las que traen id se modifican, las que no se insertan (y se les
+// asigna el id en w.Entries) y las que no vinieron se borran
+func syncWorkoutEntries(ctx context.Context, tx *sql.Tx, w *Workout) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM workout_entries WHERE workout_id = $1`, w.ID)
 
 	if err != nil {
 		return err
 	}
 
-	//actualizamos los workout entries del workout con los que vienen en la  llamada
-	for _, entry := range w.Entries {
-		query := `INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-    RETURNING id
-    `
-		_, err := tx.Exec(query, w.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex)
+	existing := map[int]bool{}
+
+	for rows.Next() {
+		var id int
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		existing[id] = false
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range w.Entries {
+		entry := &w.Entries[i]
+
+		if entry.ID == 0 {
+			err := insertWorkoutEntry(ctx, tx, int64(w.ID), entry)
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		//existing guarda si la entry ya se uso, una entry ajena o repetida invalida todo el update
+		kept, ok := existing[entry.ID]
+
+		if !ok || kept {
+			return ErrInvalidEntryID
+		}
+
+		existing[entry.ID] = true
+
+		err := updateWorkoutEntry(ctx, tx, int64(w.ID), entry)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, kept := range existing {
+		if kept {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM workout_entries WHERE id = $1`, id)
 
 		if err != nil {
 			return err
 		}
 	}
-	return tx.Commit()
+
+	return nil
 }
 
-func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
+// insertWorkoutEntry inserta la entry si el workout existe (si no, devuelve sql.ErrNoRows) y le asigna el id
+func insertWorkoutEntry(ctx context.Context, db dbExecutor, workoutID int64, e *WorkoutEntry) error {
+	query := `INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+  SELECT id, $2, $3, $4, $5, $6, $7, $8 FROM workouts WHERE id = $1
+  RETURNING id`
 
-	query := `DELETE FROM workouts
-  WHERE id = $1
-  `
-	result, err := pg.db.Exec(query, id)
+	err := db.QueryRowContext(ctx, query, workoutID, e.ExerciseName, e.Sets, e.Reps, e.DurationSeconds, e.Weight, e.Notes, e.OrderIndex).Scan(&e.ID)
+
+	return constraintError(err)
+}
+
+// updateWorkoutEntry devuelve sql.ErrNoRows si la entry no es del workout
+func updateWorkoutEntry(ctx context.Context, db dbExecutor, workoutID int64, e *WorkoutEntry) error {
+	query := `UPDATE workout_entries
+  SET exercise_name = $3, sets = $4, reps = $5, duration_seconds = $6, weight = $7, notes = $8, order_index = $9
+  WHERE id = $1 AND workout_id = $2`
+
+	err := execAffectingRows(ctx, db, query, e.ID, workoutID, e.ExerciseName, e.Sets, e.Reps, e.DurationSeconds, e.Weight, e.Notes, e.OrderIndex)
+
+	return constraintError(err)
+}
+
+// withWorkoutVersion corre fn en una transaccion que tambien sube la version de w, y se la asigna al terminar
+func (pg *PostgresWorkoutStore) withWorkoutVersion(ctx context.Context, w *Workout, fn func(tx *sql.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
 
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	version, err := bumpWorkoutVersion(ctx, tx, w)
 
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	err = fn(tx)
+
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, int64(w.ID))
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
 	}
 
+	w.Version = version
+
 	return nil
+}
+
+func (pg *PostgresWorkoutStore) CreateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error {
+	return pg.withWorkoutVersion(ctx, w, func(tx *sql.Tx) error {
+		return insertWorkoutEntry(ctx, tx, int64(w.ID), e)
+	})
+}
 
+func (pg *PostgresWorkoutStore) UpdateWorkoutEntry(ctx context.Context, w *Workout, e *WorkoutEntry) error {
+	return pg.withWorkoutVersion(ctx, w, func(tx *sql.Tx) error {
+		return updateWorkoutEntry(ctx, tx, int64(w.ID), e)
+	})
 }
 
-func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
+func (pg *PostgresWorkoutStore) DeleteWorkoutEntry(ctx context.Context, w *Workout, entryID int64) error {
+	return pg.withWorkoutVersion(ctx, w, func(tx *sql.Tx) error {
+		return execAffectingRows(ctx, tx, `DELETE FROM workout_entries WHERE id = $1 AND workout_id = $2`, entryID, w.ID)
+	})
+}
 
-	query := `SELECT user_id FROM workouts WHERE id = $1`
+func (pg *PostgresWorkoutStore) ReorderWorkoutEntries(ctx context.Context, w *Workout, entryIDs []int) error {
+	return pg.withWorkoutVersion(ctx, w, func(tx *sql.Tx) error {
+		var count int
 
-	var id int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM workout_entries WHERE workout_id = $1`, w.ID).Scan(&count)
+
+		if err != nil {
+			return err
+		}
+
+		//tienen que venir todas las entries, cada una una sola vez
+		if count != len(entryIDs) {
+			return ErrInvalidEntryOrder
+		}
+
+		seen := make(map[int]bool, len(entryIDs))
+
+		for i, id := range entryIDs {
+			if seen[id] {
+				return ErrInvalidEntryOrder
+			}
+
+			seen[id] = true
+
+			err := execAffectingRows(ctx, tx, `UPDATE workout_entries SET order_index = $3 WHERE id = $1 AND workout_id = $2`, id, w.ID, i)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidEntryOrder
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
 
-	err := pg.db.QueryRow(query, workoutID).Scan(&id)
+func (pg *PostgresWorkoutStore) DeleteWorkout(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	//las entries y las notas quedan como estaban, asi restaurar el workout lo deja igual que antes
+	query := `UPDATE workouts
+  SET deleted_at = $2, version = version + 1
+  WHERE id = $1 AND deleted_at IS NULL
+  `
+
+	return audited(ctx, pg.db, id, func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, id, dbTime(time.Now()))
+	})
+}
+
+func (pg *PostgresWorkoutStore) GetDeletedWorkouts(ctx context.Context, userID int) ([]*Workout, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + workoutColumns + `
+  FROM workouts
+  WHERE user_id = $1 AND deleted_at IS NOT NULL
+  ORDER BY deleted_at DESC, id DESC`
+
+	rows, err := pg.db.QueryContext(ctx, query, userID)
 
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
-	if err == sql.ErrNoRows {
+	defer rows.Close()
+
+	workouts := []*Workout{}
+
+	for rows.Next() {
+		workout := &Workout{}
+
+		err := scanWorkout(rows, workout)
+
+		if err != nil {
+			return nil, err
+		}
+
+		workouts = append(workouts, workout)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = loadWorkoutEntries(ctx, pg.db, workouts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return workouts, nil
+}
+
+func (pg *PostgresWorkoutStore) GetDeletedWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + workoutColumns + ` FROM workouts WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	return getWorkout(ctx, pg.db, query, id)
+}
+
+func (pg *PostgresWorkoutStore) RestoreWorkout(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE workouts
+  SET deleted_at = NULL, version = version + 1
+  WHERE id = $1 AND deleted_at IS NOT NULL
+  `
+
+	return audited(ctx, pg.db, id, func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, query, id)
+	})
+}
+
+func (pg *PostgresWorkoutStore) PurgeWorkout(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	//las entries y las notas se borran con el workout (ON DELETE CASCADE)
+	return audited(ctx, pg.db, id, func(db dbExecutor) error {
+		return execAffectingRows(ctx, db, `DELETE FROM workouts WHERE id = $1`, id)
+	})
+}
+
+func (pg *PostgresWorkoutStore) PurgeDeletedWorkouts(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM workouts WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING id`, dbTime(before))
+
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	//un evento por workout, en la misma transaccion que el borrado
+	for _, id := range ids {
+		event := &AuditEvent{Action: AuditActionWorkoutPurge, TargetType: AuditTargetWorkout}
+
+		err = recordAudit(WithAuditEvent(ctx, event), tx, id)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(ids)), nil
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutOwner(ctx context.Context, workoutID int64) (int, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT user_id FROM workouts WHERE id = $1 AND deleted_at IS NULL`
+
+	var id int
+
+	err := pg.db.QueryRowContext(ctx, query, workoutID).Scan(&id)
+
+	if err != nil {
 		return -1, err
 	}
 
 	return id, nil
 }
 
-// ver de poner esta en otro archivo
-func getWorkoutEntriesOfWorkout(db *sql.DB, id int64) ([]WorkoutEntry, error) {
-	var workoutEntries []WorkoutEntry
+func (pg *PostgresWorkoutStore) CreateWorkoutNote(ctx context.Context, note *WorkoutNote) error {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
 
-	entryQuery := `
-  SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
-  FROM workout_entries
+	//el INSERT ... SELECT no inserta nada si el workout no existe, y Scan devuelve sql.ErrNoRows
+	query := `INSERT INTO workout_notes (workout_id, author_id, body, created_at)
+  SELECT id, $2, $3, $4 FROM workouts WHERE id = $1 AND deleted_at IS NULL
+  RETURNING id`
+
+	note.CreatedAt = dbTime(time.Now())
+
+	return audited(ctx, pg.db, int64(note.WorkoutID), func(db dbExecutor) error {
+		err := db.QueryRowContext(ctx, query, note.WorkoutID, note.AuthorID, note.Body, note.CreatedAt).Scan(&note.ID)
+
+		return constraintError(err)
+	})
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutNotes(ctx context.Context, workoutID int64) ([]*WorkoutNote, error) {
+	ctx, cancel := withTimeout(ctx, pg.queryTimeout)
+	defer cancel()
+
+	query := `SELECT id, workout_id, author_id, body, created_at
+  FROM workout_notes
   WHERE workout_id = $1
-  ORDER BY order_index
-  `
+  ORDER BY created_at, id`
 
-	rows, err := db.Query(entryQuery, id)
+	rows, err := pg.db.QueryContext(ctx, query, workoutID)
 
 	if err != nil {
 		return nil, err
@@ -276,17 +911,68 @@ func getWorkoutEntriesOfWorkout(db *sql.DB, id int64) ([]WorkoutEntry, error) {
 
 	defer rows.Close()
 
+	notes := []*WorkoutNote{}
+
 	for rows.Next() {
-		wEntry := &WorkoutEntry{}
+		note := &WorkoutNote{}
 
-		err := rows.Scan(&wEntry.ID, &wEntry.ExerciseName, &wEntry.Sets, &wEntry.Reps, &wEntry.DurationSeconds, &wEntry.Weight, &wEntry.Notes, &wEntry.OrderIndex)
+		err := rows.Scan(&note.ID, &note.WorkoutID, &note.AuthorID, &note.Body, &note.CreatedAt)
 
 		if err != nil {
 			return nil, err
 		}
 
-		workoutEntries = append(workoutEntries, *wEntry)
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// loadWorkoutEntries carga las entries de todos los workouts con una sola query
+// (WHERE workout_id IN (...)) en vez de una por workout, y las reparte en cada uno
+func loadWorkoutEntries(ctx context.Context, db *sql.DB, workouts []*Workout) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Workout, len(workouts))
+	placeholders := make([]string, len(workouts))
+	args := make([]any, len(workouts))
+
+	for i, w := range workouts {
+		byID[w.ID] = w
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = w.ID
+	}
+
+	entryQuery := fmt.Sprintf(`
+  SELECT workout_id, id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+  FROM workout_entries
+  WHERE workout_id IN (%s)
+  ORDER BY workout_id, order_index, id
+  `, strings.Join(placeholders, ", "))
+
+	rows, err := db.QueryContext(ctx, entryQuery, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var workoutID int
+		wEntry := WorkoutEntry{}
+
+		err := rows.Scan(&workoutID, &wEntry.ID, &wEntry.ExerciseName, &wEntry.Sets, &wEntry.Reps, &wEntry.DurationSeconds, &wEntry.Weight, &wEntry.Notes, &wEntry.OrderIndex)
+
+		if err != nil {
+			return err
+		}
+
+		w := byID[workoutID]
+		w.Entries = append(w.Entries, wEntry)
 	}
 
-	return workoutEntries, nil
+	return rows.Err()
 }
@@ -1,7 +1,9 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"os"
 	"testing"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -10,19 +12,32 @@ import (
 )
 
 func setupDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable")
+	//solo corren si TEST_DATABASE_DSN apunta a una db (por ej. el test_db de docker-compose). Si esta
+	//seteada y la db no responde el test falla, en vez de pasar sin probar nada
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+
+	if dsn == "" {
+		t.Skip("postgres tests need TEST_DATABASE_DSN")
+	}
+
+	db, err := sql.Open("pgx", dsn)
 
 	if err != nil {
 		t.Fatalf("Connect to DB: %v", err)
 	}
 
-	err = Migrate(db, "../../migrations")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Fatalf("postgres test db not available at TEST_DATABASE_DSN: %v", err)
+	}
+
+	err = Migrate(db, DriverPostgres, "../../migrations")
 
 	if err != nil {
 		t.Fatalf("Running migrations: %v", err)
 	}
 
-	_, err = db.Exec("TRUNCATE workouts, workout_entries")
+	_, err = db.Exec("TRUNCATE workouts, workout_entries, workout_notes")
 
 	if err != nil {
 		t.Fatalf("Truncating tables: %v", err)
@@ -31,12 +46,43 @@ func setupDB(t *testing.T) *sql.DB {
 	return db
 }
 
+// newPostgresStores vacia todas las tablas antes de cada subtest de la suite, asi arrancan
+// de cero como con las otras implementaciones
+func newPostgresStores(t *testing.T) stores {
+	db := setupDB(t)
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	_, err := db.Exec("TRUNCATE users, login_attempts, audit_events RESTART IDENTITY CASCADE")
+
+	if err != nil {
+		t.Fatalf("Truncating tables: %v", err)
+	}
+
+	return stores{
+		workouts:      NewPostgresWorkoutStore(db, 0),
+		users:         NewPostgresUserStore(db, 0),
+		tokens:        NewPostgresTokenStore(db, 0),
+		loginAttempts: NewPostgresLoginAttemptStore(db, 0),
+		apiKeys:       NewPostgresAPIKeyStore(db, 0),
+		coachings:     NewPostgresCoachingStore(db, 0),
+		audit:         NewPostgresAuditStore(db, 0),
+	}
+}
+
+func TestPostgresStores(t *testing.T) {
+	runStoreSuite(t, newPostgresStores)
+}
+
 func TestCreateWorkout(t *testing.T) {
 	db := setupDB(t)
 
 	defer db.Close()
 
-	store := NewPostgresWorkoutStore(db)
+	store := NewPostgresWorkoutStore(db, 0)
+	ctx := context.Background()
 
 	tests := []struct {
 		name    string
@@ -95,7 +141,7 @@ func TestCreateWorkout(t *testing.T) {
 
 	for _, c := range tests {
 		t.Run(c.name, func(t *testing.T) {
-			createdWorkout, err := store.CreateWorkout(c.workout)
+			createdWorkout, err := store.CreateWorkout(ctx, c.workout)
 			if c.wantErr {
 				assert.Error(t, err)
 				return
@@ -107,7 +153,7 @@ func TestCreateWorkout(t *testing.T) {
 			assert.Equal(t, c.workout.CaloriesBurned, createdWorkout.CaloriesBurned)
 			assert.Equal(t, c.workout.DurationMinutes, createdWorkout.DurationMinutes)
 
-			retrieved, err := store.GetWorkoutByID(int64(createdWorkout.ID))
+			retrieved, err := store.GetWorkoutByID(ctx, int64(createdWorkout.ID))
 
 			require.NoError(t, err)
 
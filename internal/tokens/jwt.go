@@ -0,0 +1,194 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Algoritmos para firmar los JWT
+const (
+	JWTAlgorithmEdDSA = "EdDSA"
+	JWTAlgorithmHS256 = "HS256"
+)
+
+// JWTClaims es lo que lleva un token de auth en formato JWT. Alcanza para autenticar
+// la request sin ir a la db; el resto del usuario se carga solo en las rutas que lo necesitan
+type JWTClaims struct {
+	UserID   int    `json:"uid"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	//nil si el email no estaba verificado cuando se firmo el token
+	EmailVerifiedAt *jwt.NumericDate `json:"email_verified_at,omitempty"`
+	Scopes          []string         `json:"scopes"`
+	jwt.RegisteredClaims
+}
+
+// HasScope dice si el token se firmo para scope
+func (c *JWTClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// JWTManager firma y valida los JWT. Tiene un llavero de keys por key id (kid): firma siempre
+// con la activa y valida con cualquiera, asi se puede rotar la key sin invalidar los tokens
+// que ya estan dando vueltas. La key vieja se saca del llavero cuando vencieron sus tokens
+type JWTManager struct {
+	method     jwt.SigningMethod
+	activeKID  string
+	signKeys   map[string]any
+	verifyKeys map[string]any
+	issuer     string
+}
+
+// NewJWTManager arma el manager. keys es el texto de JWT_KEYS: pares kid:key separados por comas,
+// con las keys en base64. Para EdDSA la key es la semilla de 32 bytes de la privada, para HS256
+// el secreto (32 bytes como minimo). Si activeKID esta vacio se firma con la primera key
+func NewJWTManager(algorithm, keys, activeKID, issuer string) (*JWTManager, error) {
+	m := &JWTManager{
+		activeKID:  activeKID,
+		signKeys:   make(map[string]any),
+		verifyKeys: make(map[string]any),
+		issuer:     issuer,
+	}
+
+	switch algorithm {
+	case JWTAlgorithmEdDSA:
+		m.method = jwt.SigningMethodEdDSA
+	case JWTAlgorithmHS256:
+		m.method = jwt.SigningMethodHS256
+	default:
+		return nil, fmt.Errorf("jwt: unknown algorithm %q", algorithm)
+	}
+
+	for _, pair := range strings.Split(keys, ",") {
+		kid, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+
+		if !ok || kid == "" {
+			return nil, errors.New("jwt: keys must be kid:base64key pairs separated by commas")
+		}
+
+		if _, exists := m.signKeys[kid]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", kid)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", kid, err)
+		}
+
+		switch algorithm {
+		case JWTAlgorithmEdDSA:
+			if len(key) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt: key %q must be a %d byte ed25519 seed, got %d bytes", kid, ed25519.SeedSize, len(key))
+			}
+			private := ed25519.NewKeyFromSeed(key)
+			m.signKeys[kid] = private
+			m.verifyKeys[kid] = private.Public()
+		case JWTAlgorithmHS256:
+			if len(key) < 32 {
+				return nil, fmt.Errorf("jwt: key %q must have at least 32 bytes, got %d", kid, len(key))
+			}
+			m.signKeys[kid] = key
+			m.verifyKeys[kid] = key
+		}
+
+		if m.activeKID == "" {
+			m.activeKID = kid
+		}
+	}
+
+	if _, ok := m.signKeys[m.activeKID]; !ok {
+		return nil, fmt.Errorf("jwt: active key %q is not in the keys", m.activeKID)
+	}
+
+	return m, nil
+}
+
+// GenerateToken firma un token de auth para user. El Token que devuelve es como los de GenerateToken,
+// con Hash para poder guardarlo como sesion (y cerrarla), aunque el middleware no lo busque en la db
+func (m *JWTManager) GenerateToken(userID int, username, role string, emailVerifiedAt *time.Time, ttl time.Duration) (*Token, error) {
+	now := time.Now().UTC()
+
+	jti, err := randomPlaintext()
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := JWTClaims{
+		UserID:   userID,
+		Username: username,
+		Role:     role,
+		Scopes:   []string{ScopeAuth},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+	}
+
+	if emailVerifiedAt != nil {
+		claims.EmailVerifiedAt = jwt.NewNumericDate(*emailVerifiedAt)
+	}
+
+	token := jwt.NewWithClaims(m.method, claims)
+	token.Header["kid"] = m.activeKID
+
+	signed, err := token.SignedString(m.signKeys[m.activeKID])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Plaintext: signed,
+		UserID:    userID,
+		Hash:      Hash(signed),
+		Scope:     ScopeAuth,
+		Expiry:    claims.ExpiresAt.Time,
+		CreatedAt: now,
+	}, nil
+}
+
+// Parse valida la firma, el algoritmo, el issuer y que no haya vencido, y devuelve los claims
+func (m *JWTManager) Parse(signed string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{m.method.Alg()}))
+
+	_, err := parser.ParseWithClaims(signed, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, ok := m.verifyKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		return key, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(m.issuer, true) {
+		return nil, errors.New("jwt: invalid issuer")
+	}
+
+	return claims, nil
+}
+
+// IsJWT dice si un token de auth tiene forma de JWT (header.payload.firma) y no de token opaco
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte, size int) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), size)))
+}
+
+func TestJWTManager(t *testing.T) {
+	for _, algorithm := range []string{JWTAlgorithmEdDSA, JWTAlgorithmHS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			m, err := NewJWTManager(algorithm, "k1:"+testKey('a', 32), "", "workout-api")
+			require.NoError(t, err)
+
+			verifiedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+			token, err := m.GenerateToken(7, "joaquin", "user", &verifiedAt, time.Hour)
+			require.NoError(t, err)
+			assert.True(t, IsJWT(token.Plaintext))
+			assert.Equal(t, Hash(token.Plaintext), token.Hash)
+			assert.Equal(t, ScopeAuth, token.Scope)
+
+			claims, err := m.Parse(token.Plaintext)
+			require.NoError(t, err)
+			assert.Equal(t, 7, claims.UserID)
+			assert.Equal(t, "7", claims.Subject)
+			assert.Equal(t, "joaquin", claims.Username)
+			assert.Equal(t, "user", claims.Role)
+			assert.True(t, claims.HasScope(ScopeAuth))
+			require.NotNil(t, claims.EmailVerifiedAt)
+			assert.True(t, verifiedAt.Equal(claims.EmailVerifiedAt.Time))
+			assert.WithinDuration(t, token.Expiry, claims.ExpiresAt.Time, time.Second)
+
+			expired, err := m.GenerateToken(7, "joaquin", "user", nil, -time.Minute)
+			require.NoError(t, err)
+			_, err = m.Parse(expired.Plaintext)
+			assert.Error(t, err)
+
+			//cambiar el payload invalida la firma
+			parts := strings.Split(token.Plaintext, ".")
+			other, err := m.GenerateToken(8, "other", "user", nil, time.Hour)
+			require.NoError(t, err)
+			_, err = m.Parse(parts[0] + "." + strings.Split(other.Plaintext, ".")[1] + "." + parts[2])
+			assert.Error(t, err)
+
+			otherIssuer, err := NewJWTManager(algorithm, "k1:"+testKey('a', 32), "", "someone-else")
+			require.NoError(t, err)
+			_, err = otherIssuer.Parse(token.Plaintext)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldKeys := "old:" + testKey('a', 32)
+	bothKeys := oldKeys + ",new:" + testKey('b', 32)
+
+	before, err := NewJWTManager(JWTAlgorithmEdDSA, oldKeys, "", "workout-api")
+	require.NoError(t, err)
+	during, err := NewJWTManager(JWTAlgorithmEdDSA, bothKeys, "new", "workout-api")
+	require.NoError(t, err)
+	after, err := NewJWTManager(JWTAlgorithmEdDSA, "new:"+testKey('b', 32), "", "workout-api")
+	require.NoError(t, err)
+
+	oldToken, err := before.GenerateToken(1, "joaquin", "user", nil, time.Hour)
+	require.NoError(t, err)
+	newToken, err := during.GenerateToken(1, "joaquin", "user", nil, time.Hour)
+	require.NoError(t, err)
+
+	//mientras estan las dos keys valen los tokens de las dos
+	_, err = during.Parse(oldToken.Plaintext)
+	assert.NoError(t, err)
+	_, err = after.Parse(newToken.Plaintext)
+	assert.NoError(t, err)
+
+	//cuando se saca la key vieja sus tokens dejan de servir
+	_, err = after.Parse(oldToken.Plaintext)
+	assert.Error(t, err)
+}
+
+func TestJWTAlgorithmMismatch(t *testing.T) {
+	//un token HS256 no puede pasar por uno de EdDSA aunque se use la misma key
+	hmac, err := NewJWTManager(JWTAlgorithmHS256, "k1:"+testKey('a', 32), "", "workout-api")
+	require.NoError(t, err)
+	eddsa, err := NewJWTManager(JWTAlgorithmEdDSA, "k1:"+testKey('a', 32), "", "workout-api")
+	require.NoError(t, err)
+
+	token, err := hmac.GenerateToken(1, "joaquin", "user", nil, time.Hour)
+	require.NoError(t, err)
+
+	_, err = eddsa.Parse(token.Plaintext)
+	assert.Error(t, err)
+}
+
+func TestNewJWTManagerInvalid(t *testing.T) {
+	cases := map[string]struct {
+		algorithm, keys, active string
+	}{
+		"unknown algorithm":  {"RS256", "k1:" + testKey('a', 32), ""},
+		"missing kid":        {JWTAlgorithmEdDSA, testKey('a', 32), ""},
+		"not base64":         {JWTAlgorithmEdDSA, "k1:not base64!", ""},
+		"short ed25519 seed": {JWTAlgorithmEdDSA, "k1:" + testKey('a', 16), ""},
+		"short hmac secret":  {JWTAlgorithmHS256, "k1:" + testKey('a', 16), ""},
+		"duplicate kid":      {JWTAlgorithmHS256, "k1:" + testKey('a', 32) + ",k1:" + testKey('b', 32), ""},
+		"unknown active key": {JWTAlgorithmHS256, "k1:" + testKey('a', 32), "k2"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewJWTManager(c.algorithm, c.keys, c.active, "workout-api")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -8,35 +8,90 @@ import (
 )
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth          = "authentication"
+	ScopeRefresh       = "refresh"
+	ScopePasswordReset = "password-reset"
+	ScopeActivation    = "activation"
+	ScopeMFAPending    = "mfa-pending" //lo da el login a las cuentas con 2FA, solo sirve para POST /tokens/mfa
 )
 
 type Token struct {
+	ID        int       `json:"-"`
 	Plaintext string    `json:"token"`
 	UserID    int       `json:"-"`
 	Hash      []byte    `json:"-"`
 	Scope     string    `json:"-"`
 	Expiry    time.Time `json:"expiry"`
+	CreatedAt time.Time `json:"-"`
+	//desde que cliente se creo el token, para que el usuario reconozca sus sesiones
+	UserAgent string `json:"-"`
+	//los tokens de una misma sesion (el de auth y los refresh que se van rotando) comparten familia
+	FamilyID string `json:"-"`
+	//cuando se roto un refresh token, nil si todavia no se uso
+	UsedAt *time.Time `json:"-"`
 }
 
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+	now := time.Now().UTC()
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		Expiry:    now.Add(ttl),
+		Scope:     scope,
+		CreatedAt: now,
 	}
 
-	emptyBytes := make([]byte, 32)
-
-	_, err := rand.Read(emptyBytes) //pone en emptyBytes randoms bytes criptograficamente seguros
+	plaintext, err := randomPlaintext()
 
 	if err != nil {
 		return nil, err
 	}
 
-	token.Plaintext = base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Plaintext = plaintext
+	token.Hash = Hash(plaintext)
 
 	return token, nil
 }
+
+// Hash es el hash con el que se guarda (y se busca) un token en la db
+func Hash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// GenerateFamilyID genera el id de familia de una sesion nueva
+func GenerateFamilyID() (string, error) {
+	return randomPlaintext()
+}
+
+// GenerateShareToken genera el token de los links para compartir workouts.
+// No expira ni se hashea: el dueño tiene que poder volver a ver su link
+func GenerateShareToken() (string, error) {
+	return randomPlaintext()
+}
+
+// APIKeyPrefix va adelante de las API keys, asi el middleware las distingue de los tokens de sesion
+// (y se reconocen si alguien pega una en un repo o en un log)
+const APIKeyPrefix = "wapi_"
+
+// GenerateAPIKey genera el texto de una API key. Se guarda hasheada con Hash, como los tokens
+func GenerateAPIKey() (string, error) {
+	plaintext, err := randomPlaintext()
+
+	if err != nil {
+		return "", err
+	}
+
+	return APIKeyPrefix + plaintext, nil
+}
+
+func randomPlaintext() (string, error) {
+	emptyBytes := make([]byte, 32)
+
+	_, err := rand.Read(emptyBytes) //pone en emptyBytes randoms bytes criptograficamente seguros
+
+	if err != nil {
+		return "", err
+	}
+
+	return base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes), nil
+}
@@ -0,0 +1,126 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP de la RFC 6238 con los parametros que entienden todas las apps de autenticacion
+// (Google Authenticator, Authy, 1Password...): HMAC-SHA1, 6 digitos y pasos de 30 segundos
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	//cuantos pasos antes y despues del actual se aceptan, por si el reloj del celular esta corrido
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret genera un secreto de 160 bits (el largo que recomienda la RFC 4226) en base32
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	_, err := rand.Read(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI arma el otpauth:// que se muestra como QR para cargar el secreto en la app
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(Digits))
+	params.Set("period", strconv.Itoa(int(Period.Seconds())))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
+
+// Code devuelve el codigo de secret en el momento t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	return codeAt(key, counter(t)), nil
+}
+
+// Validate dice si code es el codigo de secret en t (o en el paso anterior o siguiente)
+func Validate(secret, code string, t time.Time) bool {
+	_, ok := Match(secret, code, t)
+
+	return ok
+}
+
+// Match es Validate pero ademas devuelve el paso en el que coincidio el codigo. Guardando el ultimo
+// paso usado se puede rechazar el mismo codigo si se vuelve a mandar dentro de su ventana
+func Match(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	var step int64
+	valid := false
+	for i := -skew; i <= skew; i++ {
+		//se comparan todos los pasos para que el tiempo no dependa de cual coincide
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, counter(t)+int64(i))), []byte(code)) == 1 {
+			step = counter(t) + int64(i)
+			valid = true
+		}
+	}
+
+	return step, valid
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+
+	if err != nil {
+		return nil, fmt.Errorf("totp: invalid secret: %w", err)
+	}
+
+	return key, nil
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// codeAt es el HOTP de la RFC 4226 para counter
+func codeAt(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	//truncado dinamico: los 4 bits bajos del ultimo byte dicen de donde sacar los 31 bits del codigo
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
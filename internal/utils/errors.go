@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Codigos de error estables de la api. Los clientes tienen que decidir con el code, nunca con
+// el message, que es para humanos y puede cambiar. Agregar codigos esta bien, cambiarlos no
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeValidation         = "validation_failed"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeInvalidToken       = "invalid_token"
+	ErrCodeInvalidCredentials = "invalid_credentials"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeEmailNotVerified   = "email_not_verified"
+	ErrCodeAccountSuspended   = "account_suspended"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeMethodNotAllowed   = "method_not_allowed"
+	ErrCodeConflict           = "conflict"
+	ErrCodeEditConflict       = "edit_conflict"
+	ErrCodePrecondition       = "precondition_failed"
+	ErrCodeUnsupportedMedia   = "unsupported_media_type"
+	ErrCodeTooManyRequests    = "too_many_requests"
+	ErrCodeInternal           = "internal_error"
+)
+
+// APIError es la respuesta de error de todos los handlers y middlewares. Se manda como
+// {"error": {"code": ..., "message": ..., "details": {...}}} con WriteError
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	//errores por campo (campo -> problema), para los payloads invalidos
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// WithDetails devuelve una copia del error con los errores por campo
+func (e *APIError) WithDetails(details map[string]string) *APIError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+func BadRequest(message string) *APIError {
+	return NewAPIError(http.StatusBadRequest, ErrCodeBadRequest, message)
+}
+
+// ValidationFailed es para los payloads que se pudieron leer pero tienen valores invalidos
+func ValidationFailed(message string, details map[string]string) *APIError {
+	return NewAPIError(http.StatusUnprocessableEntity, ErrCodeValidation, message).WithDetails(details)
+}
+
+func Unauthorized(message string) *APIError {
+	return NewAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, message)
+}
+
+func InvalidToken(message string) *APIError {
+	return NewAPIError(http.StatusUnauthorized, ErrCodeInvalidToken, message)
+}
+
+func Forbidden(message string) *APIError {
+	return NewAPIError(http.StatusForbidden, ErrCodeForbidden, message)
+}
+
+func NotFound(message string) *APIError {
+	return NewAPIError(http.StatusNotFound, ErrCodeNotFound, message)
+}
+
+func Conflict(message string) *APIError {
+	return NewAPIError(http.StatusConflict, ErrCodeConflict, message)
+}
+
+// PreconditionFailed es para un If-Match que no coincide con la version actual del recurso
+func PreconditionFailed(message string) *APIError {
+	return NewAPIError(http.StatusPreconditionFailed, ErrCodePrecondition, message)
+}
+
+func TooManyRequests(message string) *APIError {
+	return NewAPIError(http.StatusTooManyRequests, ErrCodeTooManyRequests, message)
+}
+
+// InternalError no lleva el detalle del error, eso va al log y no al cliente
+func InternalError() *APIError {
+	return NewAPIError(http.StatusInternalServerError, ErrCodeInternal, "internal server error")
+}
+
+func WriteError(w http.ResponseWriter, err *APIError) error {
+	return WriteJSON(w, err.Status, Envelope{"error": err})
+}
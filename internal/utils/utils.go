@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -31,7 +32,12 @@ func WriteJSON(w http.ResponseWriter, status int, data Envelope) error {
 }
 
 func ReadIdParam(w http.ResponseWriter, r *http.Request) (int64, error) {
-	paramID := chi.URLParam(r, "id")
+	return ReadIntParam(r, "id")
+}
+
+// ReadIntParam lee un param numerico de la ruta, como {entryID}
+func ReadIntParam(r *http.Request, name string) (int64, error) {
+	paramID := chi.URLParam(r, name)
 
 	if paramID == "" {
 		return 0, errors.New("no param was provided")
@@ -45,3 +51,20 @@ func ReadIdParam(w http.ResponseWriter, r *http.Request) (int64, error) {
 
 	return id, nil
 }
+
+// ReadIntQuery lee un query param entero. Devuelve nil si el param no vino
+func ReadIntQuery(r *http.Request, key string) (*int, error) {
+	value := r.URL.Query().Get(key)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", key)
+	}
+
+	return &n, nil
+}
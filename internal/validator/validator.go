@@ -0,0 +1,58 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator junta los errores de un payload campo por campo, asi el cliente recibe todos los
+// problemas en una sola respuesta. Los campos anidados usan la ruta del json, por ej "entries[2].sets"
+type Validator struct {
+	Errors map[string]string
+}
+
+func New() *Validator {
+	return &Validator{Errors: map[string]string{}}
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError agrega el error del campo. Si el campo ya tenia uno se queda el primero,
+// que suele ser el mas basico (por ej "is required" antes que "is too long")
+func (v *Validator) AddError(field, message string) {
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = message
+	}
+}
+
+// Check agrega el error del campo si ok es false
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+// Field arma el nombre de un campo de un elemento de una lista, por ej Field("entries", 2, "sets")
+func Field(list string, index int, field string) string {
+	if field == "" {
+		return fmt.Sprintf("%s[%d]", list, index)
+	}
+
+	return fmt.Sprintf("%s[%d].%s", list, index, field)
+}
+
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+// MaxChars cuenta caracteres y no bytes, igual que los VARCHAR de la db
+func MaxChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+func Between[T int | float64](value, min, max T) bool {
+	return value >= min && value <= max
+}
@@ -0,0 +1,34 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	v := New()
+	assert.True(t, v.Valid())
+
+	v.Check(NotBlank("  "), "title", "is required")
+	v.Check(MaxChars("  ", 1), "title", "is too long")
+	v.Check(Between(0, 1, 10), Field("entries", 2, "sets"), "must be between 1 and 10")
+	v.Check(Between(5.5, 0, 10), "weight", "must be between 0 and 10")
+
+	assert.False(t, v.Valid())
+	assert.Equal(t, map[string]string{
+		"title":           "is required",
+		"entries[2].sets": "must be between 1 and 10",
+	}, v.Errors)
+}
+
+func TestMaxChars(t *testing.T) {
+	//cuenta caracteres, no bytes
+	assert.True(t, MaxChars("ñandú", 5))
+	assert.False(t, MaxChars("ñandú!", 5))
+}
+
+func TestField(t *testing.T) {
+	assert.Equal(t, "entries[0]", Field("entries", 0, ""))
+	assert.Equal(t, "entries[3].reps", Field("entries", 3, "reps"))
+}
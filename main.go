@@ -1,24 +1,28 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"net/http"
-	"time"
+	"os"
 
 	"github.com/joaquinbian/workout-api-go/internal/app"
+	"github.com/joaquinbian/workout-api-go/internal/config"
 	"github.com/joaquinbian/workout-api-go/internal/routes"
 )
 
 func main() {
 
-	var port int
+	//la config sale de flags (por ej -port), variables de entorno y el archivo .env, ver config.Load
+	cfg, err := config.Load(os.Args[1:])
 
-	//nos deja pasarle el puerto mediante la flag -port y la guarda en la variable port
-	flag.IntVar(&port, "port", 8080, "Server port")
-	flag.Parse()
+	if err != nil {
+		//una config invalida no es un error inesperado, asi que mostramos el mensaje y salimos sin panic
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	app, err := app.NewApplication()
+	app, err := app.NewApplication(cfg)
 
 	if err != nil {
 		//log.Fatal("An error ocurred instanciating the app")
@@ -31,18 +35,22 @@ func main() {
 
 	defer app.DB.Close()
 
+	//la purga de la papelera corre en background mientras el server este arriba
+	go app.PurgeTrash(context.Background())
+
 	//primero registramos todos los handlers, luego escuchamos con ListenAndServe
 	//de esta forma bindeamos paths con function handlers en nuestro server
 	routerHandler := routes.SetupRoutes(app)
 
 	server := &http.Server{
-		Addr:        fmt.Sprintf(":%d", port),
-		Handler:     routerHandler,
-		IdleTimeout: time.Minute,
-		ReadTimeout: 10 * time.Second,
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      routerHandler,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
-	app.Logger.Printf("App is up and listening on port %d", port)
+	app.Logger.Printf("App is up and listening on port %d", cfg.Port)
 
 	err = server.ListenAndServe()
 
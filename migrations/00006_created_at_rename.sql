@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE workouts RENAME COLUMN creted_at TO created_at;
+ALTER TABLE workout_entries RENAME COLUMN creted_at TO created_at;
+-- indices para la paginacion por keyset de GET /workouts
+CREATE INDEX IF NOT EXISTS idx_workouts_created_at ON workouts (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_workouts_duration ON workouts (duration_minutes, id);
+CREATE INDEX IF NOT EXISTS idx_workouts_calories ON workouts (calories_burned, id);
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_workouts_calories;
+DROP INDEX IF EXISTS idx_workouts_duration;
+DROP INDEX IF EXISTS idx_workouts_created_at;
+ALTER TABLE workout_entries RENAME COLUMN created_at TO creted_at;
+ALTER TABLE workouts RENAME COLUMN created_at TO creted_at;
@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE workouts ADD COLUMN visibility VARCHAR(20) NOT NULL DEFAULT 'private'
+    CHECK (visibility IN ('private', 'followers', 'public_link'));
+-- token del link para compartir, solo existe mientras visibility = 'public_link'
+ALTER TABLE workouts ADD COLUMN share_token VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workouts_share_token ON workouts (share_token);
+CREATE INDEX IF NOT EXISTS idx_workouts_user_id ON workouts (user_id);
+
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (follower_id, followee_id),
+    CONSTRAINT no_self_follow CHECK (follower_id <> followee_id)
+);
+-- +goose Down
+DROP TABLE IF EXISTS follows;
+DROP INDEX IF EXISTS idx_workouts_user_id;
+DROP INDEX IF EXISTS idx_workouts_share_token;
+ALTER TABLE workouts DROP COLUMN share_token;
+ALTER TABLE workouts DROP COLUMN visibility;
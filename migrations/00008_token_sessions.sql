@@ -0,0 +1,14 @@
+-- +goose Up
+-- los tokens de autenticacion son las sesiones del usuario: necesitan un id para poder
+-- cerrarlas de a una, y cuando y desde donde se abrieron para que el usuario las reconozca
+ALTER TABLE tokens ADD COLUMN id BIGSERIAL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_id ON tokens (id);
+ALTER TABLE tokens ADD COLUMN created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id_scope ON tokens (user_id, scope);
+-- +goose Down
+DROP INDEX IF EXISTS idx_tokens_user_id_scope;
+ALTER TABLE tokens DROP COLUMN user_agent;
+ALTER TABLE tokens DROP COLUMN created_at;
+DROP INDEX IF EXISTS idx_tokens_id;
+ALTER TABLE tokens DROP COLUMN id;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- family_id agrupa los tokens de una sesion (auth + refresh rotados), used_at marca los refresh ya rotados
+ALTER TABLE tokens ADD COLUMN family_id VARCHAR(64);
+ALTER TABLE tokens ADD COLUMN used_at TIMESTAMP(0) WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens (family_id);
+-- +goose Down
+DROP INDEX IF EXISTS idx_tokens_family_id;
+ALTER TABLE tokens DROP COLUMN used_at;
+ALTER TABLE tokens DROP COLUMN family_id;
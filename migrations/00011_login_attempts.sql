@@ -0,0 +1,11 @@
+-- +goose Up
+-- intentos de login fallidos por username ("user:<username>") y por IP ("ip:<ip>").
+-- No tiene FK a users: tambien se cuentan los intentos con usernames que no existen
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP(0) WITH TIME ZONE
+);
+-- +goose Down
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- totp_secret se guarda al empezar la activacion, totp_enabled_at cuando se confirma con un codigo
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP(0) WITH TIME ZONE;
+-- el paso del ultimo codigo TOTP aceptado. Un codigo de ese paso o de uno anterior ya no sirve,
+-- asi no se puede reusar un codigo mientras sigue en su ventana
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- codigos de recuperacion de un solo uso, hasheados como los tokens. Se borran al usarlos
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    hash BYTEA PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+-- +goose Down
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled_at;
+ALTER TABLE users DROP COLUMN totp_secret;
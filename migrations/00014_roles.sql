@@ -0,0 +1,7 @@
+-- +goose Up
+-- role es user, coach o admin. suspended_at NULL es que la cuenta esta activa
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'coach', 'admin'));
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP(0) WITH TIME ZONE;
+-- +goose Down
+ALTER TABLE users DROP COLUMN suspended_at;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- relacion coach-atleta. La crea el coach como invitacion y queda pendiente (accepted_at NULL)
+-- hasta que el atleta la acepta. El atleta la puede borrar en cualquier momento
+CREATE TABLE IF NOT EXISTS coachings (
+    coach_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    athlete_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    accepted_at TIMESTAMP(0) WITH TIME ZONE,
+    PRIMARY KEY (coach_id, athlete_id),
+    CONSTRAINT no_self_coaching CHECK (coach_id <> athlete_id)
+);
+CREATE INDEX IF NOT EXISTS idx_coachings_athlete_id ON coachings (athlete_id);
+
+CREATE TABLE IF NOT EXISTS workout_notes (
+    id BIGSERIAL PRIMARY KEY,
+    workout_id BIGINT NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_workout_notes_workout_id ON workout_notes (workout_id);
+-- +goose Down
+DROP TABLE IF EXISTS workout_notes;
+DROP TABLE IF EXISTS coachings;
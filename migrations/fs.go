@@ -4,3 +4,9 @@ import "embed"
 
 //go:embed *.sql
 var FS embed.FS
+
+// las migraciones de sqlite viven en su propio directorio porque algunos tipos
+// (BIGSERIAL, BYTEA, TIMESTAMP WITH TIME ZONE) no existen en sqlite
+//
+//go:embed sqlite/*.sql
+var SQLiteFS embed.FS
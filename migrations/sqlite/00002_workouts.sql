@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- en sqlite no se puede agregar una columna NOT NULL con foreign key despues de creada
+-- la tabla, asi que user_id (00005 en postgres) se crea aca
+CREATE TABLE IF NOT EXISTS workouts (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    duration_minutes INTEGER NOT NULL,
+    calories_burned INTEGER,
+    creted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+-- +goose Down
+
+-- +goose StatementBegin
+DROP TABLE workouts;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+-- +goose Up
+-- workouts.user_id ya se crea en 00002, este archivo solo mantiene alineada
+-- la numeracion con las migraciones de postgres
+SELECT 1;
+-- +goose Down
+SELECT 1;
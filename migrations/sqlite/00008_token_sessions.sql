@@ -0,0 +1,25 @@
+-- +goose Up
+-- sqlite no puede agregar una columna autoincremental con ALTER TABLE, asi que se recrea la tabla
+CREATE TABLE tokens_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hash BLOB NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expiry DATETIME NOT NULL,
+    scope TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    user_agent TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO tokens_new (hash, user_id, expiry, scope) SELECT hash, user_id, expiry, scope FROM tokens;
+DROP TABLE tokens;
+ALTER TABLE tokens_new RENAME TO tokens;
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id_scope ON tokens (user_id, scope);
+-- +goose Down
+CREATE TABLE tokens_old (
+    hash BLOB PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expiry DATETIME NOT NULL,
+    scope TEXT NOT NULL
+);
+INSERT INTO tokens_old (hash, user_id, expiry, scope) SELECT hash, user_id, expiry, scope FROM tokens;
+DROP TABLE tokens;
+ALTER TABLE tokens_old RENAME TO tokens;
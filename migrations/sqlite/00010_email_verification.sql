@@ -0,0 +1,7 @@
+-- +goose Up
+-- NULL hasta que el usuario confirma su email con el token de activacion.
+-- Los usuarios que ya existian se dan por verificados para no dejarlos afuera
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
+-- +goose Down
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- role es user, coach o admin. suspended_at NULL es que la cuenta esta activa
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'coach', 'admin'));
+ALTER TABLE users ADD COLUMN suspended_at DATETIME;
+-- +goose Down
+ALTER TABLE users DROP COLUMN suspended_at;
+ALTER TABLE users DROP COLUMN role;
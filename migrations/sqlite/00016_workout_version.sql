@@ -0,0 +1,5 @@
+-- +goose Up
+-- version sube en cada cambio del workout o de sus entries, es el ETag de la api
+ALTER TABLE workouts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+-- +goose Down
+ALTER TABLE workouts DROP COLUMN version;
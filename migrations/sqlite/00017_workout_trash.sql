@@ -0,0 +1,8 @@
+-- +goose Up
+-- los workouts borrados quedan en la papelera (deleted_at no es NULL) hasta que se restauran
+-- o la purga los borra para siempre
+ALTER TABLE workouts ADD COLUMN deleted_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_workouts_deleted_at ON workouts (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose Down
+DROP INDEX IF EXISTS idx_workouts_deleted_at;
+ALTER TABLE workouts DROP COLUMN deleted_at;